@@ -0,0 +1,104 @@
+package myamqp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidBlobKey = errors.New("invalid blob key")
+)
+
+// BlobStore stores message bodies offloaded by a ClaimCheck.
+type BlobStore interface {
+	// Put stores the body and returns the key referencing it.
+	Put(ctx context.Context, body []byte) (key string, err error)
+	// Get returns the body referenced by the key.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the body referenced by the key.
+	Delete(ctx context.Context, key string) error
+}
+
+// FileBlobStore is a BlobStore that keeps bodies as files in a directory.
+// The directory must be shared by producers and consumers, e.g. a network file system.
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore creates a new FileBlobStore with the given directory, creating it if needed.
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileBlobStore{
+		dir: dir,
+	}, nil
+}
+
+// Put writes the body to a new file and returns its key.
+func (f *FileBlobStore) Put(_ context.Context, body []byte) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(id)
+
+	// Write to a temporary file and rename it, so a consumer never reads a partially written body.
+	tmp, err := os.CreateTemp(f.dir, key+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if err = os.Rename(tmp.Name(), filepath.Join(f.dir, key)); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// Get reads the body of the key.
+func (f *FileBlobStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+// Delete removes the file of the key. Deleting a missing key is not an error.
+func (f *FileBlobStore) Delete(_ context.Context, key string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// path returns the file path of the key, rejecting keys which are not generated by Put.
+func (f *FileBlobStore) path(key string) (string, error) {
+	if _, err := hex.DecodeString(key); err != nil || len(key) != 32 {
+		return "", ErrInvalidBlobKey
+	}
+
+	return filepath.Join(f.dir, key), nil
+}
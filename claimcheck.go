@@ -0,0 +1,195 @@
+package myamqp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrBlobStoreCannotBeNil = errors.New("blob store cannot be nil")
+)
+
+const (
+	// ClaimCheckHeader is the header carrying the BlobStore key of an offloaded body.
+	ClaimCheckHeader = "x-claim-check"
+	// ClaimCheckSizeHeader is the header carrying the size of an offloaded body.
+	ClaimCheckSizeHeader = "x-claim-check-size"
+)
+
+// ClaimCheck represents options for offloading large message bodies to a BlobStore.
+// Producers publish a reference message instead of the body, consumers resolve the reference
+// before the handler sees the delivery.
+type ClaimCheck struct {
+	store       BlobStore
+	threshold   int
+	deleteOnAck bool
+}
+
+// NewClaimCheck creates a new ClaimCheck with the given BlobStore and threshold.
+// Only bodies of at least threshold bytes are offloaded. Offloaded bodies are deleted once the delivery is acked,
+// or nacked or rejected without requeue, and when the producer fails to publish the reference message.
+func NewClaimCheck(store BlobStore, threshold int) *ClaimCheck {
+	return &ClaimCheck{
+		store:       store,
+		threshold:   threshold,
+		deleteOnAck: true,
+	}
+}
+
+// WithDeleteOnAck sets the deleteOnAck on the ClaimCheck.
+// Disable it when the reference message is routed to more than one queue, or dead-lettered by the AMQP server
+// to a queue whose consumers resolve it too.
+func (c *ClaimCheck) WithDeleteOnAck(deleteOnAck bool) *ClaimCheck {
+	c.deleteOnAck = deleteOnAck
+	return c
+}
+
+func (c *ClaimCheck) validate() error {
+	if c.store == nil {
+		return ErrBlobStoreCannotBeNil
+	}
+
+	return nil
+}
+
+// offload stores the body of the message in the BlobStore when it reaches the threshold
+// and returns the reference message, and the key of the stored body, empty when it is not offloaded.
+func (c *ClaimCheck) offload(ctx context.Context, msg amqp091.Publishing) (amqp091.Publishing, string, error) {
+	if len(msg.Body) < c.threshold {
+		return msg, "", nil
+	}
+
+	key, err := c.store.Put(ctx, msg.Body)
+	if err != nil {
+		return msg, "", err
+	}
+
+	headers := amqp091.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[ClaimCheckHeader] = key
+	headers[ClaimCheckSizeHeader] = int64(len(msg.Body))
+
+	msg.Headers = headers
+	msg.Body = nil
+
+	return msg, key, nil
+}
+
+// resolveDeliveries forwards the deliveries with offloaded bodies read back from the BlobStore.
+// Deliveries which cannot be resolved are rejected without requeue, unless autoAck is set, and never reach the handler.
+func (c *ClaimCheck) resolveDeliveries(logger *slog.Logger, deliveries <-chan amqp091.Delivery, autoAck bool) <-chan amqp091.Delivery {
+	tracker := &claimCheckTracker{store: c.store, keys: make(map[uint64]string)}
+	resolved := make(chan amqp091.Delivery)
+	go func() {
+		defer close(resolved)
+		for d := range deliveries {
+			key, ok := d.Headers[ClaimCheckHeader].(string)
+			if !ok {
+				resolved <- d
+				continue
+			}
+
+			body, err := c.store.Get(context.Background(), key)
+			if err != nil {
+				logger.Warn("claim check body read failed, rejecting", "key", key, "error", err)
+				if !autoAck {
+					d.Reject(false)
+				}
+				continue
+			}
+			d.Body = body
+
+			if c.deleteOnAck {
+				if autoAck {
+					// The delivery is acked already, nothing refers to the body anymore.
+					c.store.Delete(context.Background(), key)
+				} else {
+					tracker.track(d.DeliveryTag, key)
+					d.Acknowledger = &claimCheckAcknowledger{
+						Acknowledger: d.Acknowledger,
+						tracker:      tracker,
+					}
+				}
+			}
+
+			resolved <- d
+		}
+	}()
+
+	return resolved
+}
+
+// claimCheckTracker keeps the keys of the resolved deliveries of a channel by delivery tag,
+// so a multiple ack deletes the bodies of all the deliveries it settles.
+type claimCheckTracker struct {
+	store BlobStore
+
+	mu   sync.Mutex
+	keys map[uint64]string
+}
+
+func (t *claimCheckTracker) track(tag uint64, key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keys[tag] = key
+}
+
+// settle forgets the keys of the deliveries settled by the tag, and deletes their bodies unless they are requeued.
+func (t *claimCheckTracker) settle(tag uint64, multiple, requeue bool) error {
+	t.mu.Lock()
+	var keys []string
+	for settled, key := range t.keys {
+		if settled == tag || (multiple && settled < tag) {
+			keys = append(keys, key)
+			delete(t.keys, settled)
+		}
+	}
+	t.mu.Unlock()
+
+	// A requeued delivery is resolved again, with a new delivery tag, when it is redelivered.
+	if requeue {
+		return nil
+	}
+
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, t.store.Delete(context.Background(), key))
+	}
+
+	return errors.Join(errs...)
+}
+
+type claimCheckAcknowledger struct {
+	amqp091.Acknowledger
+	tracker *claimCheckTracker
+}
+
+func (a *claimCheckAcknowledger) Ack(tag uint64, multiple bool) error {
+	if err := a.Acknowledger.Ack(tag, multiple); err != nil {
+		return err
+	}
+
+	return a.tracker.settle(tag, multiple, false)
+}
+
+func (a *claimCheckAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	if err := a.Acknowledger.Nack(tag, multiple, requeue); err != nil {
+		return err
+	}
+
+	return a.tracker.settle(tag, multiple, requeue)
+}
+
+func (a *claimCheckAcknowledger) Reject(tag uint64, requeue bool) error {
+	if err := a.Acknowledger.Reject(tag, requeue); err != nil {
+		return err
+	}
+
+	return a.tracker.settle(tag, false, requeue)
+}
@@ -0,0 +1,179 @@
+package myamqp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, collecting the logs of the consumer goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newClaimCheckStore returns a FileBlobStore in a temporary directory.
+func newClaimCheckStore(t *testing.T) (*myamqp.FileBlobStore, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	store, err := myamqp.NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileBlobStore: %v", err)
+	}
+	return store, dir
+}
+
+// blobCount returns the number of bodies in the FileBlobStore directory.
+func blobCount(t *testing.T, dir string) int {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	return len(entries)
+}
+
+// publishClaimChecked publishes the bodies to the orders queue with a producer offloading bodies of at least 16 bytes.
+func publishClaimChecked(t *testing.T, amqp *myamqp.MyAMQP, claimCheck *myamqp.ClaimCheck, bodies ...string) {
+	t.Helper()
+
+	producer, err := amqp.Producer(myamqp.NewProducerOptions(defaultExchange()).
+		WithQueueOptions(myamqp.NewQueueOptions("orders").WithSkipBind(true)).
+		WithClaimCheck(claimCheck))
+	if err != nil {
+		t.Fatalf("Producer: %v", err)
+	}
+	defer producer.Close()
+
+	for _, body := range bodies {
+		if err = producer.Publish(context.Background(), "orders", false, false, amqp091.Publishing{Body: []byte(body)}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+func TestClaimCheckRoundTrip(t *testing.T) {
+	store, dir := newClaimCheckStore(t)
+	claimCheck := myamqp.NewClaimCheck(store, 16)
+	broker := newBroker(t)
+	amqp := connect(t, broker)
+
+	large := strings.Repeat("order ", 10)
+	publishClaimChecked(t, amqp, claimCheck, large, "small")
+
+	messages := broker.Messages("orders")
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(messages))
+	}
+	if _, ok := messages[0].Headers[myamqp.ClaimCheckHeader].(string); !ok || len(messages[0].Body) != 0 ||
+		messages[0].Headers[myamqp.ClaimCheckSizeHeader] != int64(len(large)) {
+		t.Errorf("large body is not offloaded: %+v", messages[0])
+	}
+	if _, ok := messages[1].Headers[myamqp.ClaimCheckHeader]; ok || string(messages[1].Body) != "small" {
+		t.Errorf("small body is offloaded: %+v", messages[1])
+	}
+	if got := blobCount(t, dir); got != 1 {
+		t.Fatalf("got %d stored bodies, want 1", got)
+	}
+
+	deliveries := consume(t, amqp, myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")).WithClaimCheck(claimCheck))
+	first, second := receive(t, deliveries), receive(t, deliveries)
+	if string(first.Body) != large || string(second.Body) != "small" {
+		t.Fatalf("got bodies %q, %q", first.Body, second.Body)
+	}
+
+	// A requeued delivery keeps its body, it is resolved again on redelivery.
+	first.Nack(false, true)
+	redelivered := receive(t, deliveries)
+	if string(redelivered.Body) != large || blobCount(t, dir) != 1 {
+		t.Fatalf("requeued body is not resolved again: %q", redelivered.Body)
+	}
+
+	// A multiple ack deletes the bodies of all the deliveries it settles.
+	redelivered.Ack(true)
+	waitFor(t, "the body to be deleted", func() bool { return blobCount(t, dir) == 0 })
+}
+
+func TestClaimCheckWithoutDeleteOnAck(t *testing.T) {
+	store, dir := newClaimCheckStore(t)
+	claimCheck := myamqp.NewClaimCheck(store, 0).WithDeleteOnAck(false)
+	amqp := connect(t, newBroker(t))
+	publishClaimChecked(t, amqp, claimCheck, "order")
+
+	d := receive(t, consume(t, amqp, myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")).WithClaimCheck(claimCheck)))
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if got := blobCount(t, dir); got != 1 {
+		t.Errorf("got %d stored bodies, want the body kept", got)
+	}
+}
+
+func TestClaimCheckMissingBody(t *testing.T) {
+	store, dir := newClaimCheckStore(t)
+	claimCheck := myamqp.NewClaimCheck(store, 0)
+	logs := &syncBuffer{}
+	broker := newBroker(t)
+	amqp := connect(t, broker, func(c *myamqp.Config) *myamqp.Config {
+		return c.WithLogger(slog.New(slog.NewTextHandler(logs, nil)))
+	})
+	publishClaimChecked(t, amqp, claimCheck, "lost", "kept")
+
+	// Remove the body of the first message, its delivery is rejected and never reaches the handler.
+	key := broker.Messages("orders")[0].Headers[myamqp.ClaimCheckHeader].(string)
+	if err := os.Remove(filepath.Join(dir, key)); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	d := receive(t, consume(t, amqp, myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")).WithClaimCheck(claimCheck)))
+	if string(d.Body) != "kept" {
+		t.Fatalf("got body %q, want the second message", d.Body)
+	}
+	d.Ack(false)
+	waitFor(t, "the unresolved delivery to be rejected", func() bool {
+		info, _ := broker.Queue("orders")
+		return info.Messages == 0 && info.Unacked == 0
+	})
+	if !strings.Contains(logs.String(), "key="+key) {
+		t.Errorf("the failed read of %s is not logged: %s", key, logs)
+	}
+}
+
+func TestClaimCheckInvalid(t *testing.T) {
+	amqp := connect(t, newBroker(t))
+
+	_, err := amqp.Producer(myamqp.NewProducerOptions(defaultExchange()).WithClaimCheck(myamqp.NewClaimCheck(nil, 0)))
+	if !errors.Is(err, myamqp.ErrBlobStoreCannotBeNil) {
+		t.Errorf("Producer: got error %v, want %v", err, myamqp.ErrBlobStoreCannotBeNil)
+	}
+
+	store, _ := newClaimCheckStore(t)
+	for _, key := range []string{"../secret", "not-hex", ""} {
+		if _, err = store.Get(context.Background(), key); !errors.Is(err, myamqp.ErrInvalidBlobKey) {
+			t.Errorf("Get %q: got error %v, want %v", key, err, myamqp.ErrInvalidBlobKey)
+		}
+	}
+}
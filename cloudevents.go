@@ -0,0 +1,316 @@
+package myamqp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrInvalidCloudEvent = errors.New("invalid cloud event")
+	ErrNotCloudEvent     = errors.New("delivery is not a cloud event")
+)
+
+// CloudEventsMode represents the content mode of the CloudEvents AMQP binding.
+type CloudEventsMode int
+
+const (
+	// CloudEventsModeBinary carries the attributes as cloudEvents:-prefixed application properties
+	// and the event data as the message body.
+	CloudEventsModeBinary CloudEventsMode = iota
+	// CloudEventsModeStructured carries the whole event as an application/cloudevents+json body.
+	CloudEventsModeStructured
+)
+
+const (
+	CloudEventsSpecVersion     = "1.0"
+	ContentTypeCloudEventsJSON = "application/cloudevents+json"
+	cloudEventsPrefix          = "cloudEvents:"
+	cloudEventsLegacyPrefix    = "cloudEvents_"
+	cloudEventsAttrSpecVersion = "specversion"
+	cloudEventsAttrID          = "id"
+	cloudEventsAttrSource      = "source"
+	cloudEventsAttrType        = "type"
+	cloudEventsAttrSubject     = "subject"
+	cloudEventsAttrTime        = "time"
+	cloudEventsAttrDataSchema  = "dataschema"
+	cloudEventsAttrContentType = "datacontenttype"
+	cloudEventsAttrData        = "data"
+	cloudEventsAttrDataBase64  = "data_base64"
+)
+
+// CloudEvent represents a CloudEvents 1.0 event.
+type CloudEvent struct {
+	ID              string
+	Source          string
+	SpecVersion     string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataSchema      string
+	DataContentType string
+	Data            []byte
+	// Extensions holds the extension attributes, keyed by their lowercase name.
+	Extensions map[string]interface{}
+}
+
+// NewCloudEvent creates a new CloudEvent with the given id, source and type.
+func NewCloudEvent(id, source, eventType string) CloudEvent {
+	return CloudEvent{
+		ID:          id,
+		Source:      source,
+		SpecVersion: CloudEventsSpecVersion,
+		Type:        eventType,
+	}
+}
+
+// Validate checks the CloudEvent for the required attributes.
+func (e CloudEvent) Validate() error {
+	switch {
+	case e.SpecVersion != CloudEventsSpecVersion:
+		return fmt.Errorf("%w: unsupported specversion %q", ErrInvalidCloudEvent, e.SpecVersion)
+	case e.ID == "":
+		return fmt.Errorf("%w: id is required", ErrInvalidCloudEvent)
+	case e.Source == "":
+		return fmt.Errorf("%w: source is required", ErrInvalidCloudEvent)
+	case e.Type == "":
+		return fmt.Errorf("%w: type is required", ErrInvalidCloudEvent)
+	}
+
+	for name := range e.Extensions {
+		if name == "" || strings.ToLower(name) != name {
+			return fmt.Errorf("%w: extension name %q must be lowercase", ErrInvalidCloudEvent, name)
+		}
+	}
+
+	return nil
+}
+
+// NewCloudEventPublishing creates a Publishing carrying the CloudEvent in the given mode.
+func NewCloudEventPublishing(event CloudEvent, mode CloudEventsMode) (amqp091.Publishing, error) {
+	if err := event.Validate(); err != nil {
+		return amqp091.Publishing{}, err
+	}
+
+	if mode == CloudEventsModeStructured {
+		body, err := marshalStructuredCloudEvent(event)
+		if err != nil {
+			return amqp091.Publishing{}, err
+		}
+
+		return amqp091.Publishing{
+			ContentType: ContentTypeCloudEventsJSON,
+			MessageId:   event.ID,
+			Timestamp:   event.Time,
+			Type:        event.Type,
+			Body:        body,
+		}, nil
+	}
+
+	headers := amqp091.Table{}
+	for name, value := range event.attributes() {
+		headers[cloudEventsPrefix+name] = value
+	}
+
+	return amqp091.Publishing{
+		Headers:     headers,
+		ContentType: event.DataContentType,
+		MessageId:   event.ID,
+		Timestamp:   event.Time,
+		Type:        event.Type,
+		Body:        event.Data,
+	}, nil
+}
+
+// ParseCloudEvent parses the CloudEvent carried by the delivery, in either binary or structured mode.
+// It returns ErrNotCloudEvent when the delivery carries no event.
+func ParseCloudEvent(d amqp091.Delivery) (CloudEvent, error) {
+	if mediaType, _, _ := mime.ParseMediaType(d.ContentType); mediaType == ContentTypeCloudEventsJSON {
+		return unmarshalStructuredCloudEvent(d.Body)
+	}
+
+	attrs := make(map[string]interface{})
+	for key, value := range d.Headers {
+		switch {
+		case strings.HasPrefix(key, cloudEventsPrefix):
+			attrs[strings.TrimPrefix(key, cloudEventsPrefix)] = value
+		case strings.HasPrefix(key, cloudEventsLegacyPrefix):
+			attrs[strings.TrimPrefix(key, cloudEventsLegacyPrefix)] = value
+		}
+	}
+
+	if _, ok := attrs[cloudEventsAttrSpecVersion]; !ok {
+		return CloudEvent{}, ErrNotCloudEvent
+	}
+
+	// In binary mode the datacontenttype is carried by the AMQP content-type property.
+	if d.ContentType != "" {
+		attrs[cloudEventsAttrContentType] = d.ContentType
+	}
+
+	event, err := cloudEventFromAttributes(attrs)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	event.Data = d.Body
+
+	return event, event.Validate()
+}
+
+// attributes returns the context attributes and extensions of the CloudEvent, except datacontenttype.
+func (e CloudEvent) attributes() map[string]interface{} {
+	attrs := make(map[string]interface{}, len(e.Extensions)+7)
+	for name, value := range e.Extensions {
+		attrs[name] = value
+	}
+
+	attrs[cloudEventsAttrSpecVersion] = e.SpecVersion
+	attrs[cloudEventsAttrID] = e.ID
+	attrs[cloudEventsAttrSource] = e.Source
+	attrs[cloudEventsAttrType] = e.Type
+	if e.Subject != "" {
+		attrs[cloudEventsAttrSubject] = e.Subject
+	}
+	if !e.Time.IsZero() {
+		attrs[cloudEventsAttrTime] = e.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if e.DataSchema != "" {
+		attrs[cloudEventsAttrDataSchema] = e.DataSchema
+	}
+
+	return attrs
+}
+
+func cloudEventFromAttributes(attrs map[string]interface{}) (CloudEvent, error) {
+	var event CloudEvent
+	for name, value := range attrs {
+		str, isString := value.(string)
+		switch name {
+		case cloudEventsAttrSpecVersion:
+			event.SpecVersion = str
+		case cloudEventsAttrID:
+			event.ID = str
+		case cloudEventsAttrSource:
+			event.Source = str
+		case cloudEventsAttrType:
+			event.Type = str
+		case cloudEventsAttrSubject:
+			event.Subject = str
+		case cloudEventsAttrDataSchema:
+			event.DataSchema = str
+		case cloudEventsAttrContentType:
+			event.DataContentType = str
+		case cloudEventsAttrTime:
+			switch t := value.(type) {
+			case time.Time:
+				event.Time = t
+			case string:
+				parsed, err := time.Parse(time.RFC3339Nano, t)
+				if err != nil {
+					return CloudEvent{}, fmt.Errorf("%w: time: %v", ErrInvalidCloudEvent, err)
+				}
+				event.Time = parsed
+			}
+		default:
+			if event.Extensions == nil {
+				event.Extensions = make(map[string]interface{})
+			}
+			event.Extensions[name] = value
+		}
+
+		if !isString && name != cloudEventsAttrTime && isRequiredCloudEventAttr(name) {
+			return CloudEvent{}, fmt.Errorf("%w: %s must be a string", ErrInvalidCloudEvent, name)
+		}
+	}
+
+	return event, nil
+}
+
+func isRequiredCloudEventAttr(name string) bool {
+	switch name {
+	case cloudEventsAttrSpecVersion, cloudEventsAttrID, cloudEventsAttrSource, cloudEventsAttrType:
+		return true
+	}
+	return false
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == ContentTypeJSON || strings.HasSuffix(mediaType, "+json")
+}
+
+func marshalStructuredCloudEvent(event CloudEvent) ([]byte, error) {
+	envelope := event.attributes()
+	if event.DataContentType != "" {
+		envelope[cloudEventsAttrContentType] = event.DataContentType
+	}
+
+	if event.Data != nil {
+		if (event.DataContentType == "" || isJSONContentType(event.DataContentType)) && json.Valid(event.Data) {
+			envelope[cloudEventsAttrData] = json.RawMessage(event.Data)
+		} else {
+			envelope[cloudEventsAttrDataBase64] = base64.StdEncoding.EncodeToString(event.Data)
+		}
+	}
+
+	return json.Marshal(envelope)
+}
+
+func unmarshalStructuredCloudEvent(body []byte) (CloudEvent, error) {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return CloudEvent{}, fmt.Errorf("%w: %v", ErrInvalidCloudEvent, err)
+	}
+
+	attrs := make(map[string]interface{}, len(envelope))
+	var data []byte
+	for name, raw := range envelope {
+		switch name {
+		case cloudEventsAttrData:
+			data = raw
+			// String data of a non-JSON content type is carried as a JSON string.
+			var str string
+			if json.Unmarshal(raw, &str) == nil {
+				if ct, ok := envelope[cloudEventsAttrContentType]; ok {
+					var contentType string
+					if json.Unmarshal(ct, &contentType) == nil && !isJSONContentType(contentType) {
+						data = []byte(str)
+					}
+				}
+			}
+		case cloudEventsAttrDataBase64:
+			var encoded string
+			if err := json.Unmarshal(raw, &encoded); err != nil {
+				return CloudEvent{}, fmt.Errorf("%w: data_base64: %v", ErrInvalidCloudEvent, err)
+			}
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return CloudEvent{}, fmt.Errorf("%w: data_base64: %v", ErrInvalidCloudEvent, err)
+			}
+			data = decoded
+		default:
+			var value interface{}
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return CloudEvent{}, fmt.Errorf("%w: %s: %v", ErrInvalidCloudEvent, name, err)
+			}
+			attrs[name] = value
+		}
+	}
+
+	event, err := cloudEventFromAttributes(attrs)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	event.Data = data
+
+	return event, event.Validate()
+}
@@ -0,0 +1,203 @@
+package myamqp_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestCloudEventRoundTrip(t *testing.T) {
+	eventTime := time.Date(2024, 5, 1, 12, 30, 0, 123000000, time.UTC)
+
+	tests := []struct {
+		name        string
+		mode        myamqp.CloudEventsMode
+		contentType string
+		data        []byte
+	}{
+		{name: "binary json", mode: myamqp.CloudEventsModeBinary, contentType: myamqp.ContentTypeJSON, data: []byte(`{"id":1}`)},
+		{name: "binary bytes", mode: myamqp.CloudEventsModeBinary, contentType: "application/octet-stream", data: []byte{0, 1, 2}},
+		{name: "structured json", mode: myamqp.CloudEventsModeStructured, contentType: myamqp.ContentTypeJSON, data: []byte(`{"id":1}`)},
+		{name: "structured text", mode: myamqp.CloudEventsModeStructured, contentType: "text/plain", data: []byte("order created")},
+		{name: "structured bytes", mode: myamqp.CloudEventsModeStructured, contentType: "application/octet-stream", data: []byte{0, 1, 2}},
+		{name: "structured without data", mode: myamqp.CloudEventsModeStructured},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newBroker(t)
+			amqp := connect(t, broker)
+			deliveries := consume(t, amqp, myamqp.NewQueueConsumerOptions("events", myamqp.NewQueueOptions("events")))
+
+			event := myamqp.NewCloudEvent("1", "/orders", "order.created")
+			event.Subject = "order-1"
+			event.Time = eventTime
+			event.DataSchema = "https://example.com/order.json"
+			event.DataContentType = tt.contentType
+			event.Data = tt.data
+			event.Extensions = map[string]interface{}{"tenant": "acme"}
+
+			publishing, err := myamqp.NewCloudEventPublishing(event, tt.mode)
+			if err != nil {
+				t.Fatalf("NewCloudEventPublishing: %v", err)
+			}
+			if err = broker.Publish("", "events", publishing); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+
+			got, err := myamqp.ParseCloudEvent(receive(t, deliveries))
+			if err != nil {
+				t.Fatalf("ParseCloudEvent: %v", err)
+			}
+			if got.ID != event.ID || got.Source != event.Source || got.Type != event.Type || got.SpecVersion != event.SpecVersion ||
+				got.Subject != event.Subject || !got.Time.Equal(eventTime) || got.DataSchema != event.DataSchema ||
+				got.DataContentType != event.DataContentType {
+				t.Errorf("got %+v, want %+v", got, event)
+			}
+			if !bytes.Equal(got.Data, tt.data) {
+				t.Errorf("data: got %q, want %q", got.Data, tt.data)
+			}
+			if got.Extensions["tenant"] != "acme" {
+				t.Errorf("extensions: got %v", got.Extensions)
+			}
+		})
+	}
+}
+
+func TestParseCloudEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		delivery amqp091.Delivery
+		wantID   string
+		wantData string
+		wantErr  error
+	}{
+		{
+			name: "legacy prefix",
+			delivery: amqp091.Delivery{
+				Headers: amqp091.Table{
+					"cloudEvents_specversion": "1.0",
+					"cloudEvents_id":          "1",
+					"cloudEvents_source":      "/orders",
+					"cloudEvents_type":        "order.created",
+				},
+				Body: []byte("data"),
+			},
+			wantID:   "1",
+			wantData: "data",
+		},
+		{
+			name: "structured base64 data",
+			delivery: amqp091.Delivery{
+				ContentType: "application/cloudevents+json; charset=utf-8",
+				Body:        []byte(`{"specversion":"1.0","id":"2","source":"/orders","type":"order.created","data_base64":"ZGF0YQ=="}`),
+			},
+			wantID:   "2",
+			wantData: "data",
+		},
+		{
+			name:     "plain message",
+			delivery: amqp091.Delivery{ContentType: myamqp.ContentTypeJSON, Body: []byte(`{"id":1}`)},
+			wantErr:  myamqp.ErrNotCloudEvent,
+		},
+		{
+			name: "missing source",
+			delivery: amqp091.Delivery{Headers: amqp091.Table{
+				"cloudEvents:specversion": "1.0",
+				"cloudEvents:id":          "1",
+				"cloudEvents:type":        "order.created",
+			}},
+			wantErr: myamqp.ErrInvalidCloudEvent,
+		},
+		{
+			name: "unsupported specversion",
+			delivery: amqp091.Delivery{Headers: amqp091.Table{
+				"cloudEvents:specversion": "0.3",
+				"cloudEvents:id":          "1",
+				"cloudEvents:source":      "/orders",
+				"cloudEvents:type":        "order.created",
+			}},
+			wantErr: myamqp.ErrInvalidCloudEvent,
+		},
+		{
+			name: "id not a string",
+			delivery: amqp091.Delivery{Headers: amqp091.Table{
+				"cloudEvents:specversion": "1.0",
+				"cloudEvents:id":          int32(1),
+				"cloudEvents:source":      "/orders",
+				"cloudEvents:type":        "order.created",
+			}},
+			wantErr: myamqp.ErrInvalidCloudEvent,
+		},
+		{
+			name: "invalid time",
+			delivery: amqp091.Delivery{Headers: amqp091.Table{
+				"cloudEvents:specversion": "1.0",
+				"cloudEvents:id":          "1",
+				"cloudEvents:source":      "/orders",
+				"cloudEvents:type":        "order.created",
+				"cloudEvents:time":        "yesterday",
+			}},
+			wantErr: myamqp.ErrInvalidCloudEvent,
+		},
+		{
+			name:     "invalid structured body",
+			delivery: amqp091.Delivery{ContentType: myamqp.ContentTypeCloudEventsJSON, Body: []byte("not json")},
+			wantErr:  myamqp.ErrInvalidCloudEvent,
+		},
+		{
+			name: "invalid base64 data",
+			delivery: amqp091.Delivery{
+				ContentType: myamqp.ContentTypeCloudEventsJSON,
+				Body:        []byte(`{"specversion":"1.0","id":"2","source":"/orders","type":"order.created","data_base64":"!"}`),
+			},
+			wantErr: myamqp.ErrInvalidCloudEvent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := myamqp.ParseCloudEvent(tt.delivery)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseCloudEvent: got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (event.ID != tt.wantID || string(event.Data) != tt.wantData) {
+				t.Errorf("got event %+v", event)
+			}
+		})
+	}
+}
+
+func TestNewCloudEventPublishingInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		event myamqp.CloudEvent
+	}{
+		{name: "missing id", event: myamqp.NewCloudEvent("", "/orders", "order.created")},
+		{name: "missing source", event: myamqp.NewCloudEvent("1", "", "order.created")},
+		{name: "missing type", event: myamqp.NewCloudEvent("1", "/orders", "")},
+		{name: "missing specversion", event: myamqp.CloudEvent{ID: "1", Source: "/orders", Type: "order.created"}},
+		{
+			name: "uppercase extension",
+			event: func() myamqp.CloudEvent {
+				event := myamqp.NewCloudEvent("1", "/orders", "order.created")
+				event.Extensions = map[string]interface{}{"Tenant": "acme"}
+				return event
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []myamqp.CloudEventsMode{myamqp.CloudEventsModeBinary, myamqp.CloudEventsModeStructured} {
+				if _, err := myamqp.NewCloudEventPublishing(tt.event, mode); !errors.Is(err, myamqp.ErrInvalidCloudEvent) {
+					t.Errorf("mode %d: got error %v, want %v", mode, err, myamqp.ErrInvalidCloudEvent)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Consume modes, how the consumed deliveries are settled.
+const (
+	modeAck     = "ack"
+	modeNack    = "nack"
+	modeRequeue = "requeue"
+	modeReject  = "reject"
+	modeAutoAck = "autoack"
+)
+
+// deliveryJSON is the JSON line printed for each delivery.
+type deliveryJSON struct {
+	Exchange        string        `json:"exchange"`
+	RoutingKey      string        `json:"routing_key"`
+	Redelivered     bool          `json:"redelivered"`
+	DeliveryTag     uint64        `json:"delivery_tag"`
+	ContentType     string        `json:"content_type,omitempty"`
+	ContentEncoding string        `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8         `json:"delivery_mode,omitempty"`
+	Priority        uint8         `json:"priority,omitempty"`
+	CorrelationID   string        `json:"correlation_id,omitempty"`
+	ReplyTo         string        `json:"reply_to,omitempty"`
+	Expiration      string        `json:"expiration,omitempty"`
+	MessageID       string        `json:"message_id,omitempty"`
+	Timestamp       *time.Time    `json:"timestamp,omitempty"`
+	Type            string        `json:"type,omitempty"`
+	UserID          string        `json:"user_id,omitempty"`
+	AppID           string        `json:"app_id,omitempty"`
+	Headers         amqp091.Table `json:"headers,omitempty"`
+	Body            *string       `json:"body,omitempty"`
+	BodyBase64      string        `json:"body_base64,omitempty"`
+}
+
+func runConsume(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("consume", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+
+	queue := fs.String("queue", "", "queue to consume from, required")
+	mode := fs.String("mode", modeAck, "how deliveries are settled: ack, nack, requeue, reject or autoack")
+	count := fs.Int("count", 0, "stop after this many deliveries, 0 for no limit")
+	prefetch := fs.Int("prefetch", 10, "prefetch count")
+	name := fs.String("name", fmt.Sprintf("myamqp-cli-%d", os.Getpid()), "consumer tag")
+	decompress := fs.Bool("decompress", true, "decompress bodies compressed by a producer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *queue == "" {
+		return errors.New("-queue is required")
+	}
+	switch *mode {
+	case modeAck, modeNack, modeRequeue, modeReject, modeAutoAck:
+	default:
+		return fmt.Errorf("unknown -mode %q", *mode)
+	}
+	// The consumer is cancelled by its tag, so it cannot be left to the client library.
+	if *name == "" {
+		return errors.New("-name must not be empty")
+	}
+	if *count < 0 {
+		return errors.New("-count must not be negative")
+	}
+	// The server pushes and acks autoack deliveries regardless of the prefetch, those past the count would be lost.
+	if *count > 0 && *mode == modeAutoAck {
+		return errors.New("-count cannot be used with -mode autoack")
+	}
+
+	amqp, err := conn.connect(ctx, func(config *myamqp.Config) *myamqp.Config {
+		return config.WithQos(myamqp.NewQos(*prefetch, 0, false))
+	})
+	if err != nil {
+		return err
+	}
+	defer amqp.Close()
+
+	limitReached := make(chan struct{})
+	closed := make(chan struct{})
+	enc := json.NewEncoder(os.Stdout)
+
+	handler := func(deliveries <-chan amqp091.Delivery, done chan error) {
+		var n int
+		var handleErr error
+		for d := range deliveries {
+			// Deliveries prefetched past the count are left to be requeued when the channel closes.
+			if (*count > 0 && n >= *count) || handleErr != nil {
+				continue
+			}
+
+			if err := enc.Encode(newDeliveryJSON(d)); err != nil {
+				handleErr = err
+				continue
+			}
+			if err := settle(d, *mode); err != nil {
+				handleErr = err
+				continue
+			}
+
+			n++
+			if *count > 0 && n == *count {
+				close(limitReached)
+			}
+		}
+		close(closed)
+		done <- handleErr
+	}
+
+	queueOpts := myamqp.NewQueueOptions(*queue).WithDeclareMode(myamqp.DeclareModePassive)
+	consumerOpts := myamqp.NewQueueConsumerOptions(*name, queueOpts).WithAutoAck(*mode == modeAutoAck)
+	if !*decompress {
+		consumerOpts = consumerOpts.WithDecompression(nil)
+	}
+
+	consumer, err := amqp.Consumer(consumerOpts, handler)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-limitReached:
+	case <-closed:
+		return errors.New("consumer closed by the server")
+	}
+
+	return consumer.Cancel()
+}
+
+// settle settles the delivery according to the consume mode.
+func settle(d amqp091.Delivery, mode string) error {
+	switch mode {
+	case modeAck:
+		return d.Ack(false)
+	case modeNack:
+		return d.Nack(false, false)
+	case modeRequeue:
+		return d.Nack(false, true)
+	case modeReject:
+		return d.Reject(false)
+	}
+	return nil
+}
+
+func newDeliveryJSON(d amqp091.Delivery) deliveryJSON {
+	out := deliveryJSON{
+		Exchange:        d.Exchange,
+		RoutingKey:      d.RoutingKey,
+		Redelivered:     d.Redelivered,
+		DeliveryTag:     d.DeliveryTag,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationID:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageID:       d.MessageId,
+		Type:            d.Type,
+		UserID:          d.UserId,
+		AppID:           d.AppId,
+		Headers:         d.Headers,
+	}
+	if !d.Timestamp.IsZero() {
+		out.Timestamp = &d.Timestamp
+	}
+
+	// Text bodies are printed as is, binary ones base64 encoded.
+	if utf8.Valid(d.Body) {
+		body := string(d.Body)
+		out.Body = &body
+	} else {
+		out.BodyBase64 = base64.StdEncoding.EncodeToString(d.Body)
+	}
+
+	return out
+}
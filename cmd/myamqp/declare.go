@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dmasior/myamqp"
+)
+
+func runDeclare(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("declare", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+
+	exchange := fs.String("exchange", "", "exchange to declare")
+	kind := fs.String("kind", "direct", "exchange kind: direct, fanout, topic or headers")
+	exchangeArgs := fs.String("exchange-args", "", "exchange arguments as a JSON object")
+	internal := fs.Bool("internal", false, "declare the exchange internal")
+	queue := fs.String("queue", "", "queue to declare, bound to the exchange when both are set")
+	routingKey := fs.String("routing-key", "", "binding routing key")
+	queueType := fs.String("queue-type", "", "queue type: classic, quorum or stream")
+	queueArgs := fs.String("args", "", "queue arguments as a JSON object, e.g. {\"x-max-length\":1000}")
+	exclusive := fs.Bool("exclusive", false, "declare the queue exclusive")
+	durable := fs.Bool("durable", true, "declare durable")
+	autoDelete := fs.Bool("auto-delete", false, "declare auto-deleted")
+	passive := fs.Bool("passive", false, "only check that the exchange and the queue exist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *exchange == "" && *queue == "" {
+		return errors.New("-exchange or -queue is required")
+	}
+
+	mode := myamqp.DeclareModeDeclare
+	if *passive {
+		mode = myamqp.DeclareModePassive
+	}
+
+	var exchangeOpts *myamqp.ExchangeOptions
+	if *exchange != "" {
+		eArgs, err := parseTable(*exchangeArgs)
+		if err != nil {
+			return fmt.Errorf("-exchange-args: %w", err)
+		}
+		exchangeOpts = myamqp.NewExchangeOptions(*exchange, *kind).
+			WithDurable(*durable).
+			WithAutoDelete(*autoDelete).
+			WithInternal(*internal).
+			WithArgs(eArgs).
+			WithDeclareMode(mode)
+	}
+
+	var queueOpts *myamqp.QueueOptions
+	if *queue != "" {
+		qArgs, err := parseTable(*queueArgs)
+		if err != nil {
+			return fmt.Errorf("-args: %w", err)
+		}
+		queueOpts = myamqp.NewQueueOptions(*queue).
+			WithRoutingKey(*routingKey).
+			WithDurable(*durable).
+			WithAutoDelete(*autoDelete).
+			WithExclusive(*exclusive).
+			WithArgs(qArgs).
+			WithDeclareMode(mode).
+			WithSkipBind(*passive)
+		if *queueType != "" {
+			queueOpts = queueOpts.WithQueueType(myamqp.QueueType(*queueType))
+		}
+	}
+
+	amqp, err := conn.connect(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer amqp.Close()
+
+	if err = amqp.Declare(exchangeOpts, queueOpts); err != nil {
+		return err
+	}
+
+	if *passive {
+		fmt.Fprintln(os.Stderr, "exists")
+	} else {
+		fmt.Fprintln(os.Stderr, "declared")
+	}
+
+	return nil
+}
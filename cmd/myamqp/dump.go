@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dmasior/myamqp"
+)
+
+func runDump(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+
+	queue := fs.String("queue", "", "queue to dump, required")
+	file := fs.String("file", "-", "file to write the dump to, - for stdout")
+	format := fs.String("format", "jsonl", "dump format: jsonl or binary")
+	count := fs.Int("count", 0, "dump at most this many messages, 0 for all")
+	remove := fs.Bool("remove", false, "remove the dumped messages from the queue, they are requeued otherwise")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *queue == "" {
+		return errors.New("-queue is required")
+	}
+	dumpFormat, err := myamqp.ParseDumpFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w, err := myamqp.NewDumpWriter(out, dumpFormat)
+	if err != nil {
+		return err
+	}
+
+	amqp, err := conn.connect(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer amqp.Close()
+
+	n, err := amqp.Dump(ctx, *queue, w, myamqp.NewDumpOptions().WithMax(*count).WithRemove(*remove))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "dumped %d messages\n", n)
+
+	return nil
+}
+
+func runReplay(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+
+	file := fs.String("file", "-", "file to read the dump from, - for stdin")
+	format := fs.String("format", "jsonl", "dump format: jsonl or binary")
+	exchange := fs.String("exchange", "", "exchange to publish to, the default exchange when empty")
+	routingKey := fs.String("routing-key", "", "routing key of all messages, the original routing keys when empty")
+	mandatory := fs.Bool("mandatory", false, "fail when a message cannot be routed to a queue")
+	rate := fs.Float64("rate", 0, "max messages per second, 0 for no limit")
+	maxInFlight := fs.Int("max-in-flight", myamqp.DefaultReplayMaxInFlight, "max messages awaiting their confirm")
+	skip := fs.Int("skip", 0, "skip this many messages at the start of the dump, to resume a replay")
+	stripUserID := fs.Bool("strip-user-id", false, "replay the messages without their user id, "+
+		"which the server rejects when it is not the user of the connection")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	dumpFormat, err := myamqp.ParseDumpFormat(*format)
+	if err != nil {
+		return err
+	}
+	if *maxInFlight < 1 {
+		return errors.New("-max-in-flight must be at least 1")
+	}
+
+	in := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	r, err := myamqp.NewDumpReader(in, dumpFormat)
+	if err != nil {
+		return err
+	}
+
+	amqp, err := conn.connect(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer amqp.Close()
+
+	exchangeOpts := myamqp.NewExchangeOptions(*exchange, myamqp.ExchangeTypeDirect).WithDeclareMode(myamqp.DeclareModePassive)
+	if *exchange == "" {
+		exchangeOpts = exchangeOpts.WithDeclareMode(myamqp.DeclareModeSkip)
+	}
+
+	options := myamqp.NewReplayOptions(exchangeOpts).
+		WithMandatory(*mandatory).
+		WithRate(*rate).
+		WithMaxInFlight(*maxInFlight).
+		WithSkip(*skip).
+		WithStripUserID(*stripUserID)
+	if *routingKey != "" {
+		options = options.WithRoutingKey(*routingKey)
+	}
+
+	progress, err := amqp.Replay(ctx, r, options)
+	if err != nil {
+		return fmt.Errorf("%w, resume with -skip %d", err, progress.Offset)
+	}
+
+	fmt.Fprintf(os.Stderr, "replayed %d messages\n", progress.Published)
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func runPublish(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+
+	headers := headerFlag{}
+	exchange := fs.String("exchange", "", "exchange to publish to, the default exchange when empty")
+	routingKey := fs.String("routing-key", "", "routing key, the queue name for the default exchange")
+	file := fs.String("file", "-", "file to read the body from, - for stdin")
+	lines := fs.Bool("lines", false, "publish each line of the input as a message")
+	fs.Var(headers, "header", "header as key=value, repeatable")
+	contentType := fs.String("content-type", "", "content type property")
+	contentEncoding := fs.String("content-encoding", "", "content encoding property")
+	typ := fs.String("type", "", "type property")
+	messageID := fs.String("message-id", "", "message id property")
+	correlationID := fs.String("correlation-id", "", "correlation id property")
+	replyTo := fs.String("reply-to", "", "reply to property")
+	expiration := fs.String("expiration", "", "expiration property, in milliseconds")
+	appID := fs.String("app-id", "", "app id property")
+	priority := fs.Uint("priority", 0, "priority property")
+	persistent := fs.Bool("persistent", false, "publish with the persistent delivery mode")
+	mandatory := fs.Bool("mandatory", false, "fail when a message cannot be routed to a queue, requires -confirm")
+	confirm := fs.Bool("confirm", false, "wait for the publisher confirm of each message")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *mandatory && !*confirm {
+		return errors.New("-mandatory requires -confirm")
+	}
+	if *priority > 255 {
+		return errors.New("-priority must be between 0 and 255")
+	}
+
+	bodies, err := readBodies(*file, *lines)
+	if err != nil {
+		return err
+	}
+
+	amqp, err := conn.connect(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer amqp.Close()
+
+	// The default exchange cannot be declared, others are expected to exist.
+	exchangeOpts := myamqp.NewExchangeOptions(*exchange, myamqp.ExchangeTypeDirect).WithDeclareMode(myamqp.DeclareModePassive)
+	if *exchange == "" {
+		exchangeOpts = exchangeOpts.WithDeclareMode(myamqp.DeclareModeSkip)
+	}
+
+	producer, err := amqp.Producer(myamqp.NewProducerOptions(exchangeOpts).WithConfirm(*confirm))
+	if err != nil {
+		return err
+	}
+	// Each message is confirmed before the next one is published, and the server returns a message before
+	// confirming it, so a return received once a message is confirmed is the return of that message.
+	returns := producer.NotifyReturn(make(chan amqp091.Return, 1))
+
+	deliveryMode := amqp091.Transient
+	if *persistent {
+		deliveryMode = amqp091.Persistent
+	}
+
+	for i, body := range bodies {
+		msg := amqp091.Publishing{
+			Headers:         amqp091.Table(headers),
+			ContentType:     *contentType,
+			ContentEncoding: *contentEncoding,
+			DeliveryMode:    deliveryMode,
+			Priority:        uint8(*priority),
+			CorrelationId:   *correlationID,
+			ReplyTo:         *replyTo,
+			Expiration:      *expiration,
+			MessageId:       *messageID,
+			Timestamp:       time.Now(),
+			Type:            *typ,
+			AppId:           *appID,
+			Body:            body,
+		}
+
+		if !*confirm {
+			if err = producer.Publish(ctx, *routingKey, false, false, msg); err != nil {
+				return fmt.Errorf("message %d: %w", i+1, err)
+			}
+			continue
+		}
+
+		deferred, err := producer.PublishWithDeferredConfirm(ctx, *routingKey, *mandatory, false, msg)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", i+1, err)
+		}
+		acked, err := deferred.WaitContext(ctx)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", i+1, err)
+		}
+		if !acked {
+			return fmt.Errorf("message %d: nacked by the server", i+1)
+		}
+
+		select {
+		case r := <-returns:
+			return fmt.Errorf("message %d: returned: %d %s", i+1, r.ReplyCode, r.ReplyText)
+		default:
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "published %d messages\n", len(bodies))
+
+	return nil
+}
+
+// readBodies reads the bodies from the file, or stdin when file is "-". With lines, each line is a body.
+func readBodies(file string, lines bool) ([][]byte, error) {
+	r := io.Reader(os.Stdin)
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if !lines {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{body}, nil
+	}
+
+	var bodies [][]byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 128*1024*1024)
+	for scanner.Scan() {
+		bodies = append(bodies, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	return bodies, scanner.Err()
+}
@@ -0,0 +1,173 @@
+package myamqp
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrUnknownContentType     = errors.New("unknown content type")
+	ErrUnknownContentEncoding = errors.New("unknown content encoding")
+	ErrNotProtoMessage        = errors.New("value is not a proto.Message")
+)
+
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeProtobuf    = "application/x-protobuf"
+	ContentTypeMessagePack = "application/msgpack"
+	ContentTypeGob         = "application/x-gob"
+)
+
+// Codec encodes and decodes message bodies of a single content type.
+type Codec interface {
+	// ContentType returns the MIME type set as the ContentType of published messages.
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// ContentEncodingCodec is a Codec whose marshaled bodies have a content encoding, e.g. a codec compressing
+// its output. The ContentEncoding is set on published messages, and deliveries with another ContentEncoding
+// are not decoded with it. The built-in codecs have no content encoding, it is set by the compression of the Producer.
+type ContentEncodingCodec interface {
+	Codec
+	// ContentEncoding returns the encoding set as the ContentEncoding of published messages.
+	ContentEncoding() string
+}
+
+// codecContentEncoding returns the content encoding of the Codec, empty when it has none.
+func codecContentEncoding(codec Codec) string {
+	if c, ok := codec.(ContentEncodingCodec); ok {
+		return c.ContentEncoding()
+	}
+	return ""
+}
+
+// JSONCodec is a Codec using encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtobufCodec is a Codec using the protobuf wire format. Values must implement proto.Message.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// MessagePackCodec is a Codec using MessagePack.
+type MessagePackCodec struct{}
+
+func (MessagePackCodec) ContentType() string {
+	return ContentTypeMessagePack
+}
+
+func (MessagePackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MessagePackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// GobCodec is a Codec using encoding/gob.
+type GobCodec struct{}
+
+func (GobCodec) ContentType() string {
+	return ContentTypeGob
+}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Codecs selects a Codec by the ContentType of a delivery.
+type Codecs struct {
+	byContentType map[string]Codec
+	fallback      Codec
+}
+
+// NewCodecs creates a new Codecs with the given codecs. The first codec is used for deliveries without a ContentType.
+func NewCodecs(codecs ...Codec) *Codecs {
+	c := &Codecs{
+		byContentType: make(map[string]Codec, len(codecs)),
+	}
+	for _, codec := range codecs {
+		if c.fallback == nil {
+			c.fallback = codec
+		}
+		c.byContentType[normalizeContentType(codec.ContentType())] = codec
+	}
+
+	return c
+}
+
+// DefaultCodecs returns Codecs with all built-in codecs, falling back to JSON.
+func DefaultCodecs() *Codecs {
+	return NewCodecs(JSONCodec{}, ProtobufCodec{}, MessagePackCodec{}, GobCodec{})
+}
+
+// Lookup returns the Codec for the given content type. Parameters such as the charset
+// and the case of the media type are ignored, e.g. "Application/JSON; charset=utf-8" matches JSONCodec.
+func (c *Codecs) Lookup(contentType string) (Codec, error) {
+	if contentType == "" && c.fallback != nil {
+		return c.fallback, nil
+	}
+
+	codec, ok := c.byContentType[normalizeContentType(contentType)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownContentType, contentType)
+	}
+
+	return codec, nil
+}
+
+// normalizeContentType returns the lower case media type of the content type, without its parameters.
+func normalizeContentType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
@@ -0,0 +1,251 @@
+package myamqp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dmasior/myamqp"
+	"github.com/dmasior/myamqp/myamqptest"
+	"github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testOrder struct {
+	ID    int      `json:"id" msgpack:"id"`
+	Items []string `json:"items" msgpack:"items"`
+}
+
+// reversedCodec is a JSON codec with a content encoding reversing the body.
+type reversedCodec struct {
+	myamqp.JSONCodec
+}
+
+func (reversedCodec) ContentEncoding() string {
+	return "reversed"
+}
+
+func (c reversedCodec) Marshal(v interface{}) ([]byte, error) {
+	body, err := c.JSONCodec.Marshal(v)
+	return reverse(body), err
+}
+
+func (c reversedCodec) Unmarshal(data []byte, v interface{}) error {
+	return c.JSONCodec.Unmarshal(reverse(data), v)
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	order := testOrder{ID: 1, Items: []string{"a", "b"}}
+
+	tests := []struct {
+		codec myamqp.Codec
+	}{
+		{codec: myamqp.JSONCodec{}},
+		{codec: myamqp.MessagePackCodec{}},
+		{codec: myamqp.GobCodec{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.codec.ContentType(), func(t *testing.T) {
+			body, err := tt.codec.Marshal(order)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			var got testOrder
+			if err = tt.codec.Unmarshal(body, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if got.ID != order.ID || len(got.Items) != 2 || got.Items[1] != "b" {
+				t.Errorf("got %+v, want %+v", got, order)
+			}
+		})
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	codec := myamqp.ProtobufCodec{}
+
+	body, err := codec.Marshal(wrapperspb.String("order"))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	got := &wrapperspb.StringValue{}
+	if err = codec.Unmarshal(body, got); err != nil || !proto.Equal(got, wrapperspb.String("order")) {
+		t.Errorf("Unmarshal: got %v, error %v", got, err)
+	}
+
+	if _, err = codec.Marshal(testOrder{}); !errors.Is(err, myamqp.ErrNotProtoMessage) {
+		t.Errorf("Marshal: got error %v, want %v", err, myamqp.ErrNotProtoMessage)
+	}
+	if err = codec.Unmarshal(body, &testOrder{}); !errors.Is(err, myamqp.ErrNotProtoMessage) {
+		t.Errorf("Unmarshal: got error %v, want %v", err, myamqp.ErrNotProtoMessage)
+	}
+}
+
+func TestCodecsLookup(t *testing.T) {
+	codecs := myamqp.DefaultCodecs()
+
+	tests := []struct {
+		contentType string
+		want        string
+		wantErr     error
+	}{
+		{contentType: "", want: myamqp.ContentTypeJSON},
+		{contentType: "application/json", want: myamqp.ContentTypeJSON},
+		{contentType: "Application/JSON; charset=utf-8", want: myamqp.ContentTypeJSON},
+		{contentType: " application/msgpack ", want: myamqp.ContentTypeMessagePack},
+		{contentType: "application/x-protobuf", want: myamqp.ContentTypeProtobuf},
+		{contentType: "application/x-gob", want: myamqp.ContentTypeGob},
+		{contentType: "text/plain", wantErr: myamqp.ErrUnknownContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			codec, err := codecs.Lookup(tt.contentType)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Lookup: got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && codec.ContentType() != tt.want {
+				t.Errorf("Lookup: got %s, want %s", codec.ContentType(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTypedProducer(t *testing.T) {
+	recorder := myamqptest.NewRecordingProducer("orders")
+
+	tests := []struct {
+		name         string
+		codec        myamqp.Codec
+		wantEncoding string
+	}{
+		{name: "json", codec: myamqp.JSONCodec{}},
+		{name: "content encoding codec", codec: reversedCodec{}, wantEncoding: "reversed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder.Reset()
+			producer := myamqp.NewTypedProducer[testOrder](recorder, tt.codec)
+			if err := producer.Publish(context.Background(), "order.created", false, false, testOrder{ID: 7}); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+
+			published := recorder.AssertPublished(t,
+				myamqptest.RoutingKey("order.created"),
+				myamqptest.ContentType(myamqp.ContentTypeJSON),
+			)
+			if published.Publishing.ContentEncoding != tt.wantEncoding {
+				t.Errorf("content encoding: got %q, want %q", published.Publishing.ContentEncoding, tt.wantEncoding)
+			}
+			var got testOrder
+			if err := tt.codec.Unmarshal(published.Publishing.Body, &got); err != nil || got.ID != 7 {
+				t.Errorf("body: got %+v, error %v", got, err)
+			}
+		})
+	}
+}
+
+func TestTypedConsumer(t *testing.T) {
+	broker := newBroker(t)
+	amqp := connect(t, broker)
+
+	received := make(chan myamqp.TypedDelivery[testOrder], 10)
+	consumer, err := myamqp.NewTypedConsumer[testOrder](amqp,
+		myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")),
+		myamqp.NewCodecs(myamqp.JSONCodec{}, myamqp.MessagePackCodec{}),
+		func(deliveries <-chan myamqp.TypedDelivery[testOrder], done chan error) {
+			for d := range deliveries {
+				received <- d
+				d.Ack(false)
+			}
+			done <- nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewTypedConsumer: %v", err)
+	}
+	t.Cleanup(func() { consumer.Cancel() })
+
+	msgpackBody, _ := myamqp.MessagePackCodec{}.Marshal(testOrder{ID: 2})
+	publishings := []amqp091.Publishing{
+		{ContentType: "application/json; charset=utf-8", Body: []byte(`{"id":1}`)},
+		{ContentType: "text/plain", Body: []byte("unknown content type")},
+		{ContentType: myamqp.ContentTypeJSON, Body: []byte("invalid json")},
+		{ContentType: myamqp.ContentTypeJSON, ContentEncoding: "gzip", Body: []byte(`{"id":3}`)},
+		{ContentType: myamqp.ContentTypeMessagePack, Body: msgpackBody},
+	}
+	for _, p := range publishings {
+		if err = broker.Publish("", "orders", p); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	// Only the decodable deliveries reach the handler, the others are rejected.
+	for _, want := range []int{1, 2} {
+		select {
+		case d := <-received:
+			if d.Message.ID != want {
+				t.Errorf("got order %d, want %d", d.Message.ID, want)
+			}
+		case <-waitAfter():
+			t.Fatalf("order %d not received", want)
+		}
+	}
+	waitFor(t, "all deliveries to be settled", func() bool {
+		info, _ := broker.Queue("orders")
+		return info.Messages == 0 && info.Unacked == 0
+	})
+}
+
+func TestTypedConsumerContentEncodingCodec(t *testing.T) {
+	broker := newBroker(t)
+	amqp := connect(t, broker)
+
+	received := make(chan testOrder, 10)
+	consumer, err := myamqp.NewTypedConsumer[testOrder](amqp,
+		myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")),
+		myamqp.NewCodecs(reversedCodec{}),
+		func(deliveries <-chan myamqp.TypedDelivery[testOrder], done chan error) {
+			for d := range deliveries {
+				received <- d.Message
+				d.Ack(false)
+			}
+			done <- nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewTypedConsumer: %v", err)
+	}
+	t.Cleanup(func() { consumer.Cancel() })
+
+	body, _ := reversedCodec{}.Marshal(testOrder{ID: 5})
+	// Without the content encoding of the codec, the delivery is rejected.
+	broker.Publish("", "orders", amqp091.Publishing{ContentType: myamqp.ContentTypeJSON, Body: bytes.Clone(body)})
+	broker.Publish("", "orders", amqp091.Publishing{ContentType: myamqp.ContentTypeJSON, ContentEncoding: "reversed", Body: body})
+
+	select {
+	case got := <-received:
+		if got.ID != 5 {
+			t.Errorf("got order %+v", got)
+		}
+	case <-waitAfter():
+		t.Fatal("order not received")
+	}
+	select {
+	case got := <-received:
+		t.Errorf("delivery without the content encoding decoded: %+v", got)
+	default:
+	}
+}
@@ -0,0 +1,225 @@
+package myamqp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrUnknownCompression     = errors.New("unknown compression")
+	ErrDecompressedBodyTooBig = errors.New("decompressed body too big")
+	ErrInvalidMaxSize         = errors.New("max size must be positive")
+)
+
+// DefaultMaxDecompressedSize is the max size of a decompressed body by default.
+const DefaultMaxDecompressedSize = 64 << 20
+
+// CompressionAlgorithm represents a compression algorithm. Its value is set as the ContentEncoding of compressed messages.
+type CompressionAlgorithm string
+
+const (
+	CompressionGzip   CompressionAlgorithm = "gzip"
+	CompressionZstd   CompressionAlgorithm = "zstd"
+	CompressionSnappy CompressionAlgorithm = "snappy"
+)
+
+// Compression represents options for compressing published message bodies.
+type Compression struct {
+	algorithm CompressionAlgorithm
+	threshold int
+}
+
+// NewCompression creates a new Compression with the given algorithm and threshold.
+// Only bodies of at least threshold bytes are compressed.
+func NewCompression(algorithm CompressionAlgorithm, threshold int) *Compression {
+	return &Compression{
+		algorithm: algorithm,
+		threshold: threshold,
+	}
+}
+
+// Algorithm returns the algorithm on the Compression.
+func (c *Compression) Algorithm() CompressionAlgorithm {
+	return c.algorithm
+}
+
+// Threshold returns the threshold on the Compression.
+func (c *Compression) Threshold() int {
+	return c.threshold
+}
+
+func (c *Compression) validate() error {
+	switch c.algorithm {
+	case CompressionGzip, CompressionZstd, CompressionSnappy:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownCompression, c.algorithm)
+	}
+}
+
+// compress compresses the body of the message when it reaches the threshold.
+// Messages with a ContentEncoding already set are left untouched.
+func (c *Compression) compress(msg amqp091.Publishing) (amqp091.Publishing, error) {
+	if msg.ContentEncoding != "" || len(msg.Body) < c.threshold {
+		return msg, nil
+	}
+
+	body, err := compressBody(c.algorithm, msg.Body)
+	if err != nil {
+		return msg, err
+	}
+	msg.Body = body
+	msg.ContentEncoding = string(c.algorithm)
+
+	return msg, nil
+}
+
+// Decompression represents options for decompressing consumed message bodies.
+type Decompression struct {
+	maxSize int
+}
+
+// NewDecompression creates a new Decompression with DefaultMaxDecompressedSize.
+func NewDecompression() *Decompression {
+	return &Decompression{
+		maxSize: DefaultMaxDecompressedSize,
+	}
+}
+
+// WithMaxSize sets the max size in bytes of a decompressed body on the Decompression.
+// Deliveries decompressing to more are rejected, so a small compressed message cannot exhaust the memory.
+func (dc *Decompression) WithMaxSize(maxSize int) *Decompression {
+	dc.maxSize = maxSize
+	return dc
+}
+
+// MaxSize returns the max size on the Decompression.
+func (dc *Decompression) MaxSize() int {
+	return dc.maxSize
+}
+
+func (dc *Decompression) validate() error {
+	if dc.maxSize <= 0 {
+		return ErrInvalidMaxSize
+	}
+	return nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+	})
+
+	return zstdErr
+}
+
+func compressBody(algorithm CompressionAlgorithm, body []byte) ([]byte, error) {
+	switch algorithm {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(body, nil), nil
+	case CompressionSnappy:
+		return s2.EncodeSnappy(nil, body), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCompression, algorithm)
+	}
+}
+
+// decompressBody decompresses the body, failing with ErrDecompressedBodyTooBig past maxSize bytes.
+func decompressBody(algorithm CompressionAlgorithm, body []byte, maxSize int) ([]byte, error) {
+	switch algorithm {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r, maxSize)
+	case CompressionZstd:
+		r, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r, maxSize)
+	case CompressionSnappy:
+		// The decoded length is in the header of the block, so nothing is allocated past the limit.
+		n, err := s2.DecodedLen(body)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxSize {
+			return nil, fmt.Errorf("%w: more than %d bytes", ErrDecompressedBodyTooBig, maxSize)
+		}
+		return s2.Decode(nil, body)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCompression, algorithm)
+	}
+}
+
+// readLimited reads r to the end, failing with ErrDecompressedBodyTooBig past maxSize bytes.
+func readLimited(r io.Reader, maxSize int) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrDecompressedBodyTooBig, maxSize)
+	}
+	return body, nil
+}
+
+// decompressDeliveries forwards the deliveries with bodies decompressed according to their ContentEncoding.
+// Deliveries with an unknown ContentEncoding are forwarded as they are. Deliveries which cannot be
+// decompressed are rejected without requeue, unless autoAck is set, and never reach the handler.
+func (dc *Decompression) decompressDeliveries(logger *slog.Logger, deliveries <-chan amqp091.Delivery, autoAck bool) <-chan amqp091.Delivery {
+	decompressed := make(chan amqp091.Delivery)
+	go func() {
+		defer close(decompressed)
+		for d := range deliveries {
+			switch algorithm := CompressionAlgorithm(d.ContentEncoding); algorithm {
+			case CompressionGzip, CompressionZstd, CompressionSnappy:
+				body, err := decompressBody(algorithm, d.Body, dc.maxSize)
+				if err != nil {
+					logger.Warn("delivery decompression failed, rejecting", "content_encoding", d.ContentEncoding, "error", err)
+					if !autoAck {
+						d.Reject(false)
+					}
+					continue
+				}
+				d.Body = body
+				d.ContentEncoding = ""
+			}
+			decompressed <- d
+		}
+	}()
+
+	return decompressed
+}
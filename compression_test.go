@@ -0,0 +1,157 @@
+package myamqp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// publishCompressed publishes the body to the orders queue with a producer compressing bodies of at least 16 bytes.
+func publishCompressed(t *testing.T, amqp *myamqp.MyAMQP, algorithm myamqp.CompressionAlgorithm, body []byte) {
+	t.Helper()
+
+	producer, err := amqp.Producer(myamqp.NewProducerOptions(defaultExchange()).
+		WithQueueOptions(myamqp.NewQueueOptions("orders").WithSkipBind(true)).
+		WithCompression(myamqp.NewCompression(algorithm, 16)))
+	if err != nil {
+		t.Fatalf("Producer: %v", err)
+	}
+	defer producer.Close()
+
+	if err = producer.Publish(context.Background(), "orders", false, false, amqp091.Publishing{Body: body}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte("order "), 100)
+
+	for _, algorithm := range []myamqp.CompressionAlgorithm{myamqp.CompressionGzip, myamqp.CompressionZstd, myamqp.CompressionSnappy} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			broker := newBroker(t)
+			amqp := connect(t, broker)
+			publishCompressed(t, amqp, algorithm, body)
+
+			messages := broker.Messages("orders")
+			if len(messages) != 1 || messages[0].ContentEncoding != string(algorithm) || len(messages[0].Body) >= len(body) {
+				t.Fatalf("published messages are not compressed: %v", messages)
+			}
+
+			// Deliveries are decompressed by default.
+			d := receive(t, consume(t, amqp, myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders"))))
+			if d.ContentEncoding != "" || !bytes.Equal(d.Body, body) {
+				t.Errorf("got content encoding %q, body %q", d.ContentEncoding, d.Body)
+			}
+		})
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	broker := newBroker(t)
+	amqp := connect(t, broker)
+	publishCompressed(t, amqp, myamqp.CompressionGzip, []byte("small"))
+
+	if messages := broker.Messages("orders"); len(messages) != 1 || messages[0].ContentEncoding != "" || string(messages[0].Body) != "small" {
+		t.Errorf("body below the threshold was compressed: %v", messages)
+	}
+}
+
+func TestDecompressionDisabled(t *testing.T) {
+	broker := newBroker(t)
+	amqp := connect(t, broker)
+	publishCompressed(t, amqp, myamqp.CompressionGzip, bytes.Repeat([]byte("order "), 100))
+	compressed := broker.Messages("orders")[0].Body
+
+	d := receive(t, consume(t, amqp, myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")).WithDecompression(nil)))
+	if d.ContentEncoding != string(myamqp.CompressionGzip) || !bytes.Equal(d.Body, compressed) {
+		t.Errorf("got content encoding %q, body %q", d.ContentEncoding, d.Body)
+	}
+}
+
+func TestDecompressionRejects(t *testing.T) {
+	tests := []struct {
+		name          string
+		publishing    amqp091.Publishing
+		decompression *myamqp.Decompression
+	}{
+		{
+			name:          "too big",
+			publishing:    amqp091.Publishing{ContentEncoding: "gzip", Body: gzipBody(t, bytes.Repeat([]byte("a"), 1024))},
+			decompression: myamqp.NewDecompression().WithMaxSize(1023),
+		},
+		{
+			name:          "snappy too big",
+			publishing:    amqp091.Publishing{ContentEncoding: "snappy", Body: snappyBody(t, bytes.Repeat([]byte("a"), 1024))},
+			decompression: myamqp.NewDecompression().WithMaxSize(1023),
+		},
+		{
+			name:          "corrupt body",
+			publishing:    amqp091.Publishing{ContentEncoding: "gzip", Body: []byte("not gzip")},
+			decompression: myamqp.NewDecompression(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newBroker(t)
+			amqp := connect(t, broker)
+			deliveries := consume(t, amqp, myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")).WithDecompression(tt.decompression))
+
+			if err := broker.Publish("", "orders", tt.publishing); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+			// Deliveries after the rejected one still reach the handler.
+			if err := broker.Publish("", "orders", amqp091.Publishing{ContentEncoding: "identity", Body: []byte("next")}); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+
+			d := receive(t, deliveries)
+			if string(d.Body) != "next" || d.ContentEncoding != "identity" {
+				t.Errorf("got body %q, content encoding %q, want the next delivery untouched", d.Body, d.ContentEncoding)
+			}
+			d.Ack(false)
+			waitFor(t, "the rejected delivery to be removed", func() bool {
+				info, _ := broker.Queue("orders")
+				return info.Messages == 0 && info.Unacked == 0
+			})
+		})
+	}
+}
+
+func TestCompressionInvalidOptions(t *testing.T) {
+	amqp := connect(t, newBroker(t))
+
+	_, err := amqp.Producer(myamqp.NewProducerOptions(defaultExchange()).WithCompression(myamqp.NewCompression("brotli", 0)))
+	if !errors.Is(err, myamqp.ErrUnknownCompression) {
+		t.Errorf("Producer: got error %v, want %v", err, myamqp.ErrUnknownCompression)
+	}
+
+	_, err = amqp.Consumer(myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")).
+		WithDecompression(myamqp.NewDecompression().WithMaxSize(0)),
+		func(deliveries <-chan amqp091.Delivery, done chan error) { done <- nil })
+	if !errors.Is(err, myamqp.ErrInvalidMaxSize) {
+		t.Errorf("Consumer: got error %v, want %v", err, myamqp.ErrInvalidMaxSize)
+	}
+}
+
+// gzipBody returns the body compressed by a producer with gzip.
+func gzipBody(t *testing.T, body []byte) []byte {
+	return compressedBody(t, myamqp.CompressionGzip, body)
+}
+
+// snappyBody returns the body compressed by a producer with snappy.
+func snappyBody(t *testing.T, body []byte) []byte {
+	return compressedBody(t, myamqp.CompressionSnappy, body)
+}
+
+func compressedBody(t *testing.T, algorithm myamqp.CompressionAlgorithm, body []byte) []byte {
+	t.Helper()
+
+	broker := newBroker(t)
+	publishCompressed(t, connect(t, broker), algorithm, body)
+	return broker.Messages("orders")[0].Body
+}
@@ -1,18 +1,41 @@
 package myamqp
 
-import "errors"
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
 
 var (
 	ErrDialFuncCannotBeNil = errors.New("dial func cannot be nil")
+	ErrDialerCannotBeNil   = errors.New("dialer cannot be nil")
 )
 
+// DefaultReconnectBackoff is the backoff of the ReconnectPolicy used when none is set.
+const DefaultReconnectBackoff = time.Second
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
 // Config represents a configuration.
 // It is used to configure a MyAMQP instance.
 type Config struct {
 	dialFunc        DialFunc
+	dialer          Dialer
 	reconnectPolicy *ReconnectPolicy
 	onConnect       func(*MyAMQP)
+	setup           func(*MyAMQP) error
 	qos             *Qos
+	tracing         *Tracing
+	metrics         Metrics
+	logger          *slog.Logger
+	onBlocked       func(blocked bool, reason string)
+	// url, amqpConfig and tls are set on a Config created with NewConfigFromURL or NewConfigFromEnv.
+	url        string
+	amqpConfig amqp091.Config
+	tls        *TLSOptions
 }
 
 // NewConfig creates a new Config with the given URL.
@@ -22,11 +45,27 @@ func NewConfig(dialFunc DialFunc) (*Config, error) {
 	}
 
 	return &Config{
-		dialFunc: dialFunc,
+		dialFunc:        dialFunc,
+		dialer:          dialerFromDialFunc(dialFunc),
+		reconnectPolicy: NewReconnectPolicy(MaxReconnectUnlimited, DefaultReconnectBackoff),
+	}, nil
+}
+
+// NewConfigWithDialer creates a new Config with the given Dialer.
+// Use it to connect through a Connection other than *amqp091.Connection.
+func NewConfigWithDialer(dialer Dialer) (*Config, error) {
+	if dialer == nil {
+		return nil, ErrDialerCannotBeNil
+	}
+
+	return &Config{
+		dialer:          dialer,
+		reconnectPolicy: NewReconnectPolicy(MaxReconnectUnlimited, DefaultReconnectBackoff),
 	}, nil
 }
 
 // WithReconnectPolicy sets the ReconnectPolicy on the Config.
+// Defaults to unlimited reconnects with DefaultReconnectBackoff.
 func (c *Config) WithReconnectPolicy(rp *ReconnectPolicy) *Config {
 	c.reconnectPolicy = rp
 	return c
@@ -38,24 +77,102 @@ func (c *Config) WithOnConnect(callback func(*MyAMQP)) *Config {
 	return c
 }
 
+// WithSetup sets the Setup callback on the Config. It is called after each connection, after OnConnect.
+// When it returns an error, e.g. a consumer could not be created, the connection is closed
+// and reconnected according to the ReconnectPolicy.
+func (c *Config) WithSetup(setup func(*MyAMQP) error) *Config {
+	c.setup = setup
+	return c
+}
+
 // WithQos sets the Qos on the Config.
 func (c *Config) WithQos(qos *Qos) *Config {
 	c.qos = qos
 	return c
 }
 
+// WithTracing sets the Tracing on the Config. Producers and consumers created afterwards are traced.
+func (c *Config) WithTracing(tracing *Tracing) *Config {
+	c.tracing = tracing
+	return c
+}
+
+// WithMetrics sets the Metrics on the Config.
+func (c *Config) WithMetrics(metrics Metrics) *Config {
+	c.metrics = metrics
+	return c
+}
+
+// WithLogger sets the Logger on the Config.
+func (c *Config) WithLogger(logger *slog.Logger) *Config {
+	c.logger = logger
+	return c
+}
+
+// WithOnBlocked sets the OnBlocked callback on the Config.
+// It is called when the AMQP server blocks or unblocks the connection, e.g. on a memory or disk alarm.
+func (c *Config) WithOnBlocked(callback func(blocked bool, reason string)) *Config {
+	c.onBlocked = callback
+	return c
+}
+
+// WithTLS sets the TLSOptions on a Config created with NewConfigFromURL or NewConfigFromEnv,
+// replacing the TLS settings of the URL. New fails with ErrTLSRequiresURL on other Configs,
+// use TLSOptions.DialFunc with NewConfig instead.
+func (c *Config) WithTLS(tlsOptions *TLSOptions) *Config {
+	c.tls = tlsOptions
+	return c
+}
+
 func (c *Config) OnConnect() func(*MyAMQP) {
 	return c.onConnect
 }
 
+func (c *Config) Setup() func(*MyAMQP) error {
+	return c.setup
+}
+
+func (c *Config) OnBlocked() func(blocked bool, reason string) {
+	return c.onBlocked
+}
+
 func (c *Config) Qos() *Qos {
 	return c.qos
 }
 
+func (c *Config) Tracing() *Tracing {
+	return c.tracing
+}
+
+// Metrics returns the Metrics on the Config, or NoopMetrics when not set.
+func (c *Config) Metrics() Metrics {
+	if c.metrics == nil {
+		return NoopMetrics{}
+	}
+	return c.metrics
+}
+
+// Logger returns the Logger on the Config, or a Logger discarding all records when not set.
+func (c *Config) Logger() *slog.Logger {
+	if c.logger == nil {
+		return discardLogger
+	}
+	return c.logger
+}
+
 func (c *Config) ReconnectPolicy() *ReconnectPolicy {
 	return c.reconnectPolicy
 }
 
+// DialFunc returns the DialFunc of the Config, nil when it was created with NewConfigWithDialer.
 func (c *Config) DialFunc() DialFunc {
 	return c.dialFunc
 }
+
+func (c *Config) Dialer() Dialer {
+	return c.dialer
+}
+
+func (c *Config) TLS() *TLSOptions {
+	return c.tls
+}
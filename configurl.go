@@ -0,0 +1,300 @@
+package myamqp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// Environment variables read by NewConfigFromEnv. Each one overrides the matching query parameter of the URL.
+const (
+	EnvURL               = "AMQP_URL"
+	EnvVhost             = "AMQP_VHOST"
+	EnvConnectionName    = "AMQP_CONNECTION_NAME"
+	EnvHeartbeat         = "AMQP_HEARTBEAT"
+	EnvConnectionTimeout = "AMQP_CONNECTION_TIMEOUT"
+	EnvChannelMax        = "AMQP_CHANNEL_MAX"
+	EnvFrameMax          = "AMQP_FRAME_MAX"
+	EnvPrefetch          = "AMQP_PREFETCH"
+	EnvMaxReconnects     = "AMQP_MAX_RECONNECTS"
+	EnvReconnectBackoff  = "AMQP_RECONNECT_BACKOFF"
+	EnvTLSCACertFile     = "AMQP_TLS_CA_CERT_FILE"
+	EnvTLSCertFile       = "AMQP_TLS_CERT_FILE"
+	EnvTLSKeyFile        = "AMQP_TLS_KEY_FILE"
+	EnvTLSServerName     = "AMQP_TLS_SERVER_NAME"
+	EnvAuthMechanism     = "AMQP_AUTH_MECHANISM"
+)
+
+const (
+	defaultHeartbeat = 10 * time.Second
+	defaultLocale    = "en_US"
+)
+
+// NewConfigFromURL creates a new Config dialing the AMQP URI, configured with its query parameters:
+//
+//   - heartbeat: heartbeat interval in seconds, or a duration such as "15s". Defaults to 10s.
+//   - connection_timeout: TCP connection timeout in milliseconds, or a duration. Must be positive.
+//   - channel_max, frame_max: limits negotiated with the AMQP server.
+//   - connection_name: connection name shown by the AMQP server.
+//   - prefetch: prefetch count of the Qos.
+//   - max_reconnects, reconnect_backoff: ReconnectPolicy, defaults to unlimited reconnects with DefaultReconnectBackoff.
+//   - cacertfile, certfile, keyfile, server_name_indication: TLS settings of an amqps URI,
+//     the files are read again on each reconnect, see TLSOptions.
+//   - auth_mechanism: plain, the default, or external for SASL EXTERNAL with the client certificate.
+//
+// The vhost is the path of the URI. Invalid settings fail with ErrInvalidConfig, naming the setting.
+func NewConfigFromURL(rawURL string) (*Config, error) {
+	return newConfigFromURL(rawURL, nil)
+}
+
+// NewConfigFromEnv creates a new Config like NewConfigFromURL, with the URL of the AMQP_URL environment variable.
+// The other Env variables override the matching query parameters, e.g. AMQP_PREFETCH overrides prefetch,
+// and AMQP_VHOST the vhost of the URL.
+func NewConfigFromEnv() (*Config, error) {
+	rawURL, ok := os.LookupEnv(EnvURL)
+	if !ok || rawURL == "" {
+		return nil, fmt.Errorf("%w: %s: not set", ErrInvalidConfig, EnvURL)
+	}
+
+	return newConfigFromURL(rawURL, os.LookupEnv)
+}
+
+// configSource looks up settings in the environment first, then in the query parameters of the URL.
+type configSource struct {
+	query  url.Values
+	lookup func(key string) (string, bool)
+}
+
+// get returns the value of the setting, and the name of where it was found for errors.
+func (s configSource) get(param, env string) (value, name string, ok bool) {
+	if s.lookup != nil {
+		if v, ok := s.lookup(env); ok && v != "" {
+			return v, env, true
+		}
+	}
+
+	if s.query.Has(param) {
+		return s.query.Get(param), "query parameter " + param, true
+	}
+
+	return "", "", false
+}
+
+func newConfigFromURL(rawURL string, lookup func(key string) (string, bool)) (*Config, error) {
+	uri, err := amqp091.ParseURI(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: URL: %v", ErrInvalidConfig, err)
+	}
+
+	// ParseURI validated the URL, which is not included in errors as it can contain a password.
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: URL: %v", ErrInvalidConfig, err)
+	}
+
+	src := configSource{query: parsed.Query(), lookup: lookup}
+
+	amqpConfig := amqp091.Config{
+		Vhost:     uri.Vhost,
+		Heartbeat: defaultHeartbeat,
+		Locale:    defaultLocale,
+	}
+
+	// The vhost is the path of the URL, so there is no query parameter for it.
+	if lookup != nil {
+		if v, ok := lookup(EnvVhost); ok && v != "" {
+			amqpConfig.Vhost = v
+		}
+	}
+
+	if v, _, ok := src.get("connection_name", EnvConnectionName); ok {
+		amqpConfig.Properties = amqp091.NewConnectionProperties()
+		amqpConfig.Properties["connection_name"] = v
+	}
+
+	if v, name, ok := src.get("heartbeat", EnvHeartbeat); ok {
+		if amqpConfig.Heartbeat, err = parseConfigDuration(name, v, time.Second); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, name, ok := src.get("connection_timeout", EnvConnectionTimeout); ok {
+		timeout, err := parseConfigDuration(name, v, time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+		// A zero timeout would make every dial fail, as the handshake deadline is the time of the dial.
+		if timeout == 0 {
+			return nil, fmt.Errorf("%w: %s: %q is not positive", ErrInvalidConfig, name, v)
+		}
+		amqpConfig.Dial = amqp091.DefaultDial(timeout)
+	}
+
+	if v, name, ok := src.get("channel_max", EnvChannelMax); ok {
+		if amqpConfig.ChannelMax, err = parseConfigInt(name, v, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, name, ok := src.get("frame_max", EnvFrameMax); ok {
+		if amqpConfig.FrameSize, err = parseConfigInt(name, v, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	tlsOpts, err := tlsOptionsFromSource(src, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{
+		url:             rawURL,
+		amqpConfig:      amqpConfig,
+		tls:             tlsOpts,
+		reconnectPolicy: NewReconnectPolicy(MaxReconnectUnlimited, DefaultReconnectBackoff),
+	}
+	config.dialFunc = config.dialURL
+	config.dialer = dialerFromDialFunc(config.dialFunc)
+
+	if v, name, ok := src.get("prefetch", EnvPrefetch); ok {
+		prefetch, err := parseConfigInt(name, v, 0)
+		if err != nil {
+			return nil, err
+		}
+		config = config.WithQos(NewQos(prefetch, 0, false))
+	}
+
+	maxReconnects, backoff := MaxReconnectUnlimited, DefaultReconnectBackoff
+	if v, name, ok := src.get("max_reconnects", EnvMaxReconnects); ok {
+		if maxReconnects, err = parseConfigInt(name, v, MaxReconnectUnlimited); err != nil {
+			return nil, err
+		}
+	}
+	if v, name, ok := src.get("reconnect_backoff", EnvReconnectBackoff); ok {
+		if backoff, err = parseConfigDuration(name, v, time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+
+	return config.WithReconnectPolicy(NewReconnectPolicy(maxReconnects, backoff)), nil
+}
+
+// tlsOptionsFromSource returns the TLSOptions of the TLS settings, nil when there are none and the
+// default TLS config of amqp091 is used for an amqps URI. The files are checked once here, naming
+// the setting on error, and read again on each connection attempt.
+func tlsOptionsFromSource(src configSource, uri amqp091.URI) (*TLSOptions, error) {
+	caCertFile, caCertName, hasCACert := src.get("cacertfile", EnvTLSCACertFile)
+	certFile, certName, hasCert := src.get("certfile", EnvTLSCertFile)
+	keyFile, keyName, hasKey := src.get("keyfile", EnvTLSKeyFile)
+	serverName, serverNameName, hasServerName := src.get("server_name_indication", EnvTLSServerName)
+	mechanism, mechanismName, hasMechanism := src.get("auth_mechanism", EnvAuthMechanism)
+
+	var externalAuth bool
+	if hasMechanism {
+		switch strings.ToLower(mechanism) {
+		case "plain":
+		case "external":
+			externalAuth = true
+		default:
+			return nil, fmt.Errorf("%w: %s: %q is not plain or external", ErrInvalidConfig, mechanismName, mechanism)
+		}
+	}
+
+	if !hasCACert && !hasCert && !hasKey && !hasServerName && !externalAuth {
+		return nil, nil
+	}
+
+	if uri.Scheme != "amqps" {
+		for _, setting := range []struct {
+			name string
+			ok   bool
+		}{{caCertName, hasCACert}, {certName, hasCert}, {keyName, hasKey}, {serverNameName, hasServerName}, {mechanismName, externalAuth}} {
+			if setting.ok {
+				return nil, fmt.Errorf("%w: %s: requires an amqps URL", ErrInvalidConfig, setting.name)
+			}
+		}
+	}
+
+	opts := NewTLSOptions().WithServerName(serverName).WithExternalAuth(externalAuth)
+
+	if hasCACert {
+		pem, err := os.ReadFile(caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, caCertName, err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s: no PEM certificate in %s", ErrInvalidConfig, caCertName, caCertFile)
+		}
+		opts = opts.WithCACertFile(caCertFile)
+	}
+
+	if hasCert != hasKey {
+		if hasCert {
+			return nil, fmt.Errorf("%w: %s: requires a key file", ErrInvalidConfig, certName)
+		}
+		return nil, fmt.Errorf("%w: %s: requires a certificate file", ErrInvalidConfig, keyName)
+	}
+
+	if externalAuth && !hasCert {
+		return nil, fmt.Errorf("%w: %s: external requires a client certificate", ErrInvalidConfig, mechanismName)
+	}
+
+	if hasCert {
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, certName, err)
+		}
+		opts = opts.WithClientCertFile(certFile, keyFile)
+	}
+
+	return opts, nil
+}
+
+// dialURL dials the URL of a Config created with NewConfigFromURL or NewConfigFromEnv.
+func (c *Config) dialURL() (*amqp091.Connection, error) {
+	if c.tls != nil {
+		return c.tls.dial(c.url, c.amqpConfig)
+	}
+	return amqp091.DialConfig(c.url, c.amqpConfig)
+}
+
+// parseConfigInt parses an integer setting, which must not be less than min.
+func parseConfigInt(name, value string, min int) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %q is not an integer", ErrInvalidConfig, name, value)
+	}
+
+	if i < min {
+		return 0, fmt.Errorf("%w: %s: %d is less than %d", ErrInvalidConfig, name, i, min)
+	}
+
+	return i, nil
+}
+
+// parseConfigDuration parses a duration setting, either an integer in the unit or a duration such as "1m30s".
+func parseConfigDuration(name, value string, unit time.Duration) (time.Duration, error) {
+	var d time.Duration
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		d = time.Duration(i) * unit
+	} else if d, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("%w: %s: %q is not a duration", ErrInvalidConfig, name, value)
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("%w: %s: %q is negative", ErrInvalidConfig, name, value)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,94 @@
+package myamqp
+
+import (
+	"context"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Connection represents the AMQP connection used by MyAMQP.
+// NewConnection adapts an *amqp091.Connection, other implementations allow mocking and fault injection.
+type Connection interface {
+	Channel() (Channel, error)
+	NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error
+	NotifyBlocked(receiver chan amqp091.Blocking) chan amqp091.Blocking
+	Config() amqp091.Config
+	IsClosed() bool
+	Close() error
+}
+
+// Channel represents the AMQP channel used by consumers, producers and RPC clients and servers.
+// It is implemented by *amqp091.Channel.
+type Channel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Confirm(noWait bool) error
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error
+	ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp091.Table) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Get(queue string, autoAck bool) (amqp091.Delivery, bool, error)
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) (*amqp091.DeferredConfirmation, error)
+	NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error
+	NotifyCancel(receiver chan string) chan string
+	NotifyReturn(receiver chan amqp091.Return) chan amqp091.Return
+	IsClosed() bool
+	Close() error
+}
+
+// Dialer is a function that returns a new Connection.
+// It is used by the Config to establish a connection to the AMQP server.
+type Dialer func() (Connection, error)
+
+var _ Channel = (*amqp091.Channel)(nil)
+
+type amqpConnection struct {
+	conn *amqp091.Connection
+}
+
+// NewConnection adapts an *amqp091.Connection to a Connection.
+func NewConnection(conn *amqp091.Connection) Connection {
+	return &amqpConnection{conn: conn}
+}
+
+func (c *amqpConnection) Channel() (Channel, error) {
+	channel, err := c.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+func (c *amqpConnection) NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error {
+	return c.conn.NotifyClose(receiver)
+}
+
+func (c *amqpConnection) NotifyBlocked(receiver chan amqp091.Blocking) chan amqp091.Blocking {
+	return c.conn.NotifyBlocked(receiver)
+}
+
+func (c *amqpConnection) Config() amqp091.Config {
+	return c.conn.Config
+}
+
+func (c *amqpConnection) IsClosed() bool {
+	return c.conn.IsClosed()
+}
+
+func (c *amqpConnection) Close() error {
+	return c.conn.Close()
+}
+
+// dialerFromDialFunc adapts a DialFunc to a Dialer.
+func dialerFromDialFunc(dialFunc DialFunc) Dialer {
+	return func() (Connection, error) {
+		conn, err := dialFunc()
+		if err != nil {
+			return nil, err
+		}
+		return NewConnection(conn), nil
+	}
+}
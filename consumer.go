@@ -2,15 +2,18 @@ package myamqp
 
 import (
 	"context"
+	"log/slog"
 
 	"github.com/rabbitmq/amqp091-go"
 )
 
 // Consumer represents a consumer.
 type Consumer struct {
-	channel *amqp091.Channel
-	done    chan error
-	options *ConsumerOptions
+	channel    Channel
+	done       chan error
+	options    *ConsumerOptions
+	logger     *slog.Logger
+	unregister func()
 }
 
 // HandleFunc is a function that handles incoming deliveries.
@@ -18,7 +21,8 @@ type HandleFunc func(deliveries <-chan amqp091.Delivery, done chan error)
 
 // Consumer creates a new consumer with the given ConsumerOptions and HandleFunc.
 func (s *MyAMQP) Consumer(options *ConsumerOptions, handler HandleFunc) (*Consumer, error) {
-	if s.conn == nil || s.conn.IsClosed() {
+	conn := s.connection()
+	if conn == nil || conn.IsClosed() {
 		return nil, ErrNotConnected
 	}
 
@@ -26,15 +30,40 @@ func (s *MyAMQP) Consumer(options *ConsumerOptions, handler HandleFunc) (*Consum
 		return nil, ErrOptionsCannotBeNil
 	}
 
-	if options.exchangeOpts == nil {
-		return nil, ErrExchangeOptionsCannotBeNil
-	}
-
 	if options.queueOpts == nil {
 		return nil, ErrQueueOptionsCannotBeNil
 	}
 
-	channel, err := s.conn.Channel()
+	if err := options.queueOpts.Validate(); err != nil {
+		return nil, err
+	}
+
+	if options.streamOpts != nil {
+		if err := options.streamOpts.validate(options); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.claimCheck != nil {
+		if err := options.claimCheck.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.decompression != nil {
+		if err := options.decompression.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	logger := s.logger().With("queue", options.queueOpts.name, "consumer_tag", options.name)
+
+	channel, err := conn.Channel()
+	if err != nil {
+		logger.Error("channel open failed", "error", err)
+		return nil, err
+	}
+	logChannelClose(logger, channel)
 
 	if s.config.Qos() != nil {
 		err = channel.Qos(
@@ -43,45 +72,39 @@ func (s *MyAMQP) Consumer(options *ConsumerOptions, handler HandleFunc) (*Consum
 			s.config.Qos().Global(),
 		)
 		if err != nil {
-			s.conn.Close()
-			s.conn = nil
+			conn.Close()
 			return nil, err
 		}
 	}
 
-	if err = channel.ExchangeDeclare(
-		options.exchangeOpts.name,
-		options.exchangeOpts.kind,
-		options.exchangeOpts.durable,
-		options.exchangeOpts.autoDelete,
-		options.exchangeOpts.internal,
-		options.exchangeOpts.noWait,
-		options.exchangeOpts.args,
-	); err != nil {
-		return nil, err
+	// Stream queues require a prefetch count on the consumer channel.
+	if options.streamOpts != nil {
+		if err = channel.Qos(options.streamOpts.prefetchCount, 0, false); err != nil {
+			return nil, err
+		}
 	}
 
-	if _, err = channel.QueueDeclare(
-		options.queueOpts.name,
-		options.queueOpts.durable,
-		options.queueOpts.autoDelete,
-		options.queueOpts.exclusive,
-		options.queueOpts.noWait,
-		options.queueOpts.args,
-	); err != nil {
+	if options.exchangeOpts != nil {
+		if err = declareExchange(logger, channel, options.exchangeOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = declareQueue(logger, channel, options.queueOpts); err != nil {
 		return nil, err
 	}
 
-	if err = channel.QueueBind(
-		options.queueOpts.name,
-		options.queueOpts.routingKey,
-		options.exchangeOpts.name,
-		options.queueOpts.noWait,
-		options.queueOpts.args,
-	); err != nil {
+	if err = bindQueue(logger, channel, options.exchangeOpts, options.queueOpts); err != nil {
 		return nil, err
 	}
 
+	consumeArgs := options.args
+	if options.streamOpts != nil {
+		if consumeArgs, err = options.streamOpts.consumeArgs(options.name, options.args); err != nil {
+			return nil, err
+		}
+	}
+
 	deliveries, err := channel.Consume(
 		options.queueOpts.name,
 		options.name,
@@ -89,20 +112,58 @@ func (s *MyAMQP) Consumer(options *ConsumerOptions, handler HandleFunc) (*Consum
 		options.exclusive,
 		options.noLocal,
 		options.noWait,
-		options.args,
+		consumeArgs,
 	)
 	if err != nil {
+		logger.Error("consume failed", "error", err)
 		return nil, err
 	}
 
+	// The AMQP server cancels the consumer when e.g. its queue is deleted.
+	cancels := channel.NotifyCancel(make(chan string, 1))
+	go func() {
+		for tag := range cancels {
+			logger.Warn("consumer cancelled by server", "consumer_tag", tag)
+		}
+	}()
+
+	// Observing first records the deliveries settled by the stages below too, e.g. rejected by the Decompression.
+	deliveries = observeDeliveries(s.config.Metrics(), options.queueOpts.name, channel, deliveries, options.autoAck)
+
+	if options.streamOpts != nil {
+		deliveries = options.streamOpts.trackOffsets(options.name, deliveries)
+	}
+
+	if options.claimCheck != nil {
+		deliveries = options.claimCheck.resolveDeliveries(logger, deliveries, options.autoAck)
+	}
+
+	if options.decompression != nil {
+		deliveries = options.decompression.decompressDeliveries(logger, deliveries, options.autoAck)
+	}
+
+	// Tracing wraps the Acknowledger last, so DeliveryContext finds the consumer span.
+	if s.config.Tracing() != nil {
+		deliveries = s.config.Tracing().traceDeliveries(options.queueOpts.name, deliveries, options.autoAck)
+	}
+
+	// Validation settles invalid deliveries through the Acknowledger of the consumer span, so they are traced.
+	if options.validation != nil {
+		deliveries = options.validation.validateDeliveries(logger, conn, deliveries, options.autoAck, options.claimCheck != nil)
+	}
+
 	consumer := &Consumer{
 		options: options,
 		channel: channel,
 		done:    make(chan error),
+		logger:  logger,
 	}
 
 	go handler(deliveries, consumer.done)
 
+	s.health.addConsumer(consumer)
+	consumer.unregister = func() { s.health.removeConsumer(consumer) }
+
 	return consumer, nil
 }
 
@@ -119,11 +180,15 @@ func (c *Consumer) SetCloseContext(ctx context.Context) chan error {
 	return chErr
 }
 
-// Cancel cancels the consumer.
+// Cancel cancels the consumer and removes it from the HealthStatus.
 func (c *Consumer) Cancel() error {
+	c.unregister()
+
 	if err := c.channel.Cancel(c.options.name, true); err != nil {
+		c.logger.Error("consumer cancel failed", "error", err)
 		return err
 	}
+	c.logger.Info("consumer cancelled")
 
 	// Wait for the handler to finish. This is needed because the handler
 	// is running in a goroutine.
@@ -0,0 +1,173 @@
+package myamqp
+
+import (
+	"log/slog"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// DeclareMode controls how an exchange or a queue is declared on the AMQP server.
+type DeclareMode int
+
+const (
+	// DeclareModeDeclare declares the exchange or queue, creating it if it does not exist.
+	// It requires configure permission on the resource.
+	DeclareModeDeclare DeclareMode = iota
+	// DeclareModePassive only verifies that the exchange or queue exists.
+	// It fails with a channel exception when it does not.
+	DeclareModePassive
+	// DeclareModeSkip does not contact the AMQP server at all. A skipped queue is not bound either.
+	DeclareModeSkip
+)
+
+func (m DeclareMode) String() string {
+	switch m {
+	case DeclareModeDeclare:
+		return "declare"
+	case DeclareModePassive:
+		return "passive"
+	case DeclareModeSkip:
+		return "skip"
+	default:
+		return "unknown"
+	}
+}
+
+func declareExchange(logger *slog.Logger, channel Channel, opts *ExchangeOptions) error {
+	var err error
+	switch opts.declareMode {
+	case DeclareModeSkip:
+		return nil
+	case DeclareModePassive:
+		err = channel.ExchangeDeclarePassive(
+			opts.name,
+			opts.kind,
+			opts.durable,
+			opts.autoDelete,
+			opts.internal,
+			opts.noWait,
+			opts.args,
+		)
+	default:
+		err = channel.ExchangeDeclare(
+			opts.name,
+			opts.kind,
+			opts.durable,
+			opts.autoDelete,
+			opts.internal,
+			opts.noWait,
+			opts.args,
+		)
+	}
+
+	if err != nil {
+		logger.Error("exchange declaration failed", "exchange", opts.name, "declare_mode", opts.declareMode, "error", err)
+	}
+
+	return err
+}
+
+func declareQueue(logger *slog.Logger, channel Channel, opts *QueueOptions) error {
+	var err error
+	switch opts.declareMode {
+	case DeclareModeSkip:
+		return nil
+	case DeclareModePassive:
+		_, err = channel.QueueDeclarePassive(
+			opts.name,
+			opts.durable,
+			opts.autoDelete,
+			opts.exclusive,
+			opts.noWait,
+			opts.declareArgs(),
+		)
+	default:
+		_, err = channel.QueueDeclare(
+			opts.name,
+			opts.durable,
+			opts.autoDelete,
+			opts.exclusive,
+			opts.noWait,
+			opts.declareArgs(),
+		)
+	}
+
+	if err != nil {
+		logger.Error("queue declaration failed", "queue", opts.name, "declare_mode", opts.declareMode, "error", err)
+	}
+
+	return err
+}
+
+// Declare declares the exchange and the queue according to their DeclareMode on a temporary channel,
+// and binds the queue to the exchange when both are set. Either can be nil.
+func (s *MyAMQP) Declare(exchangeOpts *ExchangeOptions, queueOpts *QueueOptions) error {
+	conn := s.connection()
+	if conn == nil || conn.IsClosed() {
+		return ErrNotConnected
+	}
+
+	if queueOpts != nil {
+		if err := queueOpts.Validate(); err != nil {
+			return err
+		}
+	}
+
+	logger := s.logger()
+
+	channel, err := conn.Channel()
+	if err != nil {
+		logger.Error("channel open failed", "error", err)
+		return err
+	}
+	defer channel.Close()
+
+	if exchangeOpts != nil {
+		if err = declareExchange(logger, channel, exchangeOpts); err != nil {
+			return err
+		}
+	}
+
+	if queueOpts == nil {
+		return nil
+	}
+
+	if err = declareQueue(logger, channel, queueOpts); err != nil {
+		return err
+	}
+
+	return bindQueue(logger, channel, exchangeOpts, queueOpts)
+}
+
+// bindQueue binds the queue to the exchange. The binding is not created for a skipped queue,
+// which does not contact the AMQP server at all, or when the binding itself is skipped.
+func bindQueue(logger *slog.Logger, channel Channel, exchangeOpts *ExchangeOptions, queueOpts *QueueOptions) error {
+	if exchangeOpts == nil || queueOpts.declareMode == DeclareModeSkip || queueOpts.skipBind {
+		return nil
+	}
+
+	err := channel.QueueBind(
+		queueOpts.name,
+		queueOpts.routingKey,
+		exchangeOpts.name,
+		queueOpts.noWait,
+		queueOpts.args,
+	)
+	if err != nil {
+		logger.Error("queue binding failed", "queue", queueOpts.name, "exchange", exchangeOpts.name, "routing_key", queueOpts.routingKey, "error", err)
+	}
+
+	return err
+}
+
+// logChannelClose logs the closure of the channel.
+func logChannelClose(logger *slog.Logger, channel Channel) {
+	closes := channel.NotifyClose(make(chan *amqp091.Error, 1))
+	go func() {
+		if err, ok := <-closes; ok && err != nil {
+			logger.Warn("channel closed", "error", err)
+			return
+		}
+		logger.Debug("channel closed")
+	}()
+}
@@ -0,0 +1,135 @@
+package myamqp_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestDeclareModes(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares the broker before Declare.
+		setup    func(t *testing.T, amqp *myamqp.MyAMQP)
+		exchange *myamqp.ExchangeOptions
+		queue    *myamqp.QueueOptions
+		// wantCode is the AMQP reply code Declare fails with, 0 when it succeeds.
+		wantCode  int
+		wantQueue bool
+		wantBound bool
+	}{
+		{
+			name:      "declare creates and binds",
+			exchange:  myamqp.NewExchangeOptions("orders", myamqp.ExchangeTypeDirect),
+			queue:     myamqp.NewQueueOptions("orders.created").WithRoutingKey("created"),
+			wantQueue: true,
+			wantBound: true,
+		},
+		{
+			name:     "passive exchange must exist",
+			exchange: myamqp.NewExchangeOptions("orders", myamqp.ExchangeTypeDirect).WithDeclareMode(myamqp.DeclareModePassive),
+			queue:    myamqp.NewQueueOptions("orders.created").WithRoutingKey("created"),
+			wantCode: amqp091.NotFound,
+		},
+		{
+			name:     "passive queue must exist",
+			exchange: myamqp.NewExchangeOptions("orders", myamqp.ExchangeTypeDirect),
+			queue:    myamqp.NewQueueOptions("orders.created").WithRoutingKey("created").WithDeclareMode(myamqp.DeclareModePassive),
+			wantCode: amqp091.NotFound,
+		},
+		{
+			name: "passive queue is bound",
+			setup: func(t *testing.T, amqp *myamqp.MyAMQP) {
+				if err := amqp.Declare(nil, myamqp.NewQueueOptions("orders.created")); err != nil {
+					t.Fatalf("Declare: %v", err)
+				}
+			},
+			exchange:  myamqp.NewExchangeOptions("orders", myamqp.ExchangeTypeDirect),
+			queue:     myamqp.NewQueueOptions("orders.created").WithRoutingKey("created").WithDeclareMode(myamqp.DeclareModePassive),
+			wantQueue: true,
+			wantBound: true,
+		},
+		{
+			name: "passive queue with skipped binding",
+			setup: func(t *testing.T, amqp *myamqp.MyAMQP) {
+				if err := amqp.Declare(nil, myamqp.NewQueueOptions("orders.created")); err != nil {
+					t.Fatalf("Declare: %v", err)
+				}
+			},
+			exchange: myamqp.NewExchangeOptions("orders", myamqp.ExchangeTypeDirect),
+			queue: myamqp.NewQueueOptions("orders.created").WithRoutingKey("created").
+				WithDeclareMode(myamqp.DeclareModePassive).WithSkipBind(true),
+			wantQueue: true,
+		},
+		{
+			name:     "skipped queue is neither declared nor bound",
+			exchange: myamqp.NewExchangeOptions("orders", myamqp.ExchangeTypeDirect),
+			queue:    myamqp.NewQueueOptions("orders.created").WithRoutingKey("created").WithDeclareMode(myamqp.DeclareModeSkip),
+		},
+		{
+			name: "skipped exchange is bound",
+			setup: func(t *testing.T, amqp *myamqp.MyAMQP) {
+				if err := amqp.Declare(myamqp.NewExchangeOptions("orders", myamqp.ExchangeTypeDirect), nil); err != nil {
+					t.Fatalf("Declare: %v", err)
+				}
+			},
+			exchange:  myamqp.NewExchangeOptions("orders", myamqp.ExchangeTypeDirect).WithDeclareMode(myamqp.DeclareModeSkip),
+			queue:     myamqp.NewQueueOptions("orders.created").WithRoutingKey("created"),
+			wantQueue: true,
+			wantBound: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newBroker(t)
+			amqp := connect(t, broker)
+			if tt.setup != nil {
+				tt.setup(t, amqp)
+			}
+
+			err := amqp.Declare(tt.exchange, tt.queue)
+			if tt.wantCode != 0 {
+				var amqpErr *amqp091.Error
+				if !errors.As(err, &amqpErr) || amqpErr.Code != tt.wantCode {
+					t.Fatalf("Declare: got error %v, want code %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Declare: %v", err)
+			}
+
+			if _, ok := broker.Queue("orders.created"); ok != tt.wantQueue {
+				t.Fatalf("queue exists %v, want %v", ok, tt.wantQueue)
+			}
+			if !tt.wantQueue {
+				return
+			}
+
+			if err = broker.Publish("orders", "created", amqp091.Publishing{Body: []byte("order")}); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+			if bound := len(broker.Messages("orders.created")) == 1; bound != tt.wantBound {
+				t.Errorf("queue bound %v, want %v", bound, tt.wantBound)
+			}
+		})
+	}
+}
+
+func TestDeclareNotConnected(t *testing.T) {
+	config, err := myamqp.NewConfig(newBroker(t).Dial)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	amqp, err := myamqp.New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if err = amqp.Declare(nil, myamqp.NewQueueOptions("queue")); !errors.Is(err, myamqp.ErrNotConnected) {
+		t.Errorf("Declare: got error %v, want %v", err, myamqp.ErrNotConnected)
+	}
+}
@@ -0,0 +1,407 @@
+package myamqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrReplayNacked   = errors.New("replayed message nacked")
+	ErrReplayReturned = errors.New("replayed message returned")
+)
+
+// ReplaySequenceHeader is the header set on messages replayed with the mandatory flag, holding the position of the
+// message in the dump, to match a returned message to the replayed one.
+const ReplaySequenceHeader = "x-replay-sequence"
+
+// DefaultReplayMaxInFlight is the number of replayed messages awaiting their confirm by default.
+const DefaultReplayMaxInFlight = 100
+
+// DumpOptions represents options for dumping a queue.
+type DumpOptions struct {
+	max    int
+	remove bool
+}
+
+// NewDumpOptions creates a new DumpOptions, dumping all messages and leaving them in the queue.
+func NewDumpOptions() *DumpOptions {
+	return &DumpOptions{}
+}
+
+// WithMax sets the max number of messages dumped on the DumpOptions. 0 dumps all messages.
+func (do *DumpOptions) WithMax(max int) *DumpOptions {
+	do.max = max
+	return do
+}
+
+// WithRemove sets the remove on the DumpOptions.
+// When true, the messages are acked once the dump is flushed, otherwise they are requeued.
+func (do *DumpOptions) WithRemove(remove bool) *DumpOptions {
+	do.remove = remove
+	return do
+}
+
+// Dump gets the messages of the queue and writes them to the DumpWriter, and returns the number of messages written.
+// The messages are held unacknowledged until the dump is flushed, so each one is dumped once, and then
+// acked or requeued according to the DumpOptions. On error, all messages are requeued, and the number of messages
+// written before the error is returned. Options can be nil.
+func (s *MyAMQP) Dump(ctx context.Context, queue string, w *DumpWriter, options *DumpOptions) (int, error) {
+	conn := s.connection()
+	if conn == nil || conn.IsClosed() {
+		return 0, ErrNotConnected
+	}
+
+	if options == nil {
+		options = NewDumpOptions()
+	}
+
+	logger := s.logger().With("queue", queue)
+
+	channel, err := conn.Channel()
+	if err != nil {
+		logger.Error("channel open failed", "error", err)
+		return 0, err
+	}
+	// Closing the channel requeues the messages not acked.
+	defer channel.Close()
+
+	var n int
+	var last amqp091.Delivery
+	for options.max == 0 || n < options.max {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+
+		d, ok, err := channel.Get(queue, false)
+		if err != nil {
+			logger.Error("get failed", "error", err)
+			return n, err
+		}
+		if !ok {
+			break
+		}
+
+		// The dump keeps the UserId, unlike a Publishing republished from a delivery.
+		msg := publishingFromDelivery(d)
+		msg.UserId = d.UserId
+
+		err = w.Write(DumpedMessage{
+			Exchange:    d.Exchange,
+			RoutingKey:  d.RoutingKey,
+			Redelivered: d.Redelivered,
+			Publishing:  msg,
+		})
+		if err != nil {
+			return n, err
+		}
+
+		n++
+		last = d
+	}
+
+	if err = w.Flush(); err != nil {
+		return n, err
+	}
+
+	if options.remove && n > 0 {
+		if err = last.Ack(true); err != nil {
+			logger.Error("ack failed", "error", err)
+			return n, err
+		}
+	}
+
+	logger.Info("queue dumped", "count", n, "remove", options.remove)
+
+	return n, nil
+}
+
+// ReplayOptions represents options for replaying a dump.
+type ReplayOptions struct {
+	exchangeOpts *ExchangeOptions
+	routingKey   func(m DumpedMessage) string
+	mandatory    bool
+	rate         float64
+	maxInFlight  int
+	skip         int
+	stripUserID  bool
+	progress     func(p ReplayProgress)
+}
+
+// NewReplayOptions creates a new ReplayOptions replaying to the exchange of the given ExchangeOptions,
+// with the original routing keys.
+func NewReplayOptions(exchangeOptions *ExchangeOptions) *ReplayOptions {
+	return &ReplayOptions{
+		exchangeOpts: exchangeOptions,
+		maxInFlight:  DefaultReplayMaxInFlight,
+	}
+}
+
+// WithRoutingKey sets the routing key of all replayed messages on the ReplayOptions.
+func (ro *ReplayOptions) WithRoutingKey(routingKey string) *ReplayOptions {
+	ro.routingKey = func(DumpedMessage) string { return routingKey }
+	return ro
+}
+
+// WithRoutingKeyFunc sets a function returning the routing key of each replayed message on the ReplayOptions.
+func (ro *ReplayOptions) WithRoutingKeyFunc(routingKey func(m DumpedMessage) string) *ReplayOptions {
+	ro.routingKey = routingKey
+	return ro
+}
+
+// WithMandatory sets the mandatory flag on the ReplayOptions.
+// When true, the replay fails with ErrReplayReturned when a message cannot be routed,
+// and the replayed messages have the ReplaySequenceHeader.
+func (ro *ReplayOptions) WithMandatory(mandatory bool) *ReplayOptions {
+	ro.mandatory = mandatory
+	return ro
+}
+
+// WithRate sets the max number of messages published per second on the ReplayOptions. 0 does not limit.
+func (ro *ReplayOptions) WithRate(perSecond float64) *ReplayOptions {
+	ro.rate = perSecond
+	return ro
+}
+
+// WithMaxInFlight sets the max number of messages awaiting their confirm on the ReplayOptions.
+func (ro *ReplayOptions) WithMaxInFlight(maxInFlight int) *ReplayOptions {
+	ro.maxInFlight = maxInFlight
+	return ro
+}
+
+// WithSkip sets the number of messages skipped at the start of the dump on the ReplayOptions,
+// e.g. the Offset of the ReplayProgress of an interrupted replay to resume it.
+func (ro *ReplayOptions) WithSkip(skip int) *ReplayOptions {
+	ro.skip = skip
+	return ro
+}
+
+// WithStripUserID sets the stripUserID on the ReplayOptions. When true, the UserId of the dumped messages
+// is not replayed, as the AMQP server rejects a message whose UserId is not the user of the connection.
+func (ro *ReplayOptions) WithStripUserID(stripUserID bool) *ReplayOptions {
+	ro.stripUserID = stripUserID
+	return ro
+}
+
+// WithProgress sets a function called after each confirmed message on the ReplayOptions.
+func (ro *ReplayOptions) WithProgress(progress func(p ReplayProgress)) *ReplayOptions {
+	ro.progress = progress
+	return ro
+}
+
+// ReplayProgress represents the progress of a replay.
+type ReplayProgress struct {
+	// Offset is the number of messages from the start of the dump which are skipped or confirmed.
+	// A replay resumed with WithSkip(Offset) does not publish any message twice.
+	Offset int
+	// Published is the number of messages published, Confirmed the number of them confirmed.
+	Published int
+	Confirmed int
+}
+
+// Replay publishes the messages of the DumpReader with publisher confirms, keeping up to the max in flight
+// messages awaiting their confirm, and returns the progress. On error, the progress tells where to resume.
+func (s *MyAMQP) Replay(ctx context.Context, r *DumpReader, options *ReplayOptions) (ReplayProgress, error) {
+	var progress ReplayProgress
+
+	conn := s.connection()
+	if conn == nil || conn.IsClosed() {
+		return progress, ErrNotConnected
+	}
+
+	if options == nil {
+		return progress, ErrOptionsCannotBeNil
+	}
+
+	if options.exchangeOpts == nil {
+		return progress, ErrExchangeOptionsCannotBeNil
+	}
+
+	logger := s.logger().With("exchange", options.exchangeOpts.name)
+
+	channel, err := conn.Channel()
+	if err != nil {
+		logger.Error("channel open failed", "error", err)
+		return progress, err
+	}
+	defer channel.Close()
+
+	if err = channel.Confirm(false); err != nil {
+		logger.Error("confirm mode failed", "error", err)
+		return progress, err
+	}
+
+	if err = declareExchange(logger, channel, options.exchangeOpts); err != nil {
+		return progress, err
+	}
+
+	var returns *replayReturns
+	if options.mandatory {
+		// The returns channel is unbuffered, so a return is received before the confirm of its message.
+		returns = watchReplayReturns(channel.NotifyReturn(make(chan amqp091.Return)))
+	}
+
+	type replayed struct {
+		confirm  *amqp091.DeferredConfirmation
+		sequence int64
+	}
+
+	var inFlight []replayed
+	confirmOldest := func() error {
+		oldest := inFlight[0]
+		acked, err := oldest.confirm.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !acked {
+			return fmt.Errorf("%w: message %d", ErrReplayNacked, oldest.sequence+1)
+		}
+		if returns != nil {
+			if ret, ok := returns.take(oldest.sequence); ok {
+				return fmt.Errorf("%w: message %d: %d %s", ErrReplayReturned, oldest.sequence+1, ret.ReplyCode, ret.ReplyText)
+			}
+		}
+
+		inFlight = inFlight[1:]
+		progress.Offset++
+		progress.Confirmed++
+		if options.progress != nil {
+			options.progress(progress)
+		}
+		return nil
+	}
+
+	limiter := newRateLimiter(options.rate)
+
+	for read := 0; ; read++ {
+		m, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return progress, err
+		}
+
+		if read < options.skip {
+			progress.Offset++
+			continue
+		}
+
+		for len(inFlight) > 0 && len(inFlight) >= options.maxInFlight {
+			if err = confirmOldest(); err != nil {
+				return progress, err
+			}
+		}
+
+		if err = limiter.wait(ctx); err != nil {
+			return progress, err
+		}
+
+		routingKey := m.RoutingKey
+		if options.routingKey != nil {
+			routingKey = options.routingKey(m)
+		}
+
+		msg := m.Publishing
+		if options.mandatory {
+			msg.Headers = make(amqp091.Table, len(m.Publishing.Headers)+1)
+			for k, v := range m.Publishing.Headers {
+				msg.Headers[k] = v
+			}
+			msg.Headers[ReplaySequenceHeader] = int64(read)
+		}
+		if options.stripUserID {
+			msg.UserId = ""
+		}
+
+		confirm, err := channel.PublishWithDeferredConfirmWithContext(
+			ctx,
+			options.exchangeOpts.name,
+			routingKey,
+			options.mandatory,
+			false,
+			msg,
+		)
+		if err != nil {
+			logger.Error("publish failed", "routing_key", routingKey, "error", err)
+			return progress, err
+		}
+		inFlight = append(inFlight, replayed{confirm: confirm, sequence: int64(read)})
+		progress.Published++
+	}
+
+	for len(inFlight) > 0 {
+		if err = confirmOldest(); err != nil {
+			return progress, err
+		}
+	}
+
+	logger.Info("dump replayed", "published", progress.Published, "offset", progress.Offset)
+
+	return progress, nil
+}
+
+// replayReturns receives the messages returned during a replay, so the channel never blocks on them,
+// and matches them to the replayed messages by their ReplaySequenceHeader.
+type replayReturns struct {
+	lookup chan replayLookup
+	done   chan struct{}
+}
+
+type replayLookup struct {
+	sequence int64
+	reply    chan *amqp091.Return
+}
+
+// watchReplayReturns receives the returns until the channel closes them.
+func watchReplayReturns(returns <-chan amqp091.Return) *replayReturns {
+	r := &replayReturns{
+		lookup: make(chan replayLookup),
+		done:   make(chan struct{}),
+	}
+	go r.run(returns)
+	return r
+}
+
+func (r *replayReturns) run(returns <-chan amqp091.Return) {
+	defer close(r.done)
+
+	returned := make(map[int64]amqp091.Return)
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				return
+			}
+			if sequence, ok := ret.Headers[ReplaySequenceHeader].(int64); ok {
+				returned[sequence] = ret
+			}
+		case l := <-r.lookup:
+			if ret, ok := returned[l.sequence]; ok {
+				delete(returned, l.sequence)
+				l.reply <- &ret
+			} else {
+				l.reply <- nil
+			}
+		}
+	}
+}
+
+// take returns the returned message with the sequence. It is called once the message is confirmed,
+// its return, if any, being received before the confirm.
+func (r *replayReturns) take(sequence int64) (amqp091.Return, bool) {
+	reply := make(chan *amqp091.Return, 1)
+	select {
+	case r.lookup <- replayLookup{sequence: sequence, reply: reply}:
+	case <-r.done:
+		return amqp091.Return{}, false
+	}
+
+	if ret := <-reply; ret != nil {
+		return *ret, true
+	}
+	return amqp091.Return{}, false
+}
@@ -0,0 +1,141 @@
+package myamqp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// dumpQueue dumps the orders queue in the format and returns the dump.
+func dumpQueue(t *testing.T, amqp *myamqp.MyAMQP, format myamqp.DumpFormat, options *myamqp.DumpOptions) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := myamqp.NewDumpWriter(&buf, format)
+	if err != nil {
+		t.Fatalf("NewDumpWriter: %v", err)
+	}
+	if _, err = amqp.Dump(context.Background(), "orders", w, options); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	return &buf
+}
+
+func TestDumpAndReplay(t *testing.T) {
+	for _, format := range []myamqp.DumpFormat{myamqp.DumpFormatJSONL, myamqp.DumpFormatBinary} {
+		t.Run(format.String(), func(t *testing.T) {
+			broker := newBroker(t)
+			if err := broker.DeclareQueue("orders", "", "", nil); err != nil {
+				t.Fatalf("DeclareQueue: %v", err)
+			}
+			amqp := connect(t, broker)
+
+			original := amqp091.Publishing{
+				Headers:   amqp091.Table{"attempt": int32(2), "tenant": "acme"},
+				MessageId: "1",
+				UserId:    "orders-service",
+				Priority:  3,
+				Body:      []byte(`{"id":1}`),
+			}
+			broker.Publish("", "orders", original)
+			broker.Publish("", "orders", amqp091.Publishing{MessageId: "2"})
+
+			dump := dumpQueue(t, amqp, format, myamqp.NewDumpOptions().WithRemove(true))
+			waitFor(t, "the dumped messages to be removed", func() bool {
+				info, _ := broker.Queue("orders")
+				return info.Messages == 0 && info.Unacked == 0
+			})
+
+			r, err := myamqp.NewDumpReader(bytes.NewReader(dump.Bytes()), format)
+			if err != nil {
+				t.Fatalf("NewDumpReader: %v", err)
+			}
+			m, err := r.Read()
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if m.RoutingKey != "orders" || m.Publishing.UserId != original.UserId || m.Publishing.MessageId != "1" ||
+				m.Publishing.Priority != 3 || m.Publishing.Headers["attempt"] != int32(2) || string(m.Publishing.Body) != `{"id":1}` {
+				t.Errorf("unexpected dumped message %+v", m)
+			}
+
+			// The dump is replayed with the UserId unless it is stripped.
+			for _, strip := range []bool{false, true} {
+				r, _ = myamqp.NewDumpReader(bytes.NewReader(dump.Bytes()), format)
+				progress, err := amqp.Replay(context.Background(), r, myamqp.NewReplayOptions(defaultExchange()).WithStripUserID(strip))
+				if err != nil {
+					t.Fatalf("Replay: %v", err)
+				}
+				if progress.Published != 2 || progress.Confirmed != 2 || progress.Offset != 2 {
+					t.Errorf("got progress %+v", progress)
+				}
+
+				messages := broker.Messages("orders")
+				if len(messages) != 2 {
+					t.Fatalf("got %d replayed messages, want 2", len(messages))
+				}
+				wantUserID := original.UserId
+				if strip {
+					wantUserID = ""
+				}
+				if messages[0].UserId != wantUserID || messages[0].MessageId != "1" || messages[1].MessageId != "2" {
+					t.Errorf("strip %v: unexpected replayed messages %+v", strip, messages)
+				}
+				dumpQueue(t, amqp, format, myamqp.NewDumpOptions().WithRemove(true))
+			}
+		})
+	}
+}
+
+func TestDumpRequeues(t *testing.T) {
+	broker := newBroker(t)
+	broker.DeclareQueue("orders", "", "", nil)
+	amqp := connect(t, broker)
+	for _, id := range []string{"1", "2", "3"} {
+		broker.Publish("", "orders", amqp091.Publishing{MessageId: id})
+	}
+
+	dump := dumpQueue(t, amqp, myamqp.DumpFormatJSONL, myamqp.NewDumpOptions().WithMax(2))
+	waitFor(t, "the dumped messages to be requeued", func() bool {
+		info, _ := broker.Queue("orders")
+		return info.Messages == 3 && info.Unacked == 0
+	})
+
+	r, _ := myamqp.NewDumpReader(dump, myamqp.DumpFormatJSONL)
+	var n int
+	for ; ; n++ {
+		if _, err := r.Read(); err != nil {
+			break
+		}
+	}
+	if n != 2 {
+		t.Errorf("got %d dumped messages, want 2", n)
+	}
+}
+
+func TestReplayReturned(t *testing.T) {
+	broker := newBroker(t)
+	broker.DeclareQueue("orders", "", "", nil)
+	amqp := connect(t, broker)
+
+	var buf bytes.Buffer
+	w, _ := myamqp.NewDumpWriter(&buf, myamqp.DumpFormatJSONL)
+	for _, routingKey := range []string{"orders", "orders", "missing", "orders"} {
+		w.Write(myamqp.DumpedMessage{RoutingKey: routingKey})
+	}
+	w.Flush()
+
+	r, _ := myamqp.NewDumpReader(&buf, myamqp.DumpFormatJSONL)
+	progress, err := amqp.Replay(context.Background(), r, myamqp.NewReplayOptions(defaultExchange()).WithMandatory(true))
+	if !errors.Is(err, myamqp.ErrReplayReturned) {
+		t.Fatalf("Replay: got error %v, want %v", err, myamqp.ErrReplayReturned)
+	}
+	// Resuming at the offset replays the returned message first.
+	if progress.Offset != 2 {
+		t.Errorf("got offset %d, want 2", progress.Offset)
+	}
+}
@@ -0,0 +1,417 @@
+package myamqp
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrUnknownDumpFormat = errors.New("unknown dump format")
+	ErrInvalidDump       = errors.New("invalid dump")
+)
+
+// DumpFormat represents the file format of a dump.
+type DumpFormat int
+
+const (
+	// DumpFormatJSONL writes a JSON object per message and line. The body is base64 encoded
+	// and each header is tagged with its AMQP type, so that it is replayed with the same type.
+	DumpFormatJSONL DumpFormat = iota
+	// DumpFormatBinary writes a gob stream, more compact than DumpFormatJSONL.
+	DumpFormatBinary
+)
+
+func (f DumpFormat) String() string {
+	switch f {
+	case DumpFormatJSONL:
+		return "jsonl"
+	case DumpFormatBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("DumpFormat(%d)", int(f))
+	}
+}
+
+// ParseDumpFormat parses "jsonl" or "binary" into a DumpFormat.
+func ParseDumpFormat(s string) (DumpFormat, error) {
+	switch s {
+	case "jsonl":
+		return DumpFormatJSONL, nil
+	case "binary":
+		return DumpFormatBinary, nil
+	default:
+		return 0, fmt.Errorf("%w %q", ErrUnknownDumpFormat, s)
+	}
+}
+
+func init() {
+	// Concrete types of header values, which gob must know to encode them in a Table.
+	gob.Register(amqp091.Table{})
+	gob.Register([]interface{}{})
+	gob.Register(amqp091.Decimal{})
+	gob.Register(time.Time{})
+}
+
+// DumpedMessage represents a message in a dump.
+type DumpedMessage struct {
+	Exchange    string
+	RoutingKey  string
+	Redelivered bool
+	Publishing  amqp091.Publishing
+}
+
+// DumpWriter writes messages to a dump.
+type DumpWriter struct {
+	format DumpFormat
+	w      *bufio.Writer
+	json   *json.Encoder
+	gob    *gob.Encoder
+}
+
+// NewDumpWriter creates a new DumpWriter writing to w in the given DumpFormat.
+// Writes are buffered, call Flush once done.
+func NewDumpWriter(w io.Writer, format DumpFormat) (*DumpWriter, error) {
+	dw := &DumpWriter{format: format, w: bufio.NewWriter(w)}
+
+	switch format {
+	case DumpFormatJSONL:
+		dw.json = json.NewEncoder(dw.w)
+	case DumpFormatBinary:
+		dw.gob = gob.NewEncoder(dw.w)
+	default:
+		return nil, fmt.Errorf("%w %s", ErrUnknownDumpFormat, format)
+	}
+
+	return dw, nil
+}
+
+// Write writes the message to the dump.
+func (w *DumpWriter) Write(m DumpedMessage) error {
+	if w.format == DumpFormatBinary {
+		return w.gob.Encode(m)
+	}
+
+	record, err := newDumpRecord(m)
+	if err != nil {
+		return err
+	}
+
+	return w.json.Encode(record)
+}
+
+// Flush writes the buffered messages to the underlying io.Writer.
+func (w *DumpWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// DumpReader reads messages from a dump.
+type DumpReader struct {
+	format DumpFormat
+	json   *json.Decoder
+	gob    *gob.Decoder
+	n      int
+}
+
+// NewDumpReader creates a new DumpReader reading from r in the given DumpFormat.
+func NewDumpReader(r io.Reader, format DumpFormat) (*DumpReader, error) {
+	dr := &DumpReader{format: format}
+	br := bufio.NewReader(r)
+
+	switch format {
+	case DumpFormatJSONL:
+		dr.json = json.NewDecoder(br)
+	case DumpFormatBinary:
+		dr.gob = gob.NewDecoder(br)
+	default:
+		return nil, fmt.Errorf("%w %s", ErrUnknownDumpFormat, format)
+	}
+
+	return dr, nil
+}
+
+// Read reads the next message of the dump. It returns io.EOF at the end of the dump.
+func (r *DumpReader) Read() (DumpedMessage, error) {
+	var m DumpedMessage
+	var err error
+
+	if r.format == DumpFormatBinary {
+		err = r.gob.Decode(&m)
+	} else {
+		var record dumpRecord
+		if err = r.json.Decode(&record); err == nil {
+			m, err = record.message()
+		}
+	}
+
+	// A dump truncated within a message fails with io.ErrUnexpectedEOF.
+	if err == io.EOF {
+		return m, io.EOF
+	}
+	if err != nil {
+		return m, fmt.Errorf("%w: message %d: %v", ErrInvalidDump, r.n+1, err)
+	}
+	r.n++
+
+	return m, nil
+}
+
+// dumpRecord is a DumpedMessage in DumpFormatJSONL.
+type dumpRecord struct {
+	Exchange        string                `json:"exchange"`
+	RoutingKey      string                `json:"routing_key"`
+	Redelivered     bool                  `json:"redelivered,omitempty"`
+	ContentType     string                `json:"content_type,omitempty"`
+	ContentEncoding string                `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8                 `json:"delivery_mode,omitempty"`
+	Priority        uint8                 `json:"priority,omitempty"`
+	CorrelationID   string                `json:"correlation_id,omitempty"`
+	ReplyTo         string                `json:"reply_to,omitempty"`
+	Expiration      string                `json:"expiration,omitempty"`
+	MessageID       string                `json:"message_id,omitempty"`
+	Timestamp       *time.Time            `json:"timestamp,omitempty"`
+	Type            string                `json:"type,omitempty"`
+	UserID          string                `json:"user_id,omitempty"`
+	AppID           string                `json:"app_id,omitempty"`
+	Headers         map[string]typedValue `json:"headers,omitempty"`
+	Body            []byte                `json:"body"`
+}
+
+func newDumpRecord(m DumpedMessage) (dumpRecord, error) {
+	p := m.Publishing
+	record := dumpRecord{
+		Exchange:        m.Exchange,
+		RoutingKey:      m.RoutingKey,
+		Redelivered:     m.Redelivered,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationID:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageID:       p.MessageId,
+		Type:            p.Type,
+		UserID:          p.UserId,
+		AppID:           p.AppId,
+		Body:            p.Body,
+	}
+	if !p.Timestamp.IsZero() {
+		ts := p.Timestamp
+		record.Timestamp = &ts
+	}
+
+	if p.Headers != nil {
+		headers, err := encodeTypedTable(p.Headers)
+		if err != nil {
+			return record, err
+		}
+		record.Headers = headers
+	}
+
+	return record, nil
+}
+
+func (r dumpRecord) message() (DumpedMessage, error) {
+	m := DumpedMessage{
+		Exchange:    r.Exchange,
+		RoutingKey:  r.RoutingKey,
+		Redelivered: r.Redelivered,
+		Publishing: amqp091.Publishing{
+			ContentType:     r.ContentType,
+			ContentEncoding: r.ContentEncoding,
+			DeliveryMode:    r.DeliveryMode,
+			Priority:        r.Priority,
+			CorrelationId:   r.CorrelationID,
+			ReplyTo:         r.ReplyTo,
+			Expiration:      r.Expiration,
+			MessageId:       r.MessageID,
+			Type:            r.Type,
+			UserId:          r.UserID,
+			AppId:           r.AppID,
+			Body:            r.Body,
+		},
+	}
+	if r.Timestamp != nil {
+		m.Publishing.Timestamp = *r.Timestamp
+	}
+
+	if r.Headers != nil {
+		headers, err := decodeTypedTable(r.Headers)
+		if err != nil {
+			return m, err
+		}
+		m.Publishing.Headers = headers
+	}
+
+	return m, nil
+}
+
+// typedValue is a header value tagged with its AMQP type, e.g. {"type":"int32","value":5}.
+type typedValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// typedDecimal is the value of a decimal typedValue.
+type typedDecimal struct {
+	Scale uint8 `json:"scale"`
+	Value int32 `json:"value"`
+}
+
+func encodeTypedTable(t amqp091.Table) (map[string]typedValue, error) {
+	out := make(map[string]typedValue, len(t))
+	for k, v := range t {
+		tv, err := encodeTypedValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", k, err)
+		}
+		out[k] = tv
+	}
+	return out, nil
+}
+
+func encodeTypedValue(v interface{}) (typedValue, error) {
+	var typ string
+	switch value := v.(type) {
+	case nil:
+		return typedValue{Type: "void"}, nil
+	case bool:
+		typ = "bool"
+	case int8:
+		typ = "int8"
+	case uint8:
+		typ = "uint8"
+	case int16:
+		typ = "int16"
+	case uint16:
+		typ = "uint16"
+	case int32:
+		typ = "int32"
+	case uint32:
+		typ = "uint32"
+	case int64:
+		typ = "int64"
+	case int:
+		typ, v = "int64", int64(value)
+	case float32:
+		typ = "float32"
+	case float64:
+		typ = "float64"
+	case string:
+		typ = "string"
+	case []byte:
+		typ = "bytes"
+	case time.Time:
+		typ = "timestamp"
+	case amqp091.Decimal:
+		typ, v = "decimal", typedDecimal{Scale: value.Scale, Value: value.Value}
+	case amqp091.Table:
+		table, err := encodeTypedTable(value)
+		if err != nil {
+			return typedValue{}, err
+		}
+		typ, v = "table", table
+	case []interface{}:
+		array := make([]typedValue, 0, len(value))
+		for _, item := range value {
+			tv, err := encodeTypedValue(item)
+			if err != nil {
+				return typedValue{}, err
+			}
+			array = append(array, tv)
+		}
+		typ, v = "array", array
+	default:
+		return typedValue{}, fmt.Errorf("unsupported header value type %T", v)
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return typedValue{}, err
+	}
+
+	return typedValue{Type: typ, Value: raw}, nil
+}
+
+func decodeTypedTable(t map[string]typedValue) (amqp091.Table, error) {
+	out := make(amqp091.Table, len(t))
+	for k, tv := range t {
+		v, err := decodeTypedValue(tv)
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", k, err)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+func decodeTypedValue(tv typedValue) (interface{}, error) {
+	switch tv.Type {
+	case "void":
+		return nil, nil
+	case "bool":
+		return decodeRaw[bool](tv.Value)
+	case "int8":
+		return decodeRaw[int8](tv.Value)
+	case "uint8":
+		return decodeRaw[uint8](tv.Value)
+	case "int16":
+		return decodeRaw[int16](tv.Value)
+	case "uint16":
+		return decodeRaw[uint16](tv.Value)
+	case "int32":
+		return decodeRaw[int32](tv.Value)
+	case "uint32":
+		return decodeRaw[uint32](tv.Value)
+	case "int64":
+		return decodeRaw[int64](tv.Value)
+	case "float32":
+		return decodeRaw[float32](tv.Value)
+	case "float64":
+		return decodeRaw[float64](tv.Value)
+	case "string":
+		return decodeRaw[string](tv.Value)
+	case "bytes":
+		return decodeRaw[[]byte](tv.Value)
+	case "timestamp":
+		return decodeRaw[time.Time](tv.Value)
+	case "decimal":
+		d, err := decodeRaw[typedDecimal](tv.Value)
+		return amqp091.Decimal{Scale: d.Scale, Value: d.Value}, err
+	case "table":
+		table, err := decodeRaw[map[string]typedValue](tv.Value)
+		if err != nil {
+			return nil, err
+		}
+		return decodeTypedTable(table)
+	case "array":
+		array, err := decodeRaw[[]typedValue](tv.Value)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, 0, len(array))
+		for _, item := range array {
+			v, err := decodeTypedValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unknown header value type %q", tv.Type)
+	}
+}
+
+func decodeRaw[T any](raw json.RawMessage) (T, error) {
+	var v T
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
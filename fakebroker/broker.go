@@ -0,0 +1,700 @@
+// Package fakebroker provides an in-process AMQP 0-9-1 broker for unit testing code using myamqp
+// without a running RabbitMQ.
+//
+// The broker speaks the AMQP wire protocol, so it is used through a regular DialFunc:
+//
+//	broker := fakebroker.New()
+//	defer broker.Close()
+//
+//	config, err := myamqp.NewConfig(broker.Dial)
+//
+// It implements direct, fanout, topic and headers exchanges, exchange-to-exchange bindings,
+// queues with acks, nacks, requeue, prefetch, message TTL, max length, priorities, single active consumer,
+// delivery limits and dead-lettering, publisher confirms, mandatory returns, direct reply-to and connection.blocked.
+// All state is kept in memory, durability flags are accepted but have no effect.
+package fakebroker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrBrokerClosed     = errors.New("fakebroker: broker closed")
+	ErrExchangeNotFound = errors.New("fakebroker: exchange not found")
+)
+
+const (
+	// DefaultFrameMax is the maximum frame size proposed to clients.
+	DefaultFrameMax = 131072
+	// DefaultVhost is the vhost used by Dial and the inspection methods.
+	DefaultVhost = "/"
+
+	directReplyTo       = "amq.rabbitmq.reply-to"
+	directReplyToPrefix = "amq.rabbitmq.reply-to."
+)
+
+// Broker is an in-process AMQP 0-9-1 broker.
+type Broker struct {
+	vhosts    map[string]*vhost
+	conns     map[*serverConn]struct{}
+	listeners map[net.Listener]struct{}
+	connID    uint64
+	heartbeat time.Duration
+	frameMax  uint32
+	blocked   bool
+	reason    string
+	unblocked chan struct{}
+	closed    bool
+	mu        sync.Mutex
+}
+
+// New creates a new Broker with the default vhost.
+func New() *Broker {
+	unblocked := make(chan struct{})
+	close(unblocked)
+
+	b := &Broker{
+		vhosts:    make(map[string]*vhost),
+		conns:     make(map[*serverConn]struct{}),
+		listeners: make(map[net.Listener]struct{}),
+		frameMax:  DefaultFrameMax,
+		unblocked: unblocked,
+	}
+	b.vhost(DefaultVhost)
+
+	return b
+}
+
+// WithHeartbeat sets the heartbeat interval proposed to clients. Defaults to 0, leaving it to the client.
+func (b *Broker) WithHeartbeat(heartbeat time.Duration) *Broker {
+	b.heartbeat = heartbeat
+	return b
+}
+
+// WithFrameMax sets the maximum frame size proposed to clients. Defaults to DefaultFrameMax.
+func (b *Broker) WithFrameMax(frameMax uint32) *Broker {
+	b.frameMax = frameMax
+	return b
+}
+
+// Dial connects to the broker in-process, on the default vhost. It has the signature of myamqp.DialFunc.
+func (b *Broker) Dial() (*amqp091.Connection, error) {
+	return b.DialConfig(amqp091.Config{})
+}
+
+// DialConfig connects to the broker in-process with the given config. Any credentials are accepted.
+func (b *Broker) DialConfig(config amqp091.Config) (*amqp091.Connection, error) {
+	if config.SASL == nil {
+		config.SASL = []amqp091.Authentication{&amqp091.PlainAuth{Username: "guest", Password: "guest"}}
+	}
+	if config.Vhost == "" {
+		config.Vhost = DefaultVhost
+	}
+
+	client, server := net.Pipe()
+	if err := b.ServeConn(server); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return amqp091.Open(client, config)
+}
+
+// ServeConn serves the AMQP protocol on the given connection.
+func (b *Broker) ServeConn(conn net.Conn) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		conn.Close()
+		return ErrBrokerClosed
+	}
+
+	b.connID++
+	c := newServerConn(b, conn, b.connID)
+	b.conns[c] = struct{}{}
+	go c.serve()
+
+	return nil
+}
+
+// Listen accepts AMQP connections on the given TCP address, e.g. "127.0.0.1:0".
+// It returns the address to dial, for example with amqp091.Dial("amqp://guest:guest@" + addr.String()).
+func (b *Broker) Listen(address string) (net.Addr, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		l.Close()
+		return nil, ErrBrokerClosed
+	}
+	b.listeners[l] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if b.ServeConn(conn) != nil {
+				return
+			}
+		}
+	}()
+
+	return l.Addr(), nil
+}
+
+// Close closes all listeners and client connections.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	for l := range b.listeners {
+		l.Close()
+	}
+	b.listeners = make(map[net.Listener]struct{})
+	conns := b.connections()
+	b.mu.Unlock()
+
+	for _, c := range conns {
+		c.terminate()
+	}
+
+	return nil
+}
+
+// CloseConnections closes all client connections with a CONNECTION_FORCED error, as a broker restart would.
+func (b *Broker) CloseConnections() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, c := range b.connections() {
+		c.closeWithError(newError(replyConnectionForced, "broker forced connection closure"))
+	}
+}
+
+// ConnectionCount returns the number of open client connections.
+func (b *Broker) ConnectionCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.conns)
+}
+
+// Block sends connection.blocked to all clients and holds back their publishes until Unblock,
+// as RabbitMQ does on a memory or disk alarm.
+func (b *Broker) Block(reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.blocked {
+		return
+	}
+	b.blocked = true
+	b.reason = reason
+	b.unblocked = make(chan struct{})
+
+	for _, c := range b.connections() {
+		c.sendBlocked()
+	}
+}
+
+// Unblock sends connection.unblocked to all clients and releases their publishes.
+func (b *Broker) Unblock() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.blocked {
+		return
+	}
+	b.blocked = false
+	b.reason = ""
+	close(b.unblocked)
+
+	for _, c := range b.connections() {
+		c.sendUnblocked()
+	}
+}
+
+func (b *Broker) blockedCh() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.unblocked
+}
+
+func (b *Broker) connections() []*serverConn {
+	conns := make([]*serverConn, 0, len(b.conns))
+	for c := range b.conns {
+		conns = append(conns, c)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	return conns
+}
+
+// vhost returns the vhost with the given name, creating it with the default exchanges if needed.
+func (b *Broker) vhost(name string) *vhost {
+	v, ok := b.vhosts[name]
+	if !ok {
+		v = newVhost(b, name)
+		b.vhosts[name] = v
+	}
+	return v
+}
+
+// Message represents a message held by the broker.
+type Message struct {
+	Exchange    string
+	RoutingKey  string
+	Redelivered bool
+	amqp091.Publishing
+}
+
+// QueueInfo represents the state of a queue.
+type QueueInfo struct {
+	Name      string
+	Messages  int
+	Unacked   int
+	Consumers int
+	Args      amqp091.Table
+}
+
+// Queue returns the state of the queue on the default vhost.
+func (b *Broker) Queue(name string) (QueueInfo, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.vhost(DefaultVhost).queues[name]
+	if !ok {
+		return QueueInfo{}, false
+	}
+	q.expire()
+
+	return QueueInfo{
+		Name:      q.name,
+		Messages:  len(q.ready),
+		Unacked:   q.unacked,
+		Consumers: len(q.consumers),
+		Args:      q.args,
+	}, true
+}
+
+// Messages returns the ready messages of the queue on the default vhost, in delivery order.
+func (b *Broker) Messages(queue string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.vhost(DefaultVhost).queues[queue]
+	if !ok {
+		return nil
+	}
+	q.expire()
+
+	messages := make([]Message, 0, len(q.ready))
+	for _, qm := range q.ready {
+		messages = append(messages, Message{
+			Exchange:    qm.msg.exchange,
+			RoutingKey:  qm.msg.routingKey,
+			Redelivered: qm.redelivered,
+			Publishing:  qm.msg.publishing,
+		})
+	}
+
+	return messages
+}
+
+// Publish publishes a message on the default vhost as a client would, without confirms.
+func (b *Broker) Publish(exchange, routingKey string, msg amqp091.Publishing) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v := b.vhost(DefaultVhost)
+	if exchange != "" {
+		if _, ok := v.exchanges[exchange]; !ok {
+			return ErrExchangeNotFound
+		}
+	}
+
+	v.publish(&message{exchange: exchange, routingKey: routingKey, publishing: msg})
+	return nil
+}
+
+// DeclareQueue declares a durable queue on the default vhost, bound to the exchange with the routing key
+// when the exchange is not empty. It is a shortcut to prepare a test.
+func (b *Broker) DeclareQueue(name, exchange, routingKey string, args amqp091.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v := b.vhost(DefaultVhost)
+	if _, ok := v.queues[name]; !ok {
+		v.queues[name] = newQueue(v, name, true, false, false, args, nil)
+	}
+	if exchange == "" {
+		return nil
+	}
+
+	e, ok := v.exchanges[exchange]
+	if !ok {
+		return ErrExchangeNotFound
+	}
+	e.bind(&binding{destination: name, key: routingKey, args: args})
+
+	return nil
+}
+
+// DeclareExchange declares a durable exchange on the default vhost. It is a shortcut to prepare a test.
+func (b *Broker) DeclareExchange(name, kind string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v := b.vhost(DefaultVhost)
+	if _, ok := v.exchanges[name]; !ok {
+		v.exchanges[name] = &exchange{name: name, kind: kind, durable: true}
+	}
+}
+
+type vhost struct {
+	broker    *Broker
+	name      string
+	exchanges map[string]*exchange
+	queues    map[string]*queue
+}
+
+func newVhost(b *Broker, name string) *vhost {
+	v := &vhost{
+		broker:    b,
+		name:      name,
+		exchanges: make(map[string]*exchange),
+		queues:    make(map[string]*queue),
+	}
+	for name, kind := range map[string]string{
+		"amq.direct":  amqp091.ExchangeDirect,
+		"amq.fanout":  amqp091.ExchangeFanout,
+		"amq.topic":   amqp091.ExchangeTopic,
+		"amq.headers": amqp091.ExchangeHeaders,
+		"amq.match":   amqp091.ExchangeHeaders,
+	} {
+		v.exchanges[name] = &exchange{name: name, kind: kind, durable: true}
+	}
+	return v
+}
+
+type exchange struct {
+	name       string
+	kind       string
+	durable    bool
+	autoDelete bool
+	internal   bool
+	args       amqp091.Table
+	bindings   []*binding
+	bound      bool
+}
+
+type binding struct {
+	destination string
+	toExchange  bool
+	key         string
+	args        amqp091.Table
+}
+
+func (b *binding) equal(other *binding) bool {
+	return b.destination == other.destination && b.toExchange == other.toExchange &&
+		b.key == other.key && tablesEqual(b.args, other.args)
+}
+
+func (e *exchange) bind(b *binding) {
+	for _, existing := range e.bindings {
+		if existing.equal(b) {
+			return
+		}
+	}
+	e.bindings = append(e.bindings, b)
+	e.bound = true
+}
+
+// unbind removes the bindings matching the filter and reports whether the exchange should be auto-deleted.
+func (e *exchange) unbind(match func(*binding) bool) bool {
+	kept := e.bindings[:0]
+	for _, b := range e.bindings {
+		if !match(b) {
+			kept = append(kept, b)
+		}
+	}
+	e.bindings = kept
+	return e.autoDelete && e.bound && len(e.bindings) == 0
+}
+
+func (e *exchange) matches(b *binding, routingKey string, headers amqp091.Table) bool {
+	switch e.kind {
+	case amqp091.ExchangeFanout:
+		return true
+	case amqp091.ExchangeTopic:
+		return topicMatch(strings.Split(b.key, "."), strings.Split(routingKey, "."))
+	case amqp091.ExchangeHeaders:
+		return headersMatch(b.args, headers)
+	default:
+		return b.key == routingKey
+	}
+}
+
+func topicMatch(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatch(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatch(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatch(pattern[1:], words[1:])
+	}
+}
+
+func headersMatch(args, headers amqp091.Table) bool {
+	mode, _ := args["x-match"].(string)
+	matchAny := mode == "any" || mode == "any-with-x"
+	withX := strings.HasSuffix(mode, "-with-x")
+
+	matched := 0
+	total := 0
+	for k, want := range args {
+		if k == "x-match" || (!withX && strings.HasPrefix(k, "x-")) {
+			continue
+		}
+		total++
+
+		got, ok := headers[k]
+		if ok && (want == nil || fieldsEqual(want, got)) {
+			matched++
+			if matchAny {
+				return true
+			}
+		}
+	}
+
+	if matchAny {
+		return false
+	}
+	return matched == total
+}
+
+func fieldsEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case amqp091.Table:
+		bv, ok := b.(amqp091.Table)
+		return ok && tablesEqual(av, bv)
+	case []byte:
+		bv, ok := b.([]byte)
+		return ok && string(av) == string(bv)
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !fieldsEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if ai, ok := toInt64(a); ok {
+		bi, ok := toInt64(b)
+		return ok && ai == bi
+	}
+	return a == b
+}
+
+func tablesEqual(a, b amqp091.Table) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		other, ok := b[k]
+		if !ok || !fieldsEqual(v, other) {
+			return false
+		}
+	}
+	return true
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+type message struct {
+	exchange   string
+	routingKey string
+	publishing amqp091.Publishing
+}
+
+// route returns the queues the message is routed to, and the direct reply-to channel, if any.
+func (v *vhost) route(exchangeName, routingKey string, headers amqp091.Table) ([]*queue, *serverChannel) {
+	if exchangeName == "" {
+		if strings.HasPrefix(routingKey, directReplyToPrefix) {
+			return nil, v.replyChannel(strings.TrimPrefix(routingKey, directReplyToPrefix))
+		}
+		if q, ok := v.queues[routingKey]; ok {
+			return []*queue{q}, nil
+		}
+		return nil, nil
+	}
+
+	seen := make(map[*queue]bool)
+	var queues []*queue
+	visited := make(map[string]bool)
+
+	var walk func(name string)
+	walk = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+
+		e, ok := v.exchanges[name]
+		if !ok {
+			return
+		}
+
+		routed := false
+		for _, b := range e.bindings {
+			if !e.matches(b, routingKey, headers) {
+				continue
+			}
+			if b.toExchange {
+				walk(b.destination)
+				routed = true
+				continue
+			}
+			if q, ok := v.queues[b.destination]; ok && !seen[q] {
+				seen[q] = true
+				queues = append(queues, q)
+				routed = true
+			}
+		}
+
+		if !routed {
+			if alternate, ok := e.args["alternate-exchange"].(string); ok {
+				walk(alternate)
+			}
+		}
+	}
+	walk(exchangeName)
+
+	return queues, nil
+}
+
+func (v *vhost) replyChannel(token string) *serverChannel {
+	connID, channelID, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil
+	}
+	for c := range v.broker.conns {
+		if strconv.FormatUint(c.id, 10) != connID {
+			continue
+		}
+		id, err := strconv.ParseUint(channelID, 10, 16)
+		if err != nil {
+			return nil
+		}
+		ch := c.channels[uint16(id)]
+		if ch == nil || ch.replyConsumer == "" {
+			return nil
+		}
+		return ch
+	}
+	return nil
+}
+
+// publish routes the message and enqueues it. It reports whether the message was routed,
+// and whether all queues accepted it.
+func (v *vhost) publish(msg *message) (routed, accepted bool) {
+	queues, replyCh := v.route(msg.exchange, msg.routingKey, msg.publishing.Headers)
+	if replyCh != nil {
+		replyCh.deliverReply(msg)
+		return true, true
+	}
+
+	accepted = true
+	for _, q := range queues {
+		if !q.enqueue(msg) {
+			accepted = false
+		}
+	}
+
+	return len(queues) > 0, accepted
+}
+
+func (v *vhost) deleteQueue(q *queue) int {
+	count := len(q.ready)
+	q.deleted = true
+	delete(v.queues, q.name)
+
+	for _, c := range q.consumers {
+		c.channel.cancelConsumer(c, true)
+	}
+	q.consumers = nil
+	q.ready = nil
+
+	for _, e := range v.exchanges {
+		if e.unbind(func(b *binding) bool { return !b.toExchange && b.destination == q.name }) {
+			delete(v.exchanges, e.name)
+		}
+	}
+
+	return count
+}
+
+func (v *vhost) deleteExchange(e *exchange) {
+	delete(v.exchanges, e.name)
+	for _, other := range v.exchanges {
+		if other.unbind(func(b *binding) bool { return b.toExchange && b.destination == e.name }) {
+			delete(v.exchanges, other.name)
+		}
+	}
+}
+
+func randomName(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
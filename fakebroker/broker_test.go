@@ -0,0 +1,408 @@
+package fakebroker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/dmasior/myamqp/fakebroker"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const testTimeout = 2 * time.Second
+
+// connect runs a MyAMQP connected to the broker until the end of the test.
+func connect(t *testing.T, broker *fakebroker.Broker, qos *myamqp.Qos) *myamqp.MyAMQP {
+	t.Helper()
+
+	connected := make(chan struct{}, 1)
+	config, err := myamqp.NewConfig(broker.Dial)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	config = config.WithOnConnect(func(*myamqp.MyAMQP) { connected <- struct{}{} })
+	if qos != nil {
+		config = config.WithQos(qos)
+	}
+
+	amqp, err := myamqp.New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		amqp.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	select {
+	case <-connected:
+	case <-time.After(testTimeout):
+		t.Fatal("not connected")
+	}
+
+	return amqp
+}
+
+// publish publishes the message with a publisher confirm, so it is routed once publish returns.
+func publish(t *testing.T, amqp *myamqp.MyAMQP, exchange *myamqp.ExchangeOptions, routingKey string, msg amqp091.Publishing) {
+	t.Helper()
+
+	producer, err := amqp.Producer(myamqp.NewProducerOptions(exchange).WithConfirm(true))
+	if err != nil {
+		t.Fatalf("Producer: %v", err)
+	}
+	defer producer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	confirm, err := producer.PublishWithDeferredConfirm(ctx, routingKey, false, false, msg)
+	if err != nil {
+		t.Fatalf("PublishWithDeferredConfirm: %v", err)
+	}
+	if acked, err := confirm.WaitContext(ctx); err != nil || !acked {
+		t.Fatalf("confirm: acked %v, error %v", acked, err)
+	}
+}
+
+// consume consumes the queue and returns the deliveries, with the prefetch of the Qos of the MyAMQP.
+func consume(t *testing.T, amqp *myamqp.MyAMQP, queue *myamqp.QueueOptions) <-chan amqp091.Delivery {
+	t.Helper()
+
+	received := make(chan amqp091.Delivery, 100)
+	consumer, err := amqp.Consumer(
+		myamqp.NewQueueConsumerOptions("test-consumer", queue),
+		func(deliveries <-chan amqp091.Delivery, done chan error) {
+			for d := range deliveries {
+				received <- d
+			}
+			done <- nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("Consumer: %v", err)
+	}
+	t.Cleanup(func() { consumer.Cancel() })
+
+	return received
+}
+
+func receive(t *testing.T, deliveries <-chan amqp091.Delivery) amqp091.Delivery {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(testTimeout):
+		t.Fatal("no delivery")
+		return amqp091.Delivery{}
+	}
+}
+
+func expectNoDelivery(t *testing.T, deliveries <-chan amqp091.Delivery) {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		t.Fatalf("unexpected delivery %d: %s", d.DeliveryTag, d.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// waitQueue waits for the queue to reach the number of ready and unacked messages.
+func waitQueue(t *testing.T, broker *fakebroker.Broker, name string, ready, unacked int) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		info, ok := broker.Queue(name)
+		if ok && info.Messages == ready && info.Unacked == unacked {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queue %s: got %+v, want %d ready and %d unacked", name, info, ready, unacked)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	type binding struct {
+		queue      string
+		routingKey string
+		args       amqp091.Table
+	}
+
+	tests := []struct {
+		name       string
+		kind       string
+		bindings   []binding
+		routingKey string
+		headers    amqp091.Table
+		want       map[string]int
+	}{
+		{
+			name:       "direct matches the routing key",
+			kind:       myamqp.ExchangeTypeDirect,
+			bindings:   []binding{{queue: "created", routingKey: "order.created"}, {queue: "deleted", routingKey: "order.deleted"}},
+			routingKey: "order.created",
+			want:       map[string]int{"created": 1, "deleted": 0},
+		},
+		{
+			name:       "direct without match",
+			kind:       myamqp.ExchangeTypeDirect,
+			bindings:   []binding{{queue: "created", routingKey: "order.created"}},
+			routingKey: "order.updated",
+			want:       map[string]int{"created": 0},
+		},
+		{
+			name:       "fanout ignores the routing key",
+			kind:       myamqp.ExchangeTypeFanout,
+			bindings:   []binding{{queue: "a", routingKey: "x"}, {queue: "b", routingKey: "y"}},
+			routingKey: "z",
+			want:       map[string]int{"a": 1, "b": 1},
+		},
+		{
+			name: "topic wildcards",
+			kind: myamqp.ExchangeTypeTopic,
+			bindings: []binding{
+				{queue: "star", routingKey: "order.*"},
+				{queue: "hash", routingKey: "order.#"},
+				{queue: "exact", routingKey: "order.created"},
+				{queue: "other", routingKey: "invoice.#"},
+			},
+			routingKey: "order.created",
+			want:       map[string]int{"star": 1, "hash": 1, "exact": 1, "other": 0},
+		},
+		{
+			name: "topic hash matches several words",
+			kind: myamqp.ExchangeTypeTopic,
+			bindings: []binding{
+				{queue: "star", routingKey: "order.*"},
+				{queue: "hash", routingKey: "order.#"},
+				{queue: "all", routingKey: "#"},
+			},
+			routingKey: "order.created.eu",
+			want:       map[string]int{"star": 0, "hash": 1, "all": 1},
+		},
+		{
+			name: "headers all and any",
+			kind: myamqp.ExchangeTypeHeaders,
+			bindings: []binding{
+				{queue: "all", args: amqp091.Table{"x-match": "all", "region": "eu", "kind": "order"}},
+				{queue: "any", args: amqp091.Table{"x-match": "any", "region": "us", "kind": "order"}},
+				{queue: "none", args: amqp091.Table{"x-match": "all", "region": "us", "kind": "order"}},
+			},
+			headers: amqp091.Table{"region": "eu", "kind": "order"},
+			want:    map[string]int{"all": 1, "any": 1, "none": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := fakebroker.New()
+			defer broker.Close()
+			amqp := connect(t, broker, nil)
+
+			exchange := myamqp.NewExchangeOptions("test-exchange", tt.kind)
+			for _, b := range tt.bindings {
+				queue := myamqp.NewQueueOptions(b.queue).WithRoutingKey(b.routingKey).WithArgs(b.args)
+				if err := amqp.Declare(exchange, queue); err != nil {
+					t.Fatalf("Declare %s: %v", b.queue, err)
+				}
+			}
+
+			publish(t, amqp, exchange, tt.routingKey, amqp091.Publishing{Headers: tt.headers, Body: []byte("message")})
+
+			for queue, want := range tt.want {
+				if got := len(broker.Messages(queue)); got != want {
+					t.Errorf("queue %s: got %d messages, want %d", queue, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	broker := fakebroker.New()
+	defer broker.Close()
+	amqp := connect(t, broker, myamqp.NewQos(3, 0, false))
+
+	queue := myamqp.NewQueueOptions("prefetch")
+	if err := amqp.Declare(nil, queue); err != nil {
+		t.Fatalf("Declare: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		publish(t, amqp, myamqp.NewExchangeOptions("", myamqp.ExchangeTypeDirect).WithDeclareMode(myamqp.DeclareModeSkip), "prefetch", amqp091.Publishing{Body: []byte{byte(i)}})
+	}
+
+	deliveries := consume(t, amqp, queue)
+	var received []amqp091.Delivery
+	for i := 0; i < 3; i++ {
+		received = append(received, receive(t, deliveries))
+	}
+	expectNoDelivery(t, deliveries)
+	waitQueue(t, broker, "prefetch", 2, 3)
+
+	// A multiple ack frees the whole prefetch window.
+	if err := received[2].Ack(true); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	receive(t, deliveries)
+	receive(t, deliveries)
+	waitQueue(t, broker, "prefetch", 0, 2)
+}
+
+func TestSettle(t *testing.T) {
+	tests := []struct {
+		name            string
+		settle          func(d amqp091.Delivery) error
+		wantRedelivered bool
+		wantReady       int
+	}{
+		{
+			name:      "ack removes the message",
+			settle:    func(d amqp091.Delivery) error { return d.Ack(false) },
+			wantReady: 0,
+		},
+		{
+			name:            "nack with requeue redelivers",
+			settle:          func(d amqp091.Delivery) error { return d.Nack(false, true) },
+			wantRedelivered: true,
+			wantReady:       1,
+		},
+		{
+			name:            "reject with requeue redelivers",
+			settle:          func(d amqp091.Delivery) error { return d.Reject(true) },
+			wantRedelivered: true,
+			wantReady:       1,
+		},
+		{
+			name:      "nack without requeue drops",
+			settle:    func(d amqp091.Delivery) error { return d.Nack(false, false) },
+			wantReady: 0,
+		},
+		{
+			name:      "reject without requeue drops",
+			settle:    func(d amqp091.Delivery) error { return d.Reject(false) },
+			wantReady: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := fakebroker.New()
+			defer broker.Close()
+			amqp := connect(t, broker, myamqp.NewQos(1, 0, false))
+
+			queue := myamqp.NewQueueOptions("settle")
+			if err := broker.DeclareQueue("settle", "", "", nil); err != nil {
+				t.Fatalf("DeclareQueue: %v", err)
+			}
+			if err := broker.Publish("", "settle", amqp091.Publishing{Body: []byte("message")}); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+
+			deliveries := consume(t, amqp, queue.WithDeclareMode(myamqp.DeclareModeSkip))
+			d := receive(t, deliveries)
+			if d.Redelivered {
+				t.Fatal("first delivery is redelivered")
+			}
+			if err := tt.settle(d); err != nil {
+				t.Fatalf("settle: %v", err)
+			}
+
+			if !tt.wantRedelivered {
+				expectNoDelivery(t, deliveries)
+				waitQueue(t, broker, "settle", tt.wantReady, 0)
+				return
+			}
+
+			redelivered := receive(t, deliveries)
+			if !redelivered.Redelivered || string(redelivered.Body) != "message" {
+				t.Fatalf("got redelivered %v with body %q", redelivered.Redelivered, redelivered.Body)
+			}
+		})
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       amqp091.Table
+		settle     func(d amqp091.Delivery) error
+		wantReason string
+		wantKey    string
+	}{
+		{
+			name:       "nack without requeue",
+			args:       amqp091.Table{"x-dead-letter-exchange": "dlx"},
+			settle:     func(d amqp091.Delivery) error { return d.Nack(false, false) },
+			wantReason: "rejected",
+			wantKey:    "work",
+		},
+		{
+			name:       "reject with a dead letter routing key",
+			args:       amqp091.Table{"x-dead-letter-exchange": "dlx", "x-dead-letter-routing-key": "failed"},
+			settle:     func(d amqp091.Delivery) error { return d.Reject(false) },
+			wantReason: "rejected",
+			wantKey:    "failed",
+		},
+		{
+			name:       "expired message",
+			args:       amqp091.Table{"x-dead-letter-exchange": "dlx", "x-message-ttl": int32(10)},
+			wantReason: "expired",
+			wantKey:    "work",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := fakebroker.New()
+			defer broker.Close()
+			amqp := connect(t, broker, nil)
+
+			dlx := myamqp.NewExchangeOptions("dlx", myamqp.ExchangeTypeFanout)
+			if err := amqp.Declare(dlx, myamqp.NewQueueOptions("dead")); err != nil {
+				t.Fatalf("Declare dead: %v", err)
+			}
+			work := myamqp.NewQueueOptions("work").WithArgs(tt.args)
+			if err := amqp.Declare(nil, work); err != nil {
+				t.Fatalf("Declare work: %v", err)
+			}
+
+			publish(t, amqp, myamqp.NewExchangeOptions("", myamqp.ExchangeTypeDirect).WithDeclareMode(myamqp.DeclareModeSkip), "work", amqp091.Publishing{Body: []byte("message")})
+
+			if tt.settle != nil {
+				d := receive(t, consume(t, amqp, work.WithDeclareMode(myamqp.DeclareModeSkip)))
+				if err := tt.settle(d); err != nil {
+					t.Fatalf("settle: %v", err)
+				}
+			}
+
+			waitQueue(t, broker, "dead", 1, 0)
+			dead := broker.Messages("dead")[0]
+			if dead.RoutingKey != tt.wantKey {
+				t.Errorf("routing key: got %q, want %q", dead.RoutingKey, tt.wantKey)
+			}
+			if reason := dead.Headers["x-first-death-reason"]; reason != tt.wantReason {
+				t.Errorf("x-first-death-reason: got %v, want %q", reason, tt.wantReason)
+			}
+			deaths, ok := dead.Headers["x-death"].([]interface{})
+			if !ok || len(deaths) != 1 {
+				t.Fatalf("x-death: got %#v", dead.Headers["x-death"])
+			}
+			if queue := deaths[0].(amqp091.Table)["queue"]; queue != "work" {
+				t.Errorf("x-death queue: got %v, want work", queue)
+			}
+		})
+	}
+}
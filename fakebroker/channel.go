@@ -0,0 +1,760 @@
+package fakebroker
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// serverChannel is the server side of a channel.
+type serverChannel struct {
+	conn *serverConn
+	id   uint16
+
+	consumers      map[string]*consumer
+	unacked        map[uint64]*delivery
+	deliveryTag    uint64
+	prefetch       uint16
+	globalPrefetch uint16
+	flowActive     bool
+	closing        bool
+
+	confirm    bool
+	publishSeq uint64
+	publishing *pendingPublish
+
+	replyConsumer string
+	lastQueue     string
+}
+
+type delivery struct {
+	tag      uint64
+	queue    *queue
+	consumer *consumer
+	qm       *queuedMessage
+}
+
+// pendingPublish is a basic.publish waiting for its content frames.
+type pendingPublish struct {
+	exchange   string
+	routingKey string
+	mandatory  bool
+	header     bool
+	size       uint64
+	publishing amqp091.Publishing
+}
+
+func (ch *serverChannel) vhost() *vhost {
+	return ch.conn.vhost
+}
+
+func (ch *serverChannel) handleFrame(f frame) error {
+	if ch.closing {
+		// After the server closes the channel, only close and close-ok are handled.
+		if f.typ == frameMethod {
+			d := newDecoder(f.payload)
+			if d.short() == classChannel {
+				switch d.short() {
+				case methodChannelClose:
+					ch.conn.sendMethod(ch.id, newMethod(classChannel, methodChannelCloseOk))
+					delete(ch.conn.channels, ch.id)
+				case methodChannelCloseOk:
+					delete(ch.conn.channels, ch.id)
+				}
+			}
+		}
+		return nil
+	}
+
+	switch f.typ {
+	case frameHeader:
+		return ch.handleHeader(f.payload)
+	case frameBody:
+		return ch.handleBody(f.payload)
+	}
+
+	if ch.publishing != nil {
+		return newError(replyUnexpectedFrame, "expected content frame")
+	}
+
+	d := newDecoder(f.payload)
+	classID, methodID := d.short(), d.short()
+	if d.err != nil {
+		return newError(replyFrameError, "malformed method frame")
+	}
+
+	err := ch.handleMethod(classID, methodID, d)
+	if amqpErr, ok := err.(*amqpError); ok {
+		amqpErr.classID, amqpErr.methodID = classID, methodID
+	}
+	return err
+}
+
+func (ch *serverChannel) handleMethod(classID, methodID uint16, d *decoder) error {
+	switch classID {
+	case classChannel:
+		return ch.handleChannel(methodID, d)
+	case classExchange:
+		return ch.handleExchange(methodID, d)
+	case classQueue:
+		return ch.handleQueue(methodID, d)
+	case classBasic:
+		return ch.handleBasic(methodID, d)
+	case classConfirm:
+		if methodID != methodConfirmSelect {
+			break
+		}
+		ch.confirm = true
+		if !d.bit() {
+			ch.conn.sendMethod(ch.id, newMethod(classConfirm, methodConfirmSelectOk))
+		}
+		return nil
+	case classTx:
+		return newError(replyNotImplemented, "transactions are not supported")
+	}
+
+	return newError(replyCommandInvalid, "unknown method "+strconv.Itoa(int(classID))+"."+strconv.Itoa(int(methodID)))
+}
+
+func (ch *serverChannel) handleChannel(methodID uint16, d *decoder) error {
+	switch methodID {
+	case methodChannelOpen:
+		return newError(replyChannelError, "channel already open")
+	case methodChannelFlow:
+		ch.flowActive = d.bit()
+		ch.conn.sendMethod(ch.id, newMethod(classChannel, methodChannelFlowOk).bit(ch.flowActive))
+		if ch.flowActive {
+			ch.dispatch()
+		}
+	case methodChannelFlowOk:
+	case methodChannelClose:
+		ch.release()
+		delete(ch.conn.channels, ch.id)
+		ch.conn.sendMethod(ch.id, newMethod(classChannel, methodChannelCloseOk))
+	default:
+		return newError(replyCommandInvalid, "unexpected channel method")
+	}
+	return nil
+}
+
+func (ch *serverChannel) handleExchange(methodID uint16, d *decoder) error {
+	v := ch.vhost()
+	d.short() // reserved
+
+	switch methodID {
+	case methodExchangeDeclare:
+		name, kind := d.shortstr(), d.shortstr()
+		passive, durable, autoDelete, internal, noWait := d.bit(), d.bit(), d.bit(), d.bit(), d.bit()
+		args := d.table()
+
+		e, ok := v.exchanges[name]
+		switch {
+		case passive && !ok:
+			return newError(replyNotFound, "no exchange '"+name+"' in vhost '"+v.name+"'")
+		case passive:
+		case name == "":
+			return newError(replyAccessRefused, "operation not permitted on the default exchange")
+		case ok:
+			if e.kind != kind || e.durable != durable || e.autoDelete != autoDelete || e.internal != internal {
+				return newError(replyPreconditionFailed, "inequivalent arg for exchange '"+name+"' in vhost '"+v.name+"'")
+			}
+		case strings.HasPrefix(name, "amq."):
+			return newError(replyAccessRefused, "exchange name '"+name+"' contains reserved prefix 'amq.*'")
+		default:
+			switch kind {
+			case amqp091.ExchangeDirect, amqp091.ExchangeFanout, amqp091.ExchangeTopic, amqp091.ExchangeHeaders:
+			default:
+				return newError(replyCommandInvalid, "invalid exchange type '"+kind+"'")
+			}
+			v.exchanges[name] = &exchange{name: name, kind: kind, durable: durable, autoDelete: autoDelete, internal: internal, args: args}
+		}
+
+		if !noWait {
+			ch.conn.sendMethod(ch.id, newMethod(classExchange, methodExchangeDeclareOk))
+		}
+
+	case methodExchangeDelete:
+		name := d.shortstr()
+		ifUnused, noWait := d.bit(), d.bit()
+
+		if e, ok := v.exchanges[name]; ok {
+			if strings.HasPrefix(name, "amq.") {
+				return newError(replyAccessRefused, "exchange name '"+name+"' contains reserved prefix 'amq.*'")
+			}
+			if ifUnused && len(e.bindings) > 0 {
+				return newError(replyPreconditionFailed, "exchange '"+name+"' in use")
+			}
+			v.deleteExchange(e)
+		}
+
+		if !noWait {
+			ch.conn.sendMethod(ch.id, newMethod(classExchange, methodExchangeDeleteOk))
+		}
+
+	case methodExchangeBind, methodExchangeUnbind:
+		destination, source, key := d.shortstr(), d.shortstr(), d.shortstr()
+		noWait := d.bit()
+		args := d.table()
+
+		src, ok := v.exchanges[source]
+		if !ok {
+			return newError(replyNotFound, "no exchange '"+source+"' in vhost '"+v.name+"'")
+		}
+		if _, ok := v.exchanges[destination]; !ok {
+			return newError(replyNotFound, "no exchange '"+destination+"' in vhost '"+v.name+"'")
+		}
+
+		b := &binding{destination: destination, toExchange: true, key: key, args: args}
+		reply := uint16(methodExchangeBindOk)
+		if methodID == methodExchangeBind {
+			src.bind(b)
+		} else {
+			reply = methodExchangeUnbindOk
+			if src.unbind(b.equal) {
+				v.deleteExchange(src)
+			}
+		}
+
+		if !noWait {
+			ch.conn.sendMethod(ch.id, newMethod(classExchange, reply))
+		}
+
+	default:
+		return newError(replyCommandInvalid, "unexpected exchange method")
+	}
+
+	return nil
+}
+
+func (ch *serverChannel) handleQueue(methodID uint16, d *decoder) error {
+	v := ch.vhost()
+	d.short() // reserved
+
+	switch methodID {
+	case methodQueueDeclare:
+		name := d.shortstr()
+		passive, durable, exclusive, autoDelete, noWait := d.bit(), d.bit(), d.bit(), d.bit(), d.bit()
+		args := d.table()
+
+		if name == "" {
+			name = randomName("amq.gen-")
+		}
+
+		q, ok := v.queues[name]
+		switch {
+		case passive && !ok:
+			return newError(replyNotFound, "no queue '"+name+"' in vhost '"+v.name+"'")
+		case ok && q.owner != nil && q.owner != ch.conn:
+			return newError(replyResourceLocked, "cannot obtain exclusive access to locked queue '"+name+"' in vhost '"+v.name+"'")
+		case passive:
+		case ok:
+			if q.durable != durable || q.autoDelete != autoDelete || q.exclusive != exclusive || !tablesEqual(xArgs(q.args), xArgs(args)) {
+				return newError(replyPreconditionFailed, "inequivalent arg for queue '"+name+"' in vhost '"+v.name+"'")
+			}
+		case strings.HasPrefix(name, "amq.") && !strings.HasPrefix(name, "amq.gen-"):
+			return newError(replyAccessRefused, "queue name '"+name+"' contains reserved prefix 'amq.*'")
+		default:
+			var owner *serverConn
+			if exclusive {
+				owner = ch.conn
+			}
+			q = newQueue(v, name, durable, exclusive, autoDelete, args, owner)
+			v.queues[name] = q
+		}
+
+		q.touch()
+		q.expire()
+		ch.lastQueue = name
+
+		if !noWait {
+			ch.conn.sendMethod(ch.id, newMethod(classQueue, methodQueueDeclareOk).
+				shortstr(name).
+				long(uint32(len(q.ready))).
+				long(uint32(len(q.consumers))))
+		}
+
+	case methodQueueBind, methodQueueUnbind:
+		name, exchangeName, key := d.shortstr(), d.shortstr(), d.shortstr()
+		noWait := false
+		if methodID == methodQueueBind {
+			noWait = d.bit()
+		}
+		args := d.table()
+
+		q, err := ch.queue(name)
+		if err != nil {
+			return err
+		}
+		if key == "" && name == "" {
+			key = q.name
+		}
+		if exchangeName == "" {
+			return newError(replyAccessRefused, "operation not permitted on the default exchange")
+		}
+		e, ok := v.exchanges[exchangeName]
+		if !ok {
+			return newError(replyNotFound, "no exchange '"+exchangeName+"' in vhost '"+v.name+"'")
+		}
+
+		b := &binding{destination: q.name, key: key, args: args}
+		if methodID == methodQueueBind {
+			e.bind(b)
+			if !noWait {
+				ch.conn.sendMethod(ch.id, newMethod(classQueue, methodQueueBindOk))
+			}
+		} else {
+			if e.unbind(b.equal) {
+				v.deleteExchange(e)
+			}
+			ch.conn.sendMethod(ch.id, newMethod(classQueue, methodQueueUnbindOk))
+		}
+
+	case methodQueuePurge:
+		name := d.shortstr()
+		noWait := d.bit()
+
+		q, err := ch.queue(name)
+		if err != nil {
+			return err
+		}
+		count := len(q.ready)
+		q.ready = nil
+
+		if !noWait {
+			ch.conn.sendMethod(ch.id, newMethod(classQueue, methodQueuePurgeOk).long(uint32(count)))
+		}
+
+	case methodQueueDelete:
+		name := d.shortstr()
+		ifUnused, ifEmpty, noWait := d.bit(), d.bit(), d.bit()
+
+		count := 0
+		if q, ok := v.queues[name]; ok {
+			switch {
+			case q.owner != nil && q.owner != ch.conn:
+				return newError(replyResourceLocked, "cannot obtain exclusive access to locked queue '"+name+"' in vhost '"+v.name+"'")
+			case ifUnused && len(q.consumers) > 0:
+				return newError(replyPreconditionFailed, "queue '"+name+"' in vhost '"+v.name+"' in use")
+			case ifEmpty && len(q.ready) > 0:
+				return newError(replyPreconditionFailed, "queue '"+name+"' in vhost '"+v.name+"' is not empty")
+			}
+			count = v.deleteQueue(q)
+		}
+
+		if !noWait {
+			ch.conn.sendMethod(ch.id, newMethod(classQueue, methodQueueDeleteOk).long(uint32(count)))
+		}
+
+	default:
+		return newError(replyCommandInvalid, "unexpected queue method")
+	}
+
+	return nil
+}
+
+// queue returns the queue with the given name, or the last queue declared on the channel when the name is empty.
+func (ch *serverChannel) queue(name string) (*queue, error) {
+	if name == "" {
+		name = ch.lastQueue
+	}
+	if name == "" {
+		return nil, newError(replyNotFound, "no previously declared queue")
+	}
+
+	q, ok := ch.vhost().queues[name]
+	if !ok {
+		return nil, newError(replyNotFound, "no queue '"+name+"' in vhost '"+ch.vhost().name+"'")
+	}
+	if q.owner != nil && q.owner != ch.conn {
+		return nil, newError(replyResourceLocked, "cannot obtain exclusive access to locked queue '"+name+"' in vhost '"+ch.vhost().name+"'")
+	}
+	return q, nil
+}
+
+// xArgs returns the x- arguments, the only ones RabbitMQ compares on redeclaration.
+func xArgs(args amqp091.Table) amqp091.Table {
+	filtered := amqp091.Table{}
+	for k, v := range args {
+		if strings.HasPrefix(k, "x-") {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+func (ch *serverChannel) handleBasic(methodID uint16, d *decoder) error {
+	switch methodID {
+	case methodBasicQos:
+		d.long() // prefetch-size
+		count, global := d.short(), d.bit()
+		if global {
+			ch.globalPrefetch = count
+		} else {
+			ch.prefetch = count
+		}
+		ch.conn.sendMethod(ch.id, newMethod(classBasic, methodBasicQosOk))
+		ch.dispatch()
+
+	case methodBasicConsume:
+		return ch.consume(d)
+
+	case methodBasicCancel:
+		tag, noWait := d.shortstr(), d.bit()
+		if c, ok := ch.consumers[tag]; ok {
+			ch.cancelConsumer(c, false)
+		}
+		if tag == ch.replyConsumer {
+			ch.replyConsumer = ""
+		}
+		if !noWait {
+			ch.conn.sendMethod(ch.id, newMethod(classBasic, methodBasicCancelOk).shortstr(tag))
+		}
+
+	case methodBasicPublish:
+		d.short() // reserved
+		ch.publishing = &pendingPublish{exchange: d.shortstr(), routingKey: d.shortstr(), mandatory: d.bit()}
+		if d.bit() {
+			ch.publishing = nil
+			return newError(replyNotImplemented, "immediate=true")
+		}
+
+		if e, ok := ch.vhost().exchanges[ch.publishing.exchange]; ch.publishing.exchange != "" && !ok {
+			name := ch.publishing.exchange
+			ch.publishing = nil
+			return newError(replyNotFound, "no exchange '"+name+"' in vhost '"+ch.vhost().name+"'")
+		} else if ok && e.internal {
+			ch.publishing = nil
+			return newError(replyAccessRefused, "cannot publish to internal exchange")
+		}
+
+	case methodBasicGet:
+		return ch.get(d)
+
+	case methodBasicAck:
+		tag, multiple := d.longlong(), d.bit()
+		return ch.settle(tag, multiple, func(dl *delivery) {})
+
+	case methodBasicReject:
+		tag, requeue := d.longlong(), d.bit()
+		return ch.settle(tag, false, ch.reject(requeue))
+
+	case methodBasicNack:
+		tag, multiple, requeue := d.longlong(), d.bit(), d.bit()
+		return ch.settle(tag, multiple, ch.reject(requeue))
+
+	case methodBasicRecover, methodBasicRecoverAsync:
+		d.bit() // requeue, RabbitMQ always requeues
+		ch.requeueAll()
+		if methodID == methodBasicRecover {
+			ch.conn.sendMethod(ch.id, newMethod(classBasic, methodBasicRecoverOk))
+		}
+
+	default:
+		return newError(replyCommandInvalid, "unexpected basic method")
+	}
+
+	return nil
+}
+
+func (ch *serverChannel) consume(d *decoder) error {
+	d.short() // reserved
+	name, tag := d.shortstr(), d.shortstr()
+	d.bit() // no-local
+	noAck, exclusive, noWait := d.bit(), d.bit(), d.bit()
+	d.table()
+
+	if tag == "" {
+		tag = randomName("amq.ctag-")
+	}
+	if _, ok := ch.consumers[tag]; ok || tag == ch.replyConsumer {
+		return newError(replyNotAllowed, "attempt to reuse consumer tag '"+tag+"'")
+	}
+
+	if name == directReplyTo {
+		if !noAck {
+			return newError(replyPreconditionFailed, "reply consumer cannot acknowledge")
+		}
+		ch.replyConsumer = tag
+		if !noWait {
+			ch.conn.sendMethod(ch.id, newMethod(classBasic, methodBasicConsumeOk).shortstr(tag))
+		}
+		return nil
+	}
+
+	q, err := ch.queue(name)
+	if err != nil {
+		return err
+	}
+	for _, existing := range q.consumers {
+		if existing.exclusive || exclusive {
+			return newError(replyAccessRefused, "queue '"+q.name+"' in vhost '"+ch.vhost().name+"' in exclusive use")
+		}
+	}
+
+	c := &consumer{tag: tag, channel: ch, queue: q, noAck: noAck, exclusive: exclusive, prefetch: ch.prefetch}
+	ch.consumers[tag] = c
+
+	if !noWait {
+		ch.conn.sendMethod(ch.id, newMethod(classBasic, methodBasicConsumeOk).shortstr(tag))
+	}
+
+	q.addConsumer(c)
+	q.dispatch()
+
+	return nil
+}
+
+// cancelConsumer removes the consumer. When notify is set, the client is told with a basic.cancel,
+// as RabbitMQ does when the queue of the consumer is deleted.
+func (ch *serverChannel) cancelConsumer(c *consumer, notify bool) {
+	delete(ch.consumers, c.tag)
+	if notify {
+		ch.conn.sendMethod(ch.id, newMethod(classBasic, methodBasicCancel).shortstr(c.tag).bit(true))
+		return
+	}
+	c.queue.removeConsumer(c)
+}
+
+func (ch *serverChannel) get(d *decoder) error {
+	d.short() // reserved
+	name, noAck := d.shortstr(), d.bit()
+
+	q, err := ch.queue(name)
+	if err != nil {
+		return err
+	}
+	q.touch()
+	q.expire()
+
+	if len(q.ready) == 0 {
+		ch.conn.sendMethod(ch.id, newMethod(classBasic, methodBasicGetEmpty).shortstr(""))
+		return nil
+	}
+
+	qm := q.ready[0]
+	q.ready = q.ready[1:]
+
+	ch.deliveryTag++
+	if !noAck {
+		ch.unacked[ch.deliveryTag] = &delivery{tag: ch.deliveryTag, queue: q, qm: qm}
+		q.unacked++
+	}
+
+	ch.conn.sendContent(ch.id, newMethod(classBasic, methodBasicGetOk).
+		longlong(ch.deliveryTag).
+		bit(qm.redelivered).
+		shortstr(qm.msg.exchange).
+		shortstr(qm.msg.routingKey).
+		long(uint32(len(q.ready))), qm.msg.publishing)
+
+	return nil
+}
+
+// deliver sends the message to the consumer and tracks it until it is settled.
+func (ch *serverChannel) deliver(c *consumer, qm *queuedMessage) {
+	ch.deliveryTag++
+	if !c.noAck {
+		ch.unacked[ch.deliveryTag] = &delivery{tag: ch.deliveryTag, queue: c.queue, consumer: c, qm: qm}
+		c.unacked++
+		c.queue.unacked++
+	}
+
+	ch.conn.sendContent(ch.id, newMethod(classBasic, methodBasicDeliver).
+		shortstr(c.tag).
+		longlong(ch.deliveryTag).
+		bit(qm.redelivered).
+		shortstr(qm.msg.exchange).
+		shortstr(qm.msg.routingKey), qm.msg.publishing)
+}
+
+// deliverReply sends a direct reply-to message to the reply consumer of the channel.
+func (ch *serverChannel) deliverReply(msg *message) {
+	ch.deliveryTag++
+	ch.conn.sendContent(ch.id, newMethod(classBasic, methodBasicDeliver).
+		shortstr(ch.replyConsumer).
+		longlong(ch.deliveryTag).
+		bit(false).
+		shortstr(msg.exchange).
+		shortstr(msg.routingKey), msg.publishing)
+}
+
+// settle applies fn to the delivery with the given tag, or all deliveries up to it when multiple is set.
+func (ch *serverChannel) settle(tag uint64, multiple bool, fn func(*delivery)) error {
+	var settled []*delivery
+	if multiple {
+		for t, dl := range ch.unacked {
+			if t <= tag || tag == 0 {
+				settled = append(settled, dl)
+			}
+		}
+		sort.Slice(settled, func(i, j int) bool { return settled[i].tag < settled[j].tag })
+	} else if dl, ok := ch.unacked[tag]; ok {
+		settled = append(settled, dl)
+	}
+
+	if len(settled) == 0 && (tag != 0 || !multiple) {
+		return newError(replyPreconditionFailed, "unknown delivery tag "+strconv.FormatUint(tag, 10))
+	}
+
+	// Requeued messages go back to the front in reverse, which keeps their original order.
+	queues := make(map[*queue]struct{})
+	for i := len(settled) - 1; i >= 0; i-- {
+		dl := settled[i]
+		delete(ch.unacked, dl.tag)
+		dl.queue.unacked--
+		if dl.consumer != nil {
+			dl.consumer.unacked--
+		}
+		fn(dl)
+		queues[dl.queue] = struct{}{}
+	}
+
+	ch.dispatch()
+	for q := range queues {
+		if !q.deleted {
+			q.dispatch()
+		}
+	}
+
+	return nil
+}
+
+func (ch *serverChannel) reject(requeue bool) func(*delivery) {
+	return func(dl *delivery) {
+		if requeue {
+			dl.queue.requeue(dl.qm)
+		} else {
+			dl.queue.deadLetter(dl.qm, "rejected")
+		}
+	}
+}
+
+// requeueAll requeues all unacknowledged deliveries of the channel.
+func (ch *serverChannel) requeueAll() {
+	if len(ch.unacked) > 0 {
+		ch.settle(0, true, ch.reject(true))
+	}
+}
+
+// dispatch gives the queues consumed by the channel a chance to deliver, e.g. after its prefetch freed up.
+func (ch *serverChannel) dispatch() {
+	queues := make(map[*queue]struct{})
+	for _, c := range ch.consumers {
+		queues[c.queue] = struct{}{}
+	}
+	for q := range queues {
+		if !q.deleted {
+			q.dispatch()
+		}
+	}
+}
+
+func (ch *serverChannel) handleHeader(payload []byte) error {
+	p := ch.publishing
+	if p == nil || p.header {
+		return newError(replyUnexpectedFrame, "unexpected content header frame")
+	}
+
+	publishing, size, err := decodeContentHeader(payload)
+	if err != nil {
+		return newError(replyFrameError, err.Error())
+	}
+	p.header = true
+	p.size = size
+	p.publishing = publishing
+
+	if size == 0 {
+		ch.completePublish()
+	}
+	return nil
+}
+
+func (ch *serverChannel) handleBody(payload []byte) error {
+	p := ch.publishing
+	if p == nil || !p.header {
+		return newError(replyUnexpectedFrame, "unexpected content body frame")
+	}
+
+	p.publishing.Body = append(p.publishing.Body, payload...)
+	if uint64(len(p.publishing.Body)) > p.size {
+		return newError(replyFrameError, "content body exceeds declared size")
+	}
+	if uint64(len(p.publishing.Body)) == p.size {
+		ch.completePublish()
+	}
+	return nil
+}
+
+// completePublish routes a fully received message, then sends the return and the confirm if needed.
+func (ch *serverChannel) completePublish() {
+	p := ch.publishing
+	ch.publishing = nil
+
+	if p.publishing.ReplyTo == directReplyTo {
+		if ch.replyConsumer == "" {
+			ch.closeWithError(newError(replyPreconditionFailed, "fast reply consumer does not exist"))
+			return
+		}
+		p.publishing.ReplyTo = directReplyToPrefix + strconv.FormatUint(ch.conn.id, 10) + "." + strconv.Itoa(int(ch.id))
+	}
+
+	msg := &message{exchange: p.exchange, routingKey: p.routingKey, publishing: p.publishing}
+	routed, accepted := ch.vhost().publish(msg)
+
+	if !routed && p.mandatory {
+		ch.conn.sendContent(ch.id, newMethod(classBasic, methodBasicReturn).
+			short(replyNoRoute).
+			shortstr(replyTexts[replyNoRoute]).
+			shortstr(p.exchange).
+			shortstr(p.routingKey), p.publishing)
+	}
+
+	if ch.confirm {
+		ch.publishSeq++
+		method := uint16(methodBasicAck)
+		if !accepted {
+			method = methodBasicNack
+		}
+		confirm := newMethod(classBasic, method).longlong(ch.publishSeq).bit(false)
+		if !accepted {
+			confirm.bit(false)
+		}
+		ch.conn.sendMethod(ch.id, confirm)
+	}
+}
+
+// closeWithError closes the channel with the given error and releases its state.
+func (ch *serverChannel) closeWithError(err *amqpError) {
+	if ch.closing {
+		return
+	}
+	ch.release()
+	ch.closing = true
+
+	ch.conn.sendMethod(ch.id, newMethod(classChannel, methodChannelClose).
+		short(err.code).
+		shortstr(replyTexts[err.code]+" - "+err.text).
+		short(err.classID).
+		short(err.methodID))
+}
+
+// release cancels the consumers of the channel and requeues its unacknowledged deliveries.
+func (ch *serverChannel) release() {
+	ch.closing = true
+	ch.publishing = nil
+	ch.replyConsumer = ""
+
+	consumers := make([]*consumer, 0, len(ch.consumers))
+	for _, c := range ch.consumers {
+		consumers = append(consumers, c)
+	}
+	ch.consumers = make(map[string]*consumer)
+
+	ch.requeueAll()
+
+	for _, c := range consumers {
+		c.queue.removeConsumer(c)
+	}
+}
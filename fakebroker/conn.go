@@ -0,0 +1,441 @@
+package fakebroker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	channelMax = 2047
+
+	// closeTimeout bounds the wait for close-ok after the server closes a connection.
+	closeTimeout = time.Second
+)
+
+var errHandshake = errors.New("fakebroker: handshake failed")
+
+// serverConn is the server side of a client connection.
+type serverConn struct {
+	broker    *Broker
+	conn      net.Conn
+	id        uint64
+	reader    *bufio.Reader
+	vhost     *vhost
+	frameMax  uint32
+	heartbeat time.Duration
+	props     amqp091.Table
+	channels  map[uint16]*serverChannel
+	closing   bool
+
+	out      [][]frame
+	outMu    sync.Mutex
+	outCond  *sync.Cond
+	outClose bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newServerConn(b *Broker, conn net.Conn, id uint64) *serverConn {
+	c := &serverConn{
+		broker:   b,
+		conn:     conn,
+		id:       id,
+		reader:   bufio.NewReader(conn),
+		frameMax: b.frameMax,
+		channels: make(map[uint16]*serverChannel),
+		done:     make(chan struct{}),
+	}
+	c.outCond = sync.NewCond(&c.outMu)
+	return c
+}
+
+// serve runs the connection until the client disconnects or the connection is closed.
+func (c *serverConn) serve() {
+	go c.writeLoop()
+	defer c.cleanup()
+
+	if err := c.handshake(); err != nil {
+		c.terminate()
+		return
+	}
+
+	if c.heartbeat > 0 {
+		go c.heartbeatLoop()
+	}
+
+	c.broker.mu.Lock()
+	if c.broker.blocked {
+		c.sendBlocked()
+	}
+	c.broker.mu.Unlock()
+
+	for {
+		if c.heartbeat > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(2 * c.heartbeat))
+		}
+
+		f, err := readFrame(c.reader)
+		if err != nil {
+			return
+		}
+
+		// Hold back publishes while the broker is blocked, as RabbitMQ stops reading from the socket.
+		if f.typ == frameMethod && isMethod(f.payload, classBasic, methodBasicPublish) {
+			select {
+			case <-c.broker.blockedCh():
+			case <-c.done:
+				return
+			}
+		}
+
+		c.broker.mu.Lock()
+		err = c.handleFrame(f)
+		c.broker.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func isMethod(payload []byte, classID, methodID uint16) bool {
+	d := newDecoder(payload)
+	return d.short() == classID && d.short() == methodID && d.err == nil
+}
+
+func (c *serverConn) handshake() error {
+	header := make([]byte, len(protocolHeader))
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header, protocolHeader) {
+		c.conn.Write(protocolHeader)
+		return errHandshake
+	}
+
+	c.sendMethod(0, newMethod(classConnection, methodConnectionStart).
+		octet(0).
+		octet(9).
+		table(amqp091.Table{
+			"product": "myamqp fakebroker",
+			"version": "0.9.1",
+			"capabilities": amqp091.Table{
+				"publisher_confirms":           true,
+				"exchange_exchange_bindings":   true,
+				"basic.nack":                   true,
+				"consumer_cancel_notify":       true,
+				"connection.blocked":           true,
+				"authentication_failure_close": true,
+				"per_consumer_qos":             true,
+				"direct_reply_to":              true,
+			},
+		}).
+		longstr("PLAIN AMQPLAIN EXTERNAL").
+		longstr("en_US"))
+
+	d, err := c.expect(classConnection, methodConnectionStartOk)
+	if err != nil {
+		return err
+	}
+	c.props = d.table()
+
+	c.sendMethod(0, newMethod(classConnection, methodConnectionTune).
+		short(channelMax).
+		long(c.frameMax).
+		short(uint16(c.broker.heartbeat/time.Second)))
+
+	if d, err = c.expect(classConnection, methodConnectionTuneOk); err != nil {
+		return err
+	}
+	d.short() // channel-max
+	if frameMax := d.long(); frameMax > 0 && frameMax < c.frameMax {
+		c.frameMax = frameMax
+	}
+	c.heartbeat = time.Duration(d.short()) * time.Second
+
+	if d, err = c.expect(classConnection, methodConnectionOpen); err != nil {
+		return err
+	}
+	name := d.shortstr()
+
+	c.broker.mu.Lock()
+	c.vhost = c.broker.vhost(name)
+	c.broker.mu.Unlock()
+
+	c.sendMethod(0, newMethod(classConnection, methodConnectionOpenOk).shortstr(""))
+
+	return nil
+}
+
+// expect reads the next frame, which must be the given connection method.
+func (c *serverConn) expect(classID, methodID uint16) (*decoder, error) {
+	for {
+		f, err := readFrame(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if f.typ == frameHeartbeat {
+			continue
+		}
+
+		d := newDecoder(f.payload)
+		if f.typ != frameMethod || d.short() != classID || d.short() != methodID {
+			return nil, errHandshake
+		}
+		return d, d.err
+	}
+}
+
+func (c *serverConn) handleFrame(f frame) error {
+	if f.typ == frameHeartbeat {
+		return nil
+	}
+
+	if f.channel == 0 {
+		if f.typ != frameMethod {
+			c.closeWithError(newError(replyUnexpectedFrame, "content frame on channel 0"))
+			return nil
+		}
+		return c.handleConnectionMethod(newDecoder(f.payload))
+	}
+
+	if c.closing {
+		return nil
+	}
+
+	ch, ok := c.channels[f.channel]
+	if !ok {
+		d := newDecoder(f.payload)
+		if f.typ == frameMethod && d.short() == classChannel && d.short() == methodChannelOpen {
+			c.openChannel(f.channel)
+			return nil
+		}
+		c.closeWithError(newError(replyChannelError, "expected 'channel.open'"))
+		return nil
+	}
+
+	if err := ch.handleFrame(f); err != nil {
+		var amqpErr *amqpError
+		if !errors.As(err, &amqpErr) {
+			amqpErr = newError(replyInternalError, err.Error())
+		}
+		if isHardError(amqpErr.code) {
+			c.closeWithError(amqpErr)
+		} else {
+			ch.closeWithError(amqpErr)
+		}
+	}
+
+	return nil
+}
+
+func (c *serverConn) handleConnectionMethod(d *decoder) error {
+	classID, methodID := d.short(), d.short()
+	if classID != classConnection {
+		c.closeWithError(newError(replyCommandInvalid, "unexpected method on channel 0"))
+		return nil
+	}
+
+	switch methodID {
+	case methodConnectionClose:
+		c.closeChannels()
+		c.sendMethod(0, newMethod(classConnection, methodConnectionCloseOk))
+		// The writer closes the connection once close-ok is written, which ends the read loop.
+		c.closing = true
+		c.closeAfterFlush()
+		return nil
+	case methodConnectionCloseOk:
+		c.terminate()
+		return io.EOF
+	case methodConnectionUpdateSecret:
+		c.sendMethod(0, newMethod(classConnection, methodConnectionUpdateOk))
+	default:
+		c.closeWithError(newError(replyCommandInvalid, "unexpected connection method"))
+	}
+
+	return nil
+}
+
+func (c *serverConn) openChannel(id uint16) {
+	if id > channelMax {
+		c.closeWithError(newError(replyChannelError, "channel id out of range"))
+		return
+	}
+
+	c.channels[id] = &serverChannel{
+		conn:       c,
+		id:         id,
+		consumers:  make(map[string]*consumer),
+		unacked:    make(map[uint64]*delivery),
+		flowActive: true,
+	}
+	c.sendMethod(id, newMethod(classChannel, methodChannelOpenOk).longstr(""))
+}
+
+// closeWithError closes the connection with the given error. The caller must hold the broker lock.
+func (c *serverConn) closeWithError(err *amqpError) {
+	if c.closing {
+		return
+	}
+	c.closing = true
+	c.closeChannels()
+
+	c.sendMethod(0, newMethod(classConnection, methodConnectionClose).
+		short(err.code).
+		shortstr(replyTexts[err.code]+" - "+err.text).
+		short(err.classID).
+		short(err.methodID))
+
+	time.AfterFunc(closeTimeout, c.terminate)
+}
+
+// closeChannels releases the state of all channels. The caller must hold the broker lock.
+func (c *serverConn) closeChannels() {
+	for _, ch := range c.channels {
+		ch.release()
+	}
+	c.channels = make(map[uint16]*serverChannel)
+}
+
+// cleanup releases the connection state once the connection is gone.
+func (c *serverConn) cleanup() {
+	c.terminate()
+
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c.closeChannels()
+	delete(b.conns, c)
+
+	// Exclusive queues are deleted when their owning connection closes.
+	for _, v := range b.vhosts {
+		for _, q := range v.queues {
+			if q.owner == c {
+				v.deleteQueue(q)
+			}
+		}
+	}
+}
+
+// terminate closes the network connection.
+func (c *serverConn) terminate() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+
+		c.outMu.Lock()
+		c.outClose = true
+		c.outCond.Broadcast()
+		c.outMu.Unlock()
+	})
+}
+
+func (c *serverConn) sendBlocked() {
+	c.sendMethod(0, newMethod(classConnection, methodConnectionBlocked).shortstr(c.broker.reason))
+}
+
+func (c *serverConn) sendUnblocked() {
+	c.sendMethod(0, newMethod(classConnection, methodConnectionUnblocked))
+}
+
+func (c *serverConn) sendMethod(channel uint16, method *encoder) {
+	c.send(frame{typ: frameMethod, channel: channel, payload: method.bytes()})
+}
+
+// sendContent sends a method with content, splitting the body into frames of at most frameMax.
+func (c *serverConn) sendContent(channel uint16, method *encoder, p amqp091.Publishing) {
+	header, err := encodeContentHeader(p, len(p.Body))
+	if err != nil {
+		c.closeWithError(newError(replyInternalError, err.Error()))
+		return
+	}
+
+	frames := []frame{
+		{typ: frameMethod, channel: channel, payload: method.bytes()},
+		{typ: frameHeader, channel: channel, payload: header},
+	}
+
+	size := int(c.frameMax) - frameOverhead
+	for body := p.Body; len(body) > 0; {
+		n := min(size, len(body))
+		frames = append(frames, frame{typ: frameBody, channel: channel, payload: body[:n]})
+		body = body[n:]
+	}
+
+	c.send(frames...)
+}
+
+// send queues frames for the writer, without blocking. The frames are written contiguously.
+func (c *serverConn) send(frames ...frame) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	if c.outClose {
+		return
+	}
+	c.out = append(c.out, frames)
+	c.outCond.Signal()
+}
+
+// closeAfterFlush closes the connection once all queued frames are written.
+func (c *serverConn) closeAfterFlush() {
+	c.send(frame{})
+}
+
+func (c *serverConn) writeLoop() {
+	w := bufio.NewWriter(c.conn)
+	for {
+		c.outMu.Lock()
+		for len(c.out) == 0 && !c.outClose {
+			c.outCond.Wait()
+		}
+		if c.outClose {
+			c.outMu.Unlock()
+			return
+		}
+		batch := c.out
+		c.out = nil
+		c.outMu.Unlock()
+
+		for _, frames := range batch {
+			for _, f := range frames {
+				// The zero frame marks the end of the connection.
+				if f.typ == 0 {
+					w.Flush()
+					c.terminate()
+					return
+				}
+				if err := writeFrame(w, f); err != nil {
+					c.terminate()
+					return
+				}
+			}
+		}
+		if err := w.Flush(); err != nil {
+			c.terminate()
+			return
+		}
+	}
+}
+
+func (c *serverConn) heartbeatLoop() {
+	ticker := time.NewTicker(c.heartbeat / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.send(frame{typ: frameHeartbeat})
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package fakebroker
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type queue struct {
+	vhost      *vhost
+	name       string
+	durable    bool
+	exclusive  bool
+	autoDelete bool
+	owner      *serverConn
+	args       amqp091.Table
+
+	ready     []*queuedMessage
+	unacked   int
+	consumers []*consumer
+	consumed  bool
+	deleted   bool
+
+	messageTTL    int64
+	maxLength     int64
+	overflow      string
+	maxPriority   uint8
+	deliveryLimit int64
+	singleActive  bool
+	expires       int64
+
+	ttlTimer     *time.Timer
+	expiresTimer *time.Timer
+}
+
+type queuedMessage struct {
+	msg           *message
+	redelivered   bool
+	deliveryCount int64
+	expiresAt     time.Time
+}
+
+type consumer struct {
+	tag       string
+	channel   *serverChannel
+	queue     *queue
+	noAck     bool
+	exclusive bool
+	prefetch  uint16
+	unacked   int
+}
+
+func newQueue(v *vhost, name string, durable, exclusive, autoDelete bool, args amqp091.Table, owner *serverConn) *queue {
+	q := &queue{
+		vhost:      v,
+		name:       name,
+		durable:    durable,
+		exclusive:  exclusive,
+		autoDelete: autoDelete,
+		owner:      owner,
+		args:       args,
+		overflow:   "drop-head",
+	}
+
+	q.messageTTL, _ = toInt64(args["x-message-ttl"])
+	q.maxLength, _ = toInt64(args["x-max-length"])
+	q.deliveryLimit, _ = toInt64(args["x-delivery-limit"])
+	q.expires, _ = toInt64(args["x-expires"])
+	if overflow, ok := args["x-overflow"].(string); ok {
+		q.overflow = overflow
+	}
+	if maxPriority, ok := toInt64(args["x-max-priority"]); ok && maxPriority > 0 {
+		q.maxPriority = uint8(min(maxPriority, 255))
+	}
+	q.singleActive, _ = args["x-single-active-consumer"].(bool)
+	if _, ok := args["x-message-ttl"]; !ok {
+		q.messageTTL = -1
+	}
+
+	q.touch()
+
+	return q
+}
+
+// touch restarts the x-expires timer of an unused queue.
+func (q *queue) touch() {
+	if q.expires <= 0 {
+		return
+	}
+	if q.expiresTimer != nil {
+		q.expiresTimer.Stop()
+	}
+	if len(q.consumers) > 0 {
+		return
+	}
+
+	q.expiresTimer = time.AfterFunc(time.Duration(q.expires)*time.Millisecond, func() {
+		b := q.vhost.broker
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if !q.deleted && len(q.consumers) == 0 {
+			q.vhost.deleteQueue(q)
+		}
+	})
+}
+
+// enqueue adds a message to the queue and reports whether the queue accepted it.
+func (q *queue) enqueue(msg *message) bool {
+	q.expire()
+
+	qm := &queuedMessage{msg: msg}
+
+	ttl := q.messageTTL
+	if msg.publishing.Expiration != "" {
+		if expiration, err := strconv.ParseInt(msg.publishing.Expiration, 10, 64); err == nil && (ttl < 0 || expiration < ttl) {
+			ttl = expiration
+		}
+	}
+	if ttl >= 0 {
+		qm.expiresAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+
+	if q.maxLength > 0 && int64(len(q.ready)) >= q.maxLength {
+		switch q.overflow {
+		case "reject-publish":
+			return false
+		case "reject-publish-dlx":
+			q.deadLetter(qm, "maxlen")
+			return false
+		default:
+			head := q.ready[0]
+			q.ready = q.ready[1:]
+			q.deadLetter(head, "maxlen")
+		}
+	}
+
+	q.insert(qm, false)
+	q.dispatch()
+
+	return true
+}
+
+// priority returns the priority of the message, capped to the max priority of the queue.
+func (q *queuedMessage) priority(max uint8) uint8 {
+	if max == 0 {
+		return 0
+	}
+	return min(q.msg.publishing.Priority, max)
+}
+
+// insert adds the message to the ready list, at the end or the front of its priority band.
+func (q *queue) insert(qm *queuedMessage, front bool) {
+	p := qm.priority(q.maxPriority)
+
+	i := len(q.ready)
+	if front {
+		i = 0
+		for i < len(q.ready) && q.ready[i].priority(q.maxPriority) > p {
+			i++
+		}
+	} else if q.maxPriority > 0 {
+		i = 0
+		for i < len(q.ready) && q.ready[i].priority(q.maxPriority) >= p {
+			i++
+		}
+	}
+
+	q.ready = append(q.ready, nil)
+	copy(q.ready[i+1:], q.ready[i:])
+	q.ready[i] = qm
+}
+
+// requeue returns a delivered message to the queue, or dead-letters it when its delivery limit is exceeded.
+func (q *queue) requeue(qm *queuedMessage) {
+	if q.deleted {
+		return
+	}
+
+	qm.redelivered = true
+	qm.deliveryCount++
+	if q.deliveryLimit > 0 && qm.deliveryCount > q.deliveryLimit {
+		q.deadLetter(qm, "delivery_limit")
+		return
+	}
+
+	q.insert(qm, true)
+}
+
+// dispatch delivers ready messages to the consumers with available capacity.
+func (q *queue) dispatch() {
+	q.expire()
+
+	next := 0
+	for len(q.ready) > 0 && len(q.consumers) > 0 {
+		candidates := q.consumers
+		if q.singleActive {
+			candidates = q.consumers[:1]
+		}
+
+		var c *consumer
+		for i := range candidates {
+			candidate := candidates[(next+i)%len(candidates)]
+			if candidate.hasCapacity() {
+				c = candidate
+				next = (next + i + 1) % len(candidates)
+				break
+			}
+		}
+		if c == nil {
+			return
+		}
+
+		qm := q.ready[0]
+		q.ready = q.ready[1:]
+		c.channel.deliver(c, qm)
+	}
+
+	// Rotate the consumers so the next dispatch continues the round-robin.
+	if next > 0 && !q.singleActive && len(q.consumers) > 0 {
+		next %= len(q.consumers)
+		q.consumers = append(q.consumers[next:], q.consumers[:next]...)
+	}
+}
+
+// expire dead-letters the expired ready messages and schedules the next expiry.
+func (q *queue) expire() {
+	now := time.Now()
+	var next time.Time
+
+	kept := q.ready[:0]
+	var expired []*queuedMessage
+	for _, qm := range q.ready {
+		switch {
+		case qm.expiresAt.IsZero():
+			kept = append(kept, qm)
+		case !qm.expiresAt.After(now):
+			expired = append(expired, qm)
+		default:
+			kept = append(kept, qm)
+			if next.IsZero() || qm.expiresAt.Before(next) {
+				next = qm.expiresAt
+			}
+		}
+	}
+	for i := len(kept); i < len(q.ready); i++ {
+		q.ready[i] = nil
+	}
+	q.ready = kept
+
+	for _, qm := range expired {
+		q.deadLetter(qm, "expired")
+	}
+
+	if q.ttlTimer != nil {
+		q.ttlTimer.Stop()
+		q.ttlTimer = nil
+	}
+	if !next.IsZero() {
+		q.ttlTimer = time.AfterFunc(time.Until(next), func() {
+			b := q.vhost.broker
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if !q.deleted {
+				q.dispatch()
+			}
+		})
+	}
+}
+
+// deadLetter publishes the message to the dead letter exchange of the queue, if any, with an x-death header.
+func (q *queue) deadLetter(qm *queuedMessage, reason string) {
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+
+	routingKey := qm.msg.routingKey
+	if dlrk, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		routingKey = dlrk
+	}
+
+	publishing := qm.msg.publishing
+	headers := amqp091.Table{}
+	for k, v := range publishing.Headers {
+		headers[k] = v
+	}
+	headers["x-death"] = appendDeath(headers["x-death"], amqp091.Table{
+		"count":        int64(1),
+		"reason":       reason,
+		"queue":        q.name,
+		"time":         time.Now().Truncate(time.Second),
+		"exchange":     qm.msg.exchange,
+		"routing-keys": []interface{}{qm.msg.routingKey},
+	})
+	if _, ok := headers["x-first-death-reason"]; !ok {
+		headers["x-first-death-reason"] = reason
+		headers["x-first-death-queue"] = q.name
+		headers["x-first-death-exchange"] = qm.msg.exchange
+	}
+	publishing.Headers = headers
+	publishing.Expiration = ""
+
+	if _, ok := q.vhost.exchanges[dlx]; !ok && dlx != "" {
+		return
+	}
+
+	q.vhost.publish(&message{exchange: dlx, routingKey: routingKey, publishing: publishing})
+}
+
+// appendDeath adds the death to the x-death header, incrementing the count of an existing entry
+// for the same queue and reason and moving it to the front.
+func appendDeath(header interface{}, death amqp091.Table) []interface{} {
+	deaths, _ := header.([]interface{})
+
+	updated := []interface{}{death}
+	for _, d := range deaths {
+		existing, ok := d.(amqp091.Table)
+		if ok && existing["queue"] == death["queue"] && existing["reason"] == death["reason"] {
+			count, _ := toInt64(existing["count"])
+			death["count"] = count + 1
+			continue
+		}
+		updated = append(updated, d)
+	}
+
+	return updated
+}
+
+func (q *queue) addConsumer(c *consumer) {
+	q.consumers = append(q.consumers, c)
+	q.consumed = true
+	if q.expiresTimer != nil {
+		q.expiresTimer.Stop()
+	}
+}
+
+// removeConsumer removes the consumer and deletes the queue when it is auto-delete and has no consumers left.
+func (q *queue) removeConsumer(c *consumer) {
+	for i, existing := range q.consumers {
+		if existing == c {
+			q.consumers = append(q.consumers[:i:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+
+	if q.deleted {
+		return
+	}
+	if q.autoDelete && q.consumed && len(q.consumers) == 0 {
+		q.vhost.deleteQueue(q)
+		return
+	}
+
+	q.touch()
+	q.dispatch()
+}
+
+func (c *consumer) hasCapacity() bool {
+	ch := c.channel
+	if !ch.flowActive || ch.closing {
+		return false
+	}
+	if c.noAck {
+		return true
+	}
+	if c.prefetch > 0 && c.unacked >= int(c.prefetch) {
+		return false
+	}
+	if ch.globalPrefetch > 0 && len(ch.unacked) >= int(ch.globalPrefetch) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package fakebroker
+
+// AMQP 0-9-1 class ids.
+const (
+	classConnection = 10
+	classChannel    = 20
+	classExchange   = 40
+	classQueue      = 50
+	classBasic      = 60
+	classConfirm    = 85
+	classTx         = 90
+)
+
+// AMQP 0-9-1 method ids, by class.
+const (
+	methodConnectionStart        = 10
+	methodConnectionStartOk      = 11
+	methodConnectionTune         = 30
+	methodConnectionTuneOk       = 31
+	methodConnectionOpen         = 40
+	methodConnectionOpenOk       = 41
+	methodConnectionClose        = 50
+	methodConnectionCloseOk      = 51
+	methodConnectionBlocked      = 60
+	methodConnectionUnblocked    = 61
+	methodConnectionUpdateSecret = 70
+	methodConnectionUpdateOk     = 71
+
+	methodChannelOpen    = 10
+	methodChannelOpenOk  = 11
+	methodChannelFlow    = 20
+	methodChannelFlowOk  = 21
+	methodChannelClose   = 40
+	methodChannelCloseOk = 41
+
+	methodExchangeDeclare   = 10
+	methodExchangeDeclareOk = 11
+	methodExchangeDelete    = 20
+	methodExchangeDeleteOk  = 21
+	methodExchangeBind      = 30
+	methodExchangeBindOk    = 31
+	methodExchangeUnbind    = 40
+	methodExchangeUnbindOk  = 51
+
+	methodQueueDeclare   = 10
+	methodQueueDeclareOk = 11
+	methodQueueBind      = 20
+	methodQueueBindOk    = 21
+	methodQueuePurge     = 30
+	methodQueuePurgeOk   = 31
+	methodQueueDelete    = 40
+	methodQueueDeleteOk  = 41
+	methodQueueUnbind    = 50
+	methodQueueUnbindOk  = 51
+
+	methodBasicQos          = 10
+	methodBasicQosOk        = 11
+	methodBasicConsume      = 20
+	methodBasicConsumeOk    = 21
+	methodBasicCancel       = 30
+	methodBasicCancelOk     = 31
+	methodBasicPublish      = 40
+	methodBasicReturn       = 50
+	methodBasicDeliver      = 60
+	methodBasicGet          = 70
+	methodBasicGetOk        = 71
+	methodBasicGetEmpty     = 72
+	methodBasicAck          = 80
+	methodBasicReject       = 90
+	methodBasicRecoverAsync = 100
+	methodBasicRecover      = 110
+	methodBasicRecoverOk    = 111
+	methodBasicNack         = 120
+
+	methodConfirmSelect   = 10
+	methodConfirmSelectOk = 11
+)
+
+// AMQP 0-9-1 reply codes.
+const (
+	replySuccess            = 200
+	replyContentTooLarge    = 311
+	replyNoRoute            = 312
+	replyConnectionForced   = 320
+	replyAccessRefused      = 403
+	replyNotFound           = 404
+	replyResourceLocked     = 405
+	replyPreconditionFailed = 406
+	replyFrameError         = 501
+	replySyntaxError        = 502
+	replyCommandInvalid     = 503
+	replyChannelError       = 504
+	replyUnexpectedFrame    = 505
+	replyNotAllowed         = 530
+	replyNotImplemented     = 540
+	replyInternalError      = 541
+)
+
+var replyTexts = map[uint16]string{
+	replySuccess:            "OK",
+	replyNoRoute:            "NO_ROUTE",
+	replyConnectionForced:   "CONNECTION_FORCED",
+	replyAccessRefused:      "ACCESS_REFUSED",
+	replyNotFound:           "NOT_FOUND",
+	replyResourceLocked:     "RESOURCE_LOCKED",
+	replyPreconditionFailed: "PRECONDITION_FAILED",
+	replyFrameError:         "FRAME_ERROR",
+	replySyntaxError:        "SYNTAX_ERROR",
+	replyCommandInvalid:     "COMMAND_INVALID",
+	replyChannelError:       "CHANNEL_ERROR",
+	replyUnexpectedFrame:    "UNEXPECTED_FRAME",
+	replyNotAllowed:         "NOT_ALLOWED",
+	replyNotImplemented:     "NOT_IMPLEMENTED",
+	replyInternalError:      "INTERNAL_ERROR",
+}
+
+// amqpError is an error closing a channel or a connection.
+type amqpError struct {
+	code     uint16
+	text     string
+	classID  uint16
+	methodID uint16
+}
+
+func (e *amqpError) Error() string {
+	return replyTexts[e.code] + " - " + e.text
+}
+
+func newError(code uint16, text string) *amqpError {
+	return &amqpError{code: code, text: text}
+}
+
+// isHardError reports whether the reply code closes the connection rather than the channel.
+func isHardError(code uint16) bool {
+	switch code {
+	case replyConnectionForced, replyFrameError, replySyntaxError, replyCommandInvalid,
+		replyChannelError, replyUnexpectedFrame, replyNotAllowed, replyNotImplemented, replyInternalError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,507 @@
+package fakebroker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+
+	// frameOverhead is the size of the frame type, channel, size and end octets.
+	frameOverhead = 8
+)
+
+var (
+	errFrameEnd     = errors.New("fakebroker: invalid frame end")
+	errShortPayload = errors.New("fakebroker: short payload")
+	errFieldType    = errors.New("fakebroker: unsupported field type")
+)
+
+var protocolHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+type frame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var head [7]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		typ:     head[0],
+		channel: binary.BigEndian.Uint16(head[1:3]),
+		payload: make([]byte, binary.BigEndian.Uint32(head[3:7])),
+	}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+
+	end, err := r.ReadByte()
+	if err != nil {
+		return frame{}, err
+	}
+	if end != frameEnd {
+		return frame{}, errFrameEnd
+	}
+
+	return f, nil
+}
+
+func writeFrame(w *bufio.Writer, f frame) error {
+	var head [7]byte
+	head[0] = f.typ
+	binary.BigEndian.PutUint16(head[1:3], f.channel)
+	binary.BigEndian.PutUint32(head[3:7], uint32(len(f.payload)))
+
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(f.payload); err != nil {
+		return err
+	}
+	return w.WriteByte(frameEnd)
+}
+
+// encoder encodes method arguments and content headers.
+// Consecutive bits are packed into a single octet, least significant bit first.
+type encoder struct {
+	buf   bytes.Buffer
+	bits  byte
+	nbits int
+	err   error
+}
+
+func newMethod(classID, methodID uint16) *encoder {
+	e := &encoder{}
+	e.short(classID)
+	e.short(methodID)
+	return e
+}
+
+func (e *encoder) flushBits() {
+	if e.nbits > 0 {
+		e.buf.WriteByte(e.bits)
+		e.bits, e.nbits = 0, 0
+	}
+}
+
+func (e *encoder) bit(b bool) *encoder {
+	if e.nbits == 8 {
+		e.flushBits()
+	}
+	if b {
+		e.bits |= 1 << e.nbits
+	}
+	e.nbits++
+	return e
+}
+
+func (e *encoder) octet(v byte) *encoder {
+	e.flushBits()
+	e.buf.WriteByte(v)
+	return e
+}
+
+func (e *encoder) short(v uint16) *encoder {
+	e.flushBits()
+	binary.Write(&e.buf, binary.BigEndian, v)
+	return e
+}
+
+func (e *encoder) long(v uint32) *encoder {
+	e.flushBits()
+	binary.Write(&e.buf, binary.BigEndian, v)
+	return e
+}
+
+func (e *encoder) longlong(v uint64) *encoder {
+	e.flushBits()
+	binary.Write(&e.buf, binary.BigEndian, v)
+	return e
+}
+
+func (e *encoder) shortstr(s string) *encoder {
+	e.flushBits()
+	if len(s) > math.MaxUint8 {
+		s = s[:math.MaxUint8]
+	}
+	e.buf.WriteByte(byte(len(s)))
+	e.buf.WriteString(s)
+	return e
+}
+
+func (e *encoder) longstr(s string) *encoder {
+	e.flushBits()
+	binary.Write(&e.buf, binary.BigEndian, uint32(len(s)))
+	e.buf.WriteString(s)
+	return e
+}
+
+func (e *encoder) table(t amqp091.Table) *encoder {
+	e.flushBits()
+	var nested bytes.Buffer
+	for k, v := range t {
+		nested.WriteByte(byte(len(k)))
+		nested.WriteString(k)
+		if err := writeField(&nested, v); err != nil && e.err == nil {
+			e.err = err
+		}
+	}
+	binary.Write(&e.buf, binary.BigEndian, uint32(nested.Len()))
+	e.buf.Write(nested.Bytes())
+	return e
+}
+
+func (e *encoder) bytes() []byte {
+	e.flushBits()
+	return e.buf.Bytes()
+}
+
+func writeField(w *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case bool:
+		w.WriteByte('t')
+		if v {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	case byte:
+		w.WriteByte('B')
+		w.WriteByte(v)
+	case int8:
+		w.WriteByte('b')
+		w.WriteByte(byte(v))
+	case int16:
+		w.WriteByte('s')
+		binary.Write(w, binary.BigEndian, v)
+	case int:
+		w.WriteByte('I')
+		binary.Write(w, binary.BigEndian, int32(v))
+	case int32:
+		w.WriteByte('I')
+		binary.Write(w, binary.BigEndian, v)
+	case int64:
+		w.WriteByte('l')
+		binary.Write(w, binary.BigEndian, v)
+	case float32:
+		w.WriteByte('f')
+		binary.Write(w, binary.BigEndian, v)
+	case float64:
+		w.WriteByte('d')
+		binary.Write(w, binary.BigEndian, v)
+	case amqp091.Decimal:
+		w.WriteByte('D')
+		w.WriteByte(v.Scale)
+		binary.Write(w, binary.BigEndian, v.Value)
+	case string:
+		w.WriteByte('S')
+		binary.Write(w, binary.BigEndian, uint32(len(v)))
+		w.WriteString(v)
+	case []interface{}:
+		var nested bytes.Buffer
+		for _, item := range v {
+			if err := writeField(&nested, item); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('A')
+		binary.Write(w, binary.BigEndian, uint32(nested.Len()))
+		w.Write(nested.Bytes())
+	case time.Time:
+		w.WriteByte('T')
+		binary.Write(w, binary.BigEndian, v.Unix())
+	case amqp091.Table:
+		e := &encoder{}
+		e.table(v)
+		w.WriteByte('F')
+		w.Write(e.bytes())
+		return e.err
+	case []byte:
+		w.WriteByte('x')
+		binary.Write(w, binary.BigEndian, uint32(len(v)))
+		w.Write(v)
+	case nil:
+		w.WriteByte('V')
+	default:
+		return fmt.Errorf("%w: %T", errFieldType, value)
+	}
+
+	return nil
+}
+
+// decoder decodes method arguments and content headers.
+type decoder struct {
+	b     []byte
+	off   int
+	bits  byte
+	nbits int
+	err   error
+}
+
+func newDecoder(b []byte) *decoder {
+	return &decoder{b: b}
+}
+
+func (d *decoder) next(n int) []byte {
+	d.nbits = 0
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if d.off+n > len(d.b) {
+		d.err = errShortPayload
+		return make([]byte, n)
+	}
+	b := d.b[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) bit() bool {
+	if d.nbits == 0 || d.nbits == 8 {
+		d.bits = d.next(1)[0]
+		d.nbits = 0
+	}
+	b := d.bits&(1<<d.nbits) != 0
+	d.nbits++
+	return b
+}
+
+func (d *decoder) octet() byte {
+	return d.next(1)[0]
+}
+
+func (d *decoder) short() uint16 {
+	return binary.BigEndian.Uint16(d.next(2))
+}
+
+func (d *decoder) long() uint32 {
+	return binary.BigEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) longlong() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) shortstr() string {
+	return string(d.next(int(d.octet())))
+}
+
+func (d *decoder) longstr() string {
+	return string(d.next(int(d.long())))
+}
+
+func (d *decoder) table() amqp091.Table {
+	nested := newDecoder(d.next(int(d.long())))
+	t := amqp091.Table{}
+	for nested.err == nil && nested.off < len(nested.b) {
+		key := nested.shortstr()
+		t[key] = nested.field()
+	}
+	if nested.err != nil && d.err == nil {
+		d.err = nested.err
+	}
+	return t
+}
+
+func (d *decoder) field() interface{} {
+	switch typ := d.octet(); typ {
+	case 't':
+		return d.octet() != 0
+	case 'B':
+		return d.octet()
+	case 'b':
+		return int8(d.octet())
+	case 's':
+		return int16(d.short())
+	case 'I':
+		return int32(d.long())
+	case 'l':
+		return int64(d.longlong())
+	case 'f':
+		return math.Float32frombits(d.long())
+	case 'd':
+		return math.Float64frombits(d.longlong())
+	case 'D':
+		scale := d.octet()
+		return amqp091.Decimal{Scale: scale, Value: int32(d.long())}
+	case 'S':
+		return d.longstr()
+	case 'A':
+		nested := newDecoder(d.next(int(d.long())))
+		var arr []interface{}
+		for nested.err == nil && nested.off < len(nested.b) {
+			arr = append(arr, nested.field())
+		}
+		if nested.err != nil && d.err == nil {
+			d.err = nested.err
+		}
+		return arr
+	case 'T':
+		return time.Unix(int64(d.longlong()), 0)
+	case 'F':
+		return d.table()
+	case 'x':
+		return append([]byte(nil), d.next(int(d.long()))...)
+	case 'V':
+		return nil
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("%w: %q", errFieldType, typ)
+		}
+		return nil
+	}
+}
+
+const (
+	flagContentType     = 0x8000
+	flagContentEncoding = 0x4000
+	flagHeaders         = 0x2000
+	flagDeliveryMode    = 0x1000
+	flagPriority        = 0x0800
+	flagCorrelationID   = 0x0400
+	flagReplyTo         = 0x0200
+	flagExpiration      = 0x0100
+	flagMessageID       = 0x0080
+	flagTimestamp       = 0x0040
+	flagType            = 0x0020
+	flagUserID          = 0x0010
+	flagAppID           = 0x0008
+)
+
+// encodeContentHeader encodes the content header of a basic class message with the given body size.
+func encodeContentHeader(p amqp091.Publishing, size int) ([]byte, error) {
+	var flags uint16
+	props := &encoder{}
+
+	if p.ContentType != "" {
+		flags |= flagContentType
+		props.shortstr(p.ContentType)
+	}
+	if p.ContentEncoding != "" {
+		flags |= flagContentEncoding
+		props.shortstr(p.ContentEncoding)
+	}
+	if len(p.Headers) > 0 {
+		flags |= flagHeaders
+		props.table(p.Headers)
+	}
+	if p.DeliveryMode != 0 {
+		flags |= flagDeliveryMode
+		props.octet(p.DeliveryMode)
+	}
+	if p.Priority != 0 {
+		flags |= flagPriority
+		props.octet(p.Priority)
+	}
+	if p.CorrelationId != "" {
+		flags |= flagCorrelationID
+		props.shortstr(p.CorrelationId)
+	}
+	if p.ReplyTo != "" {
+		flags |= flagReplyTo
+		props.shortstr(p.ReplyTo)
+	}
+	if p.Expiration != "" {
+		flags |= flagExpiration
+		props.shortstr(p.Expiration)
+	}
+	if p.MessageId != "" {
+		flags |= flagMessageID
+		props.shortstr(p.MessageId)
+	}
+	if !p.Timestamp.IsZero() {
+		flags |= flagTimestamp
+		props.longlong(uint64(p.Timestamp.Unix()))
+	}
+	if p.Type != "" {
+		flags |= flagType
+		props.shortstr(p.Type)
+	}
+	if p.UserId != "" {
+		flags |= flagUserID
+		props.shortstr(p.UserId)
+	}
+	if p.AppId != "" {
+		flags |= flagAppID
+		props.shortstr(p.AppId)
+	}
+
+	header := &encoder{}
+	header.short(classBasic)
+	header.short(0)
+	header.longlong(uint64(size))
+	header.short(flags)
+	header.buf.Write(props.bytes())
+
+	return header.bytes(), props.err
+}
+
+// decodeContentHeader decodes a content header into the properties of a Publishing and returns the body size.
+func decodeContentHeader(payload []byte) (amqp091.Publishing, uint64, error) {
+	var p amqp091.Publishing
+	d := newDecoder(payload)
+	d.short() // class
+	d.short() // weight
+	size := d.longlong()
+	flags := d.short()
+
+	if flags&flagContentType != 0 {
+		p.ContentType = d.shortstr()
+	}
+	if flags&flagContentEncoding != 0 {
+		p.ContentEncoding = d.shortstr()
+	}
+	if flags&flagHeaders != 0 {
+		p.Headers = d.table()
+	}
+	if flags&flagDeliveryMode != 0 {
+		p.DeliveryMode = d.octet()
+	}
+	if flags&flagPriority != 0 {
+		p.Priority = d.octet()
+	}
+	if flags&flagCorrelationID != 0 {
+		p.CorrelationId = d.shortstr()
+	}
+	if flags&flagReplyTo != 0 {
+		p.ReplyTo = d.shortstr()
+	}
+	if flags&flagExpiration != 0 {
+		p.Expiration = d.shortstr()
+	}
+	if flags&flagMessageID != 0 {
+		p.MessageId = d.shortstr()
+	}
+	if flags&flagTimestamp != 0 {
+		p.Timestamp = time.Unix(int64(d.longlong()), 0)
+	}
+	if flags&flagType != 0 {
+		p.Type = d.shortstr()
+	}
+	if flags&flagUserID != 0 {
+		p.UserId = d.shortstr()
+	}
+	if flags&flagAppID != 0 {
+		p.AppId = d.shortstr()
+	}
+
+	return p, size, d.err
+}
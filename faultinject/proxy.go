@@ -0,0 +1,262 @@
+// Package faultinject provides a local TCP proxy injecting network faults between MyAMQP and an AMQP server,
+// or the in-process fakebroker, to test reconnects.
+//
+//	proxy, err := faultinject.NewProxy("localhost:5672")
+//	defer proxy.Close()
+//
+//	config, err := myamqp.NewConfig(func() (*amqp091.Connection, error) {
+//		return amqp091.Dial("amqp://guest:guest@" + proxy.Addr() + "/")
+//	})
+//
+//	// Later, in the test.
+//	proxy.Reset()
+package faultinject
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrProxyClosed = errors.New("faultinject: proxy closed")
+)
+
+// Proxy is a TCP proxy forwarding connections to an upstream address, with injectable faults.
+// All methods are safe for concurrent use.
+type Proxy struct {
+	listener  net.Listener
+	upstream  string
+	links     map[*link]struct{}
+	delay     time.Duration
+	blackhole bool
+	reject    bool
+	accepted  int
+	closed    bool
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+}
+
+// link is a proxied connection.
+type link struct {
+	client   net.Conn
+	upstream net.Conn
+	once     sync.Once
+}
+
+// NewProxy starts a Proxy listening on a random local port and forwarding to the upstream address.
+func NewProxy(upstream string) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		listener: listener,
+		upstream: upstream,
+		links:    make(map[*link]struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.acceptLoop()
+
+	return p, nil
+}
+
+// Addr returns the address to connect to, e.g. "127.0.0.1:41234".
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Accepted returns the number of connections accepted, including rejected ones.
+func (p *Proxy) Accepted() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.accepted
+}
+
+// Connections returns the number of open proxied connections.
+func (p *Proxy) Connections() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.links)
+}
+
+// Delay delays every chunk of data forwarded in both directions by the given duration.
+// Zero removes the delay.
+func (p *Proxy) Delay(delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.delay = delay
+}
+
+// Blackhole silently discards all data in both directions while keeping the connections open,
+// as a network partition does. Only heartbeats detect it.
+func (p *Proxy) Blackhole() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blackhole = true
+}
+
+// Reject closes new connections right after accepting them, so every dial fails.
+// Open connections are not affected.
+func (p *Proxy) Reject() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reject = true
+}
+
+// Heal removes the delay, the blackhole and the rejection of new connections.
+// Data discarded while blackholed is lost.
+func (p *Proxy) Heal() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.delay = 0
+	p.blackhole = false
+	p.reject = false
+}
+
+// Drop closes all open connections gracefully, as a broker restart does.
+func (p *Proxy) Drop() {
+	for _, l := range p.takeLinks() {
+		l.close(false)
+	}
+}
+
+// Reset closes all open connections with a TCP reset, as a crashed peer or a firewall does.
+func (p *Proxy) Reset() {
+	for _, l := range p.takeLinks() {
+		l.close(true)
+	}
+}
+
+// Close stops accepting connections and closes all open connections.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	err := p.listener.Close()
+	p.Drop()
+	p.wg.Wait()
+
+	return err
+}
+
+func (p *Proxy) takeLinks() []*link {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	links := make([]*link, 0, len(p.links))
+	for l := range p.links {
+		links = append(links, l)
+	}
+	p.links = make(map[*link]struct{})
+
+	return links
+}
+
+func (p *Proxy) acceptLoop() {
+	defer p.wg.Done()
+
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		p.accepted++
+		reject := p.reject || p.closed
+		p.mu.Unlock()
+
+		if reject {
+			client.Close()
+			continue
+		}
+
+		p.wg.Add(1)
+		go p.serve(client)
+	}
+}
+
+func (p *Proxy) serve(client net.Conn) {
+	defer p.wg.Done()
+
+	upstream, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		client.Close()
+		return
+	}
+
+	l := &link{client: client, upstream: upstream}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		l.close(false)
+		return
+	}
+	p.links[l] = struct{}{}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.forward(upstream, client)
+		l.close(false)
+	}()
+	go func() {
+		defer wg.Done()
+		p.forward(client, upstream)
+		l.close(false)
+	}()
+	wg.Wait()
+
+	p.mu.Lock()
+	delete(p.links, l)
+	p.mu.Unlock()
+}
+
+// forward copies data from src to dst, applying the faults of the Proxy.
+func (p *Proxy) forward(dst io.Writer, src io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			p.mu.Lock()
+			delay, blackhole := p.delay, p.blackhole
+			p.mu.Unlock()
+
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+			if !blackhole {
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// close closes both sides of the link. With reset, the connections are closed with a TCP reset.
+func (l *link) close(reset bool) {
+	l.once.Do(func() {
+		if reset {
+			for _, conn := range []net.Conn{l.client, l.upstream} {
+				if tcp, ok := conn.(*net.TCPConn); ok {
+					tcp.SetLinger(0)
+				}
+			}
+		}
+		l.client.Close()
+		l.upstream.Close()
+	})
+}
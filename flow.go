@@ -0,0 +1,298 @@
+package myamqp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrConnectionBlocked  = errors.New("connection blocked")
+	ErrPublishBufferFull  = errors.New("publish buffer full")
+	ErrBlockedWaitTimeout = errors.New("timeout waiting for connection to be unblocked")
+)
+
+// BlockedMode represents how a Producer publishes while the connection is blocked by the AMQP server.
+type BlockedMode int
+
+const (
+	// BlockedModePublish publishes regardless, the publish blocks until the connection is unblocked or ctx is done.
+	BlockedModePublish BlockedMode = iota
+	// BlockedModeFailFast fails the publish with ErrConnectionBlocked.
+	BlockedModeFailFast
+	// BlockedModeWait waits for the connection to be unblocked, failing with ErrBlockedWaitTimeout after the timeout.
+	BlockedModeWait
+	// BlockedModeBuffer buffers the message and publishes it once the connection is unblocked,
+	// failing with ErrPublishBufferFull when the buffer is full.
+	BlockedModeBuffer
+)
+
+// BlockedPolicy represents options for publishing while the connection is blocked.
+type BlockedPolicy struct {
+	mode       BlockedMode
+	timeout    time.Duration
+	bufferSize int
+	onDropped  func(routingKey string, msg amqp091.Publishing, err error)
+}
+
+// NewBlockedPolicyFailFast creates a new BlockedPolicy failing publishes with ErrConnectionBlocked.
+func NewBlockedPolicyFailFast() *BlockedPolicy {
+	return &BlockedPolicy{
+		mode: BlockedModeFailFast,
+	}
+}
+
+// NewBlockedPolicyWait creates a new BlockedPolicy waiting up to the timeout for the connection to be unblocked.
+// If timeout is 0, it waits until the context of the publish is done.
+func NewBlockedPolicyWait(timeout time.Duration) *BlockedPolicy {
+	return &BlockedPolicy{
+		mode:    BlockedModeWait,
+		timeout: timeout,
+	}
+}
+
+// NewBlockedPolicyBuffer creates a new BlockedPolicy buffering up to bufferSize messages while the connection is blocked.
+// PublishWithDeferredConfirm fails with ErrConnectionBlocked, as the confirmation of a buffered message is not known yet.
+// Buffered messages which cannot be published, e.g. as the connection dropped before it was unblocked, are dropped,
+// see WithOnDropped.
+func NewBlockedPolicyBuffer(bufferSize int) *BlockedPolicy {
+	return &BlockedPolicy{
+		mode:       BlockedModeBuffer,
+		bufferSize: bufferSize,
+	}
+}
+
+// WithOnDropped sets the OnDropped callback on the BlockedPolicy. It is called with each buffered message which
+// could not be published, Publish having returned nil for it, e.g. to publish it again with a new Producer.
+// The message is the one prepared for publishing, compressed and without the body offloaded by a ClaimCheck,
+// which is deleted. Dropped messages are logged when it is not set.
+func (b *BlockedPolicy) WithOnDropped(callback func(routingKey string, msg amqp091.Publishing, err error)) *BlockedPolicy {
+	b.onDropped = callback
+	return b
+}
+
+// Mode returns the mode on the BlockedPolicy.
+func (b *BlockedPolicy) Mode() BlockedMode {
+	return b.mode
+}
+
+// flowControl tracks the connection.blocked state of the connection.
+type flowControl struct {
+	blocked   bool
+	reason    string
+	unblocked chan struct{}
+	mu        sync.Mutex
+}
+
+func newFlowControl() *flowControl {
+	unblocked := make(chan struct{})
+	close(unblocked)
+
+	return &flowControl{
+		unblocked: unblocked,
+	}
+}
+
+func (f *flowControl) set(blocked bool, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if blocked && !f.blocked {
+		f.unblocked = make(chan struct{})
+	}
+	if !blocked && f.blocked {
+		close(f.unblocked)
+	}
+	f.blocked = blocked
+	f.reason = reason
+}
+
+func (f *flowControl) state() (bool, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.blocked, f.reason
+}
+
+// wait waits for the connection to be unblocked.
+func (f *flowControl) wait(ctx context.Context) error {
+	f.mu.Lock()
+	unblocked := f.unblocked
+	f.mu.Unlock()
+
+	select {
+	case <-unblocked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsBlocked reports whether the connection is blocked by the AMQP server, e.g. on a memory or disk alarm.
+func (s *MyAMQP) IsBlocked() bool {
+	blocked, _ := s.flow.state()
+	return blocked
+}
+
+// watchBlocked tracks the blocked state of the connection and notifies the OnBlocked callback.
+func (s *MyAMQP) watchBlocked(conn Connection) {
+	blockings := conn.NotifyBlocked(make(chan amqp091.Blocking, 1))
+	go func() {
+		for b := range blockings {
+			s.flow.set(b.Active, b.Reason)
+			if b.Active {
+				s.logger().Warn("connection blocked", "reason", b.Reason)
+			} else {
+				s.logger().Info("connection unblocked")
+			}
+			if s.config.OnBlocked() != nil {
+				s.config.OnBlocked()(b.Active, b.Reason)
+			}
+		}
+	}()
+}
+
+type bufferedPublishing struct {
+	routingKey string
+	mandatory  bool
+	immediate  bool
+	msg        amqp091.Publishing
+	offloaded  string
+}
+
+// checkBlocked applies the BlockedPolicy before publishing. It returns true when the message was buffered,
+// with the key of its body offloaded by the ClaimCheck, deleted if the message is dropped.
+func (s *Producer) checkBlocked(ctx context.Context, routingKey string, mandatory, immediate, confirm bool, msg amqp091.Publishing, offloaded string) (bool, error) {
+	policy := s.options.blockedPolicy
+	if policy == nil {
+		return false, nil
+	}
+
+	switch policy.mode {
+	case BlockedModeFailFast:
+		if blocked, _ := s.flow.state(); blocked {
+			return false, ErrConnectionBlocked
+		}
+	case BlockedModeWait:
+		waitCtx := ctx
+		if policy.timeout > 0 {
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithTimeoutCause(ctx, policy.timeout, ErrBlockedWaitTimeout)
+			defer cancel()
+		}
+		if err := s.flow.wait(waitCtx); err != nil {
+			// The ctx of the publish may end first, e.g. with its own deadline.
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			return false, context.Cause(waitCtx)
+		}
+	case BlockedModeBuffer:
+		s.bufMu.Lock()
+		defer s.bufMu.Unlock()
+
+		// Keep buffering while the buffer is flushed, so messages keep their order.
+		if blocked, _ := s.flow.state(); !blocked && len(s.buffer) == 0 {
+			return false, nil
+		}
+		if confirm {
+			return false, ErrConnectionBlocked
+		}
+		// A message buffered on a closed channel would only be dropped.
+		if s.channel.IsClosed() {
+			return false, amqp091.ErrClosed
+		}
+		if len(s.buffer) >= policy.bufferSize {
+			return false, ErrPublishBufferFull
+		}
+
+		s.buffer = append(s.buffer, bufferedPublishing{
+			routingKey: routingKey,
+			mandatory:  mandatory,
+			immediate:  immediate,
+			msg:        msg,
+			offloaded:  offloaded,
+		})
+		if !s.flushing {
+			s.flushing = true
+			go s.flushBuffer()
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// flushBuffer publishes the buffered messages once the connection is unblocked. When the channel closes
+// first, e.g. as the connection dropped, or a publish fails, the buffered messages are dropped.
+func (s *Producer) flushBuffer() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	closed := s.channel.NotifyClose(make(chan *amqp091.Error, 1))
+	go func() {
+		select {
+		case <-closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// MyAMQP unblocks the flow on reconnect, before the Producer of the previous connection knows its channel closed.
+	if err := s.flow.wait(ctx); err != nil || s.channel.IsClosed() {
+		s.dropBuffer(amqp091.ErrClosed)
+		return
+	}
+
+	for {
+		s.bufMu.Lock()
+		if len(s.buffer) == 0 {
+			s.flushing = false
+			s.bufMu.Unlock()
+			return
+		}
+		p := s.buffer[0]
+		s.bufMu.Unlock()
+
+		err := s.channel.PublishWithContext(
+			ctx,
+			s.options.exchangeOpts.name,
+			p.routingKey,
+			p.mandatory,
+			p.immediate,
+			p.msg,
+		)
+		s.metrics.Publish(s.options.exchangeOpts.name, p.routingKey, err)
+		if err != nil {
+			s.logger.Error("buffered publish failed", "routing_key", p.routingKey, "error", err)
+			s.dropBuffer(err)
+			return
+		}
+
+		// The message is removed only after publishing, so new messages are buffered behind it.
+		s.bufMu.Lock()
+		s.buffer = s.buffer[1:]
+		s.bufMu.Unlock()
+	}
+}
+
+// dropBuffer drops the buffered messages, reporting each one to the OnDropped callback of the BlockedPolicy.
+// It ends the flush, messages buffered afterwards are flushed by a new flushBuffer.
+func (s *Producer) dropBuffer(err error) {
+	s.bufMu.Lock()
+	dropped := s.buffer
+	s.buffer = nil
+	s.flushing = false
+	s.bufMu.Unlock()
+
+	for _, p := range dropped {
+		s.releaseOffloaded(p.offloaded)
+		if s.options.blockedPolicy.onDropped != nil {
+			s.options.blockedPolicy.onDropped(p.routingKey, p.msg, err)
+		} else {
+			s.logger.Error("buffered message dropped", "routing_key", p.routingKey, "error", err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package myamqp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/dmasior/myamqp/fakebroker"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// newBlockedProducer returns a producer to the orders queue with the BlockedPolicy, on a connection blocked by the broker.
+func newBlockedProducer(t *testing.T, policy *myamqp.BlockedPolicy) (*fakebroker.Broker, *myamqp.MyAMQP, *myamqp.Producer) {
+	t.Helper()
+
+	broker := newBroker(t)
+	amqp := connect(t, broker)
+	producer, err := amqp.Producer(myamqp.NewProducerOptions(defaultExchange()).
+		WithQueueOptions(myamqp.NewQueueOptions("orders").WithSkipBind(true)).
+		WithBlockedPolicy(policy))
+	if err != nil {
+		t.Fatalf("Producer: %v", err)
+	}
+	t.Cleanup(func() { producer.Close() })
+
+	broker.Block("low on memory")
+	waitFor(t, "the connection to be blocked", amqp.IsBlocked)
+	return broker, amqp, producer
+}
+
+func publishBody(producer *myamqp.Producer, body string) error {
+	return producer.Publish(context.Background(), "orders", false, false, amqp091.Publishing{Body: []byte(body)})
+}
+
+// assertBodies checks the ready messages of the orders queue, in order.
+func assertBodies(t *testing.T, broker *fakebroker.Broker, want []string) {
+	t.Helper()
+
+	waitFor(t, fmt.Sprintf("%d messages", len(want)), func() bool { return len(broker.Messages("orders")) >= len(want) })
+	messages := broker.Messages("orders")
+	if len(messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(messages), len(want))
+	}
+	for i, m := range messages {
+		if string(m.Body) != want[i] {
+			t.Fatalf("message %d: got body %q, want %q", i, m.Body, want[i])
+		}
+	}
+}
+
+func TestBlockedPolicyFailFast(t *testing.T) {
+	broker, amqp, producer := newBlockedProducer(t, myamqp.NewBlockedPolicyFailFast())
+
+	if err := publishBody(producer, "1"); !errors.Is(err, myamqp.ErrConnectionBlocked) {
+		t.Errorf("Publish: got error %v, want %v", err, myamqp.ErrConnectionBlocked)
+	}
+
+	broker.Unblock()
+	waitFor(t, "the connection to be unblocked", func() bool { return !amqp.IsBlocked() })
+	if err := publishBody(producer, "2"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	assertBodies(t, broker, []string{"2"})
+}
+
+func TestBlockedPolicyWait(t *testing.T) {
+	_, _, producer := newBlockedProducer(t, myamqp.NewBlockedPolicyWait(20*time.Millisecond))
+
+	if err := publishBody(producer, "1"); !errors.Is(err, myamqp.ErrBlockedWaitTimeout) {
+		t.Errorf("Publish: got error %v, want %v", err, myamqp.ErrBlockedWaitTimeout)
+	}
+
+	// The context of the publish may end first.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := producer.Publish(ctx, "orders", false, false, amqp091.Publishing{Body: []byte("2")}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Publish: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBlockedPolicyWaitUnblocked(t *testing.T) {
+	broker, _, producer := newBlockedProducer(t, myamqp.NewBlockedPolicyWait(0))
+
+	published := make(chan error, 1)
+	go func() { published <- publishBody(producer, "1") }()
+
+	select {
+	case err := <-published:
+		t.Fatalf("Publish returned while blocked: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	broker.Unblock()
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	case <-waitAfter():
+		t.Fatal("Publish not released by the unblock")
+	}
+	assertBodies(t, broker, []string{"1"})
+}
+
+func TestBlockedPolicyBuffer(t *testing.T) {
+	broker, _, producer := newBlockedProducer(t, myamqp.NewBlockedPolicyBuffer(3))
+
+	for _, body := range []string{"1", "2", "3"} {
+		if err := publishBody(producer, body); err != nil {
+			t.Fatalf("Publish %s: %v", body, err)
+		}
+	}
+	if err := publishBody(producer, "4"); !errors.Is(err, myamqp.ErrPublishBufferFull) {
+		t.Errorf("Publish: got error %v, want %v", err, myamqp.ErrPublishBufferFull)
+	}
+	if _, err := producer.PublishWithDeferredConfirm(context.Background(), "orders", false, false, amqp091.Publishing{}); !errors.Is(err, myamqp.ErrConnectionBlocked) {
+		t.Errorf("PublishWithDeferredConfirm: got error %v, want %v", err, myamqp.ErrConnectionBlocked)
+	}
+	if messages := broker.Messages("orders"); len(messages) != 0 {
+		t.Fatalf("buffered messages published while blocked: %v", messages)
+	}
+
+	broker.Unblock()
+	assertBodies(t, broker, []string{"1", "2", "3"})
+}
+
+// TestBlockedPolicyBufferBlockedAgain blocks the connection again while the buffer is flushed,
+// the buffered messages are published once each, in order.
+func TestBlockedPolicyBufferBlockedAgain(t *testing.T) {
+	broker, amqp, producer := newBlockedProducer(t, myamqp.NewBlockedPolicyBuffer(1000))
+
+	var want []string
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 5; i++ {
+			body := fmt.Sprintf("%d.%d", round, i)
+			if err := publishBody(producer, body); err != nil {
+				t.Fatalf("Publish %s: %v", body, err)
+			}
+			want = append(want, body)
+		}
+		broker.Unblock()
+		broker.Block("low on memory")
+		waitFor(t, "the connection to be blocked", amqp.IsBlocked)
+	}
+	broker.Unblock()
+
+	assertBodies(t, broker, want)
+	time.Sleep(20 * time.Millisecond)
+	if got := len(broker.Messages("orders")); got != len(want) {
+		t.Errorf("got %d messages, want %d", got, len(want))
+	}
+}
+
+func TestBlockedPolicyBufferDropped(t *testing.T) {
+	var mu sync.Mutex
+	var dropped []string
+	policy := myamqp.NewBlockedPolicyBuffer(10).WithOnDropped(func(_ string, msg amqp091.Publishing, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dropped = append(dropped, string(msg.Body))
+	})
+	broker, _, producer := newBlockedProducer(t, policy)
+
+	for _, body := range []string{"1", "2"} {
+		if err := publishBody(producer, body); err != nil {
+			t.Fatalf("Publish %s: %v", body, err)
+		}
+	}
+
+	// The connection drops before it is unblocked.
+	broker.CloseConnections()
+	waitFor(t, "the buffered messages to be dropped", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(dropped) == 2
+	})
+	if dropped[0] != "1" || dropped[1] != "2" {
+		t.Errorf("got dropped %v, want [1 2]", dropped)
+	}
+}
+
+func TestOnBlocked(t *testing.T) {
+	type blocking struct {
+		blocked bool
+		reason  string
+	}
+	blockings := make(chan blocking, 2)
+	broker := newBroker(t)
+	amqp := connect(t, broker, func(c *myamqp.Config) *myamqp.Config {
+		return c.WithOnBlocked(func(blocked bool, reason string) { blockings <- blocking{blocked, reason} })
+	})
+
+	broker.Block("low on memory")
+	broker.Unblock()
+
+	for _, want := range []blocking{{true, "low on memory"}, {false, ""}} {
+		select {
+		case got := <-blockings:
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		case <-waitAfter():
+			t.Fatalf("OnBlocked not called with %+v", want)
+		}
+	}
+	if amqp.IsBlocked() {
+		t.Error("connection is still blocked")
+	}
+}
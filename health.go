@@ -0,0 +1,223 @@
+package myamqp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthCheck represents the kind of check answered by HealthHandler.
+type HealthCheck int
+
+const (
+	// HealthCheckLiveness passes while Run is running, including while reconnecting.
+	HealthCheckLiveness HealthCheck = iota
+	// HealthCheckReadiness passes when the connection is open and not blocked,
+	// and all consumer and producer channels are open.
+	HealthCheckReadiness
+)
+
+// ChannelHealth represents the state of the channel of a consumer or a producer.
+type ChannelHealth struct {
+	Name string `json:"name"`
+	Open bool   `json:"open"`
+}
+
+// HealthStatus represents the state of MyAMQP.
+type HealthStatus struct {
+	Running          bool            `json:"running"`
+	Connected        bool            `json:"connected"`
+	Blocked          bool            `json:"blocked"`
+	BlockedReason    string          `json:"blocked_reason,omitempty"`
+	LastError        string          `json:"last_error,omitempty"`
+	LastErrorAt      *time.Time      `json:"last_error_at,omitempty"`
+	LastConnectAt    *time.Time      `json:"last_connect_at,omitempty"`
+	SinceLastConnect time.Duration   `json:"since_last_connect_ns,omitempty"`
+	Consumers        []ChannelHealth `json:"consumers"`
+	Producers        []ChannelHealth `json:"producers"`
+}
+
+// Live reports whether the liveness check passes.
+func (h HealthStatus) Live() bool {
+	return h.Running
+}
+
+// Ready reports whether the readiness check passes.
+func (h HealthStatus) Ready() bool {
+	if !h.Connected || h.Blocked {
+		return false
+	}
+
+	for _, c := range h.Consumers {
+		if !c.Open {
+			return false
+		}
+	}
+	for _, p := range h.Producers {
+		if !p.Open {
+			return false
+		}
+	}
+
+	return true
+}
+
+// healthState holds the state of MyAMQP reported by Health.
+// Consumers and producers are registered with the number of the connection they were created on.
+type healthState struct {
+	running       bool
+	lastErr       error
+	lastErrAt     time.Time
+	lastConnectAt time.Time
+	connection    int
+	consumers     map[*Consumer]int
+	producers     map[*Producer]int
+	mu            sync.Mutex
+}
+
+func (h *healthState) setRunning(running bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = running
+}
+
+func (h *healthState) setLastError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastErr = err
+	h.lastErrAt = time.Now()
+}
+
+// connected starts a new connection. Consumers and producers of the previous connections stay registered,
+// so one which is not created again fails the readiness check.
+func (h *healthState) connected() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastConnectAt = time.Now()
+	h.connection++
+}
+
+// addConsumer registers the consumer, replacing the consumers of the previous connections with the same name,
+// as consumers are usually created again in the OnConnect callback.
+func (h *healthState) addConsumer(c *Consumer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.consumers == nil {
+		h.consumers = make(map[*Consumer]int)
+	}
+	for other, connection := range h.consumers {
+		if connection < h.connection && other.healthName() == c.healthName() {
+			delete(h.consumers, other)
+		}
+	}
+	h.consumers[c] = h.connection
+}
+
+func (h *healthState) removeConsumer(c *Consumer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.consumers, c)
+}
+
+// addProducer registers the producer, replacing the producers of the previous connections with the same exchange.
+func (h *healthState) addProducer(p *Producer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.producers == nil {
+		h.producers = make(map[*Producer]int)
+	}
+	for other, connection := range h.producers {
+		if connection < h.connection && other.healthName() == p.healthName() {
+			delete(h.producers, other)
+		}
+	}
+	h.producers[p] = h.connection
+}
+
+func (h *healthState) removeProducer(p *Producer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.producers, p)
+}
+
+func (c *Consumer) healthName() string {
+	return c.options.queueOpts.name + "/" + c.options.name
+}
+
+func (p *Producer) healthName() string {
+	return p.options.exchangeOpts.name
+}
+
+// Health returns the HealthStatus of the MyAMQP.
+func (s *MyAMQP) Health(ctx context.Context) (HealthStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return HealthStatus{}, err
+	}
+
+	conn := s.connection()
+	blocked, blockedReason := s.flow.state()
+
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+
+	status := HealthStatus{
+		Running:       s.health.running,
+		Connected:     conn != nil && !conn.IsClosed(),
+		Blocked:       blocked,
+		BlockedReason: blockedReason,
+		Consumers:     make([]ChannelHealth, 0, len(s.health.consumers)),
+		Producers:     make([]ChannelHealth, 0, len(s.health.producers)),
+	}
+	if s.health.lastErr != nil {
+		lastErrAt := s.health.lastErrAt
+		status.LastError = s.health.lastErr.Error()
+		status.LastErrorAt = &lastErrAt
+	}
+	if !s.health.lastConnectAt.IsZero() {
+		lastConnectAt := s.health.lastConnectAt
+		status.LastConnectAt = &lastConnectAt
+		status.SinceLastConnect = time.Since(s.health.lastConnectAt)
+	}
+
+	for c := range s.health.consumers {
+		status.Consumers = append(status.Consumers, ChannelHealth{
+			Name: c.healthName(),
+			Open: !c.channel.IsClosed(),
+		})
+	}
+	for p := range s.health.producers {
+		status.Producers = append(status.Producers, ChannelHealth{
+			Name: p.healthName(),
+			Open: !p.channel.IsClosed(),
+		})
+	}
+
+	return status, nil
+}
+
+// HealthHandler returns an http.Handler responding with the HealthStatus as JSON.
+// The status code is 200 when the check passes and 503 otherwise.
+func (s *MyAMQP) HealthHandler(check HealthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := s.Health(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		pass := status.Live()
+		if check == HealthCheckReadiness {
+			pass = status.Ready()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if pass {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
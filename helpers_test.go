@@ -0,0 +1,128 @@
+package myamqp_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/dmasior/myamqp/fakebroker"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const waitTimeout = 2 * time.Second
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newBroker starts a fake broker closed at the end of the test.
+func newBroker(t *testing.T) *fakebroker.Broker {
+	t.Helper()
+
+	broker := fakebroker.New()
+	t.Cleanup(func() { broker.Close() })
+	return broker
+}
+
+// connect runs a MyAMQP connected to the broker until the end of the test.
+// The options are applied to the Config, e.g. to set the Qos or Metrics.
+func connect(t *testing.T, broker *fakebroker.Broker, options ...func(*myamqp.Config) *myamqp.Config) *myamqp.MyAMQP {
+	t.Helper()
+
+	connected := make(chan struct{}, 1)
+	config, err := myamqp.NewConfig(broker.Dial)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	config = config.
+		WithLogger(discardLogger).
+		WithOnConnect(func(*myamqp.MyAMQP) {
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		})
+	for _, option := range options {
+		config = option(config)
+	}
+
+	amqp, err := myamqp.New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		amqp.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	select {
+	case <-connected:
+	case <-time.After(waitTimeout):
+		t.Fatal("not connected")
+	}
+
+	return amqp
+}
+
+// consume starts a consumer forwarding its deliveries to the returned channel, cancelled at the end of the test.
+func consume(t *testing.T, amqp *myamqp.MyAMQP, options *myamqp.ConsumerOptions) <-chan amqp091.Delivery {
+	t.Helper()
+
+	received := make(chan amqp091.Delivery, 100)
+	consumer, err := amqp.Consumer(options, func(deliveries <-chan amqp091.Delivery, done chan error) {
+		for d := range deliveries {
+			received <- d
+		}
+		done <- nil
+	})
+	if err != nil {
+		t.Fatalf("Consumer: %v", err)
+	}
+	t.Cleanup(func() { consumer.Cancel() })
+
+	return received
+}
+
+// receive returns the next delivery, failing the test when none is received in time.
+func receive(t *testing.T, deliveries <-chan amqp091.Delivery) amqp091.Delivery {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(waitTimeout):
+		t.Fatal("no delivery")
+		return amqp091.Delivery{}
+	}
+}
+
+// waitFor polls the condition until it is true, failing the test when it is not in time.
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", desc)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// defaultExchange returns ExchangeOptions of the default exchange, which cannot be declared.
+func defaultExchange() *myamqp.ExchangeOptions {
+	return myamqp.NewExchangeOptions("", myamqp.ExchangeTypeDirect).WithDeclareMode(myamqp.DeclareModeSkip)
+}
+
+// waitAfter returns a channel receiving after the wait timeout.
+func waitAfter() <-chan time.Time {
+	return time.After(waitTimeout)
+}
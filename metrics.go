@@ -0,0 +1,181 @@
+package myamqp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Metrics is called by MyAMQP, Producer and Consumer to record their activity.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Connect is called after each connection attempt, err is nil on success.
+	Connect(err error)
+	// Reconnect is called before each reconnect attempt.
+	Reconnect(attempt int)
+	// Publish is called after each publish, err is nil on success.
+	Publish(exchange, routingKey string, err error)
+	// Confirm is called when the AMQP server confirms a message published with PublishWithDeferredConfirm.
+	Confirm(exchange string, acked bool, latency time.Duration)
+	// Return is called when the AMQP server returns an unroutable mandatory message.
+	Return(exchange, routingKey string)
+	// Deliver is called when a consumer receives a delivery.
+	Deliver(queue string, autoAck bool)
+	// Ack is called when a delivery is acked.
+	Ack(queue string)
+	// Nack is called when a delivery is nacked.
+	Nack(queue string, requeue bool)
+	// Reject is called when a delivery is rejected.
+	Reject(queue string, requeue bool)
+	// HandlerDuration is called with the time from a delivery being received to being acked, nacked or rejected.
+	HandlerDuration(queue string, duration time.Duration)
+	// Unsettled is called with the number of deliveries of a consumer channel which were neither acked,
+	// nacked nor rejected when the channel closed. The AMQP server requeues them.
+	Unsettled(queue string, count int)
+}
+
+// NoopMetrics is a Metrics that records nothing. It is used when no Metrics is set on the Config.
+type NoopMetrics struct{}
+
+func (NoopMetrics) Connect(error)                         {}
+func (NoopMetrics) Reconnect(int)                         {}
+func (NoopMetrics) Publish(string, string, error)         {}
+func (NoopMetrics) Confirm(string, bool, time.Duration)   {}
+func (NoopMetrics) Return(string, string)                 {}
+func (NoopMetrics) Deliver(string, bool)                  {}
+func (NoopMetrics) Ack(string)                            {}
+func (NoopMetrics) Nack(string, bool)                     {}
+func (NoopMetrics) Reject(string, bool)                   {}
+func (NoopMetrics) HandlerDuration(string, time.Duration) {}
+func (NoopMetrics) Unsettled(string, int)                 {}
+
+// observeConfirm records the confirmation of a message published at the given time.
+func observeConfirm(metrics Metrics, exchange string, confirm *amqp091.DeferredConfirmation, published time.Time) {
+	<-confirm.Done()
+	metrics.Confirm(exchange, confirm.Acked(), time.Since(published))
+}
+
+// observeReturns records the returns of the producer channel.
+func observeReturns(metrics Metrics, returns <-chan amqp091.Return) {
+	for r := range returns {
+		metrics.Return(r.Exchange, r.RoutingKey)
+	}
+}
+
+// observeDeliveries forwards the deliveries with an Acknowledger recording their outcome.
+// The deliveries not settled when the channel closes are recorded as Unsettled.
+func observeDeliveries(metrics Metrics, queue string, channel Channel, deliveries <-chan amqp091.Delivery, autoAck bool) <-chan amqp091.Delivery {
+	tracker := &deliveryTracker{metrics: metrics, queue: queue, received: make(map[uint64]time.Time)}
+	if !autoAck {
+		closes := channel.NotifyClose(make(chan *amqp091.Error, 1))
+		go func() {
+			<-closes
+			tracker.close()
+		}()
+	}
+
+	observed := make(chan amqp091.Delivery)
+	go func() {
+		defer close(observed)
+		for d := range deliveries {
+			if autoAck {
+				metrics.Deliver(queue, true)
+			} else {
+				tracker.deliver(d.DeliveryTag)
+				d.Acknowledger = &metricsAcknowledger{
+					Acknowledger: d.Acknowledger,
+					tracker:      tracker,
+				}
+			}
+			observed <- d
+		}
+	}()
+
+	return observed
+}
+
+// deliveryTracker keeps the receive time of the unsettled deliveries of a channel by delivery tag,
+// so a multiple ack, nack or reject records every delivery it settles.
+type deliveryTracker struct {
+	metrics Metrics
+	queue   string
+
+	mu       sync.Mutex
+	received map[uint64]time.Time
+	closed   bool
+}
+
+// deliver records the delivery, as unsettled already when the channel closed meanwhile.
+func (t *deliveryTracker) deliver(tag uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.metrics.Deliver(t.queue, false)
+	if t.closed {
+		t.metrics.Unsettled(t.queue, 1)
+		return
+	}
+	t.received[tag] = time.Now()
+}
+
+// settle forgets the deliveries settled by the tag and returns their receive times.
+func (t *deliveryTracker) settle(tag uint64, multiple bool) []time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var settled []time.Time
+	for delivered, received := range t.received {
+		if delivered == tag || (multiple && delivered < tag) {
+			settled = append(settled, received)
+			delete(t.received, delivered)
+		}
+	}
+
+	return settled
+}
+
+// close records the deliveries not settled when the channel closed, the AMQP server requeues them.
+func (t *deliveryTracker) close() {
+	t.mu.Lock()
+	n := len(t.received)
+	t.received = make(map[uint64]time.Time)
+	t.closed = true
+	t.mu.Unlock()
+
+	if n > 0 {
+		t.metrics.Unsettled(t.queue, n)
+	}
+}
+
+type metricsAcknowledger struct {
+	amqp091.Acknowledger
+	tracker *deliveryTracker
+}
+
+func (a *metricsAcknowledger) Ack(tag uint64, multiple bool) error {
+	err := a.Acknowledger.Ack(tag, multiple)
+	for _, received := range a.tracker.settle(tag, multiple) {
+		a.tracker.metrics.HandlerDuration(a.tracker.queue, time.Since(received))
+		a.tracker.metrics.Ack(a.tracker.queue)
+	}
+	return err
+}
+
+func (a *metricsAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	err := a.Acknowledger.Nack(tag, multiple, requeue)
+	for _, received := range a.tracker.settle(tag, multiple) {
+		a.tracker.metrics.HandlerDuration(a.tracker.queue, time.Since(received))
+		a.tracker.metrics.Nack(a.tracker.queue, requeue)
+	}
+	return err
+}
+
+func (a *metricsAcknowledger) Reject(tag uint64, requeue bool) error {
+	err := a.Acknowledger.Reject(tag, requeue)
+	for _, received := range a.tracker.settle(tag, false) {
+		a.tracker.metrics.HandlerDuration(a.tracker.queue, time.Since(received))
+		a.tracker.metrics.Reject(a.tracker.queue, requeue)
+	}
+	return err
+}
@@ -3,6 +3,8 @@ package myamqp
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -11,15 +13,18 @@ import (
 
 var (
 	ErrNotConnected = errors.New("not connected")
+	ErrSetupFailed  = errors.New("setup failed")
 )
 
 // MyAMQP represents a connection to an AMQP server.
 type MyAMQP struct {
 	config  *Config
-	conn    *amqp091.Connection
+	conn    Connection
 	connMu  sync.Mutex
 	rCtx    context.Context
 	rCancel context.CancelFunc
+	health  healthState
+	flow    *flowControl
 }
 
 // DialFunc is a function that returns a new AMQP connection.
@@ -32,8 +37,15 @@ func New(config *Config) (*MyAMQP, error) {
 		return nil, errors.New("config cannot be nil")
 	}
 
+	if config.tls != nil {
+		if err := config.tls.validate(config.url); err != nil {
+			return nil, err
+		}
+	}
+
 	return &MyAMQP{
 		config: config,
+		flow:   newFlowControl(),
 	}, nil
 }
 
@@ -45,90 +57,157 @@ func (s *MyAMQP) Run(ctx context.Context) error {
 	default:
 	}
 
-	errListener := s.config.ReconnectPolicy().ErrListener()
-	if errListener == nil {
-		errListener = func(err error) {}
+	reconnectPolicy := s.config.ReconnectPolicy()
+	listener := reconnectPolicy.ErrListener()
+	if listener == nil {
+		listener = func(err error) {}
+	}
+	errListener := func(err error) {
+		s.health.setLastError(err)
+		listener(err)
 	}
 
-	s.rCtx, s.rCancel = context.WithCancel(ctx)
+	s.health.setRunning(true)
+	defer s.health.setRunning(false)
 
-	reconnErrCh := make(chan error, 1)
-	_, errCh, err := s.connect()
-	if err != nil {
-		errListener(err)
-		reconnErrCh <- err
-	}
+	s.connMu.Lock()
+	s.rCtx, s.rCancel = context.WithCancel(ctx)
+	rCtx := s.rCtx
+	s.connMu.Unlock()
 
 	for {
-		select {
-		case <-s.rCtx.Done():
-			if s.conn != nil && !s.conn.IsClosed() {
-				cErr := s.conn.Close()
-				if cErr != nil {
-					errListener(cErr)
-				}
+		errCh, err := s.connect()
+		if err != nil {
+			errListener(err)
+		} else {
+			if reconnectPolicy.ResetOnConnect() {
+				reconnectPolicy.Reset()
 			}
-			errListener(s.rCtx.Err())
-			return s.rCtx.Err()
-		case <-reconnErrCh:
-			reconnectPolicy := s.config.ReconnectPolicy()
-			if reconnectPolicy.Max() != MaxReconnectUnlimited && reconnectPolicy.Count() >= reconnectPolicy.Max() {
-				errListener(ErrMaxReconnectsReached)
-				return ErrMaxReconnectsReached
+
+			select {
+			case <-rCtx.Done():
+				return s.shutdown(rCtx, errListener)
+			case rcErr, ok := <-errCh:
+				// The error channel is closed without an error on a graceful close, e.g. by Close.
+				if !ok && rCtx.Err() != nil {
+					return s.shutdown(rCtx, errListener)
+				}
+				s.logger().Warn("connection closed", "error", rcErr)
+				if rcErr != nil {
+					s.health.setLastError(rcErr)
+				}
 			}
+		}
+
+		if reconnectPolicy.Max() != MaxReconnectUnlimited && reconnectPolicy.Count() >= reconnectPolicy.Max() {
+			s.logger().Error("max reconnects reached", "max", reconnectPolicy.Max())
+			errListener(ErrMaxReconnectsReached)
+			return ErrMaxReconnectsReached
+		}
 
-			// Setup new error channel and reconnect.
-			reconnectPolicy.Inc()
-			<-time.After(reconnectPolicy.Backoff())
+		reconnectPolicy.Inc()
+		s.config.Metrics().Reconnect(reconnectPolicy.Count())
+		s.logger().Info("waiting before reconnect", "attempt", reconnectPolicy.Count(), "backoff", reconnectPolicy.Backoff())
 
-			var err error
-			_, errCh, err = s.connect()
-			if err != nil {
-				errListener(err)
-				reconnErrCh <- err
-			}
-		case rcErr := <-errCh:
-			reconnErrCh <- rcErr
-		default:
-			<-time.After(time.Millisecond * 500)
+		backoff := time.NewTimer(reconnectPolicy.Backoff())
+		select {
+		case <-rCtx.Done():
+			backoff.Stop()
+			return s.shutdown(rCtx, errListener)
+		case <-backoff.C:
 		}
 	}
 }
 
-func (s *MyAMQP) connect() (*amqp091.Connection, chan *amqp091.Error, error) {
-	s.connMu.Lock()
-	defer s.connMu.Unlock()
-	errCh := make(chan *amqp091.Error)
+// shutdown closes the connection when the Run context is done.
+func (s *MyAMQP) shutdown(rCtx context.Context, errListener func(error)) error {
+	s.logger().Info("closing connection", "reason", rCtx.Err())
+	if conn := s.connection(); conn != nil && !conn.IsClosed() {
+		if err := conn.Close(); err != nil {
+			errListener(err)
+		}
+	}
+	errListener(rCtx.Err())
+	return rCtx.Err()
+}
 
-	// Run to the AMQP server.
-	conn, err := s.config.DialFunc()()
+// connect dials the AMQP server and runs the OnConnect and Setup callbacks.
+// It returns the channel receiving the error closing the connection.
+func (s *MyAMQP) connect() (chan *amqp091.Error, error) {
+	s.config.Logger().Debug("connecting")
+	conn, err := s.config.Dialer()()
+	s.config.Metrics().Connect(err)
 	if err != nil {
-		return nil, errCh, err
+		s.config.Logger().Error("connect failed", "error", err)
+		return nil, err
 	}
+
+	// Listen for the close before running the callbacks, so a close during them is not missed.
+	// The channel is buffered, so the connection can shut down while Run is not receiving.
+	errCh := conn.NotifyClose(make(chan *amqp091.Error, 1))
+
+	s.connMu.Lock()
 	s.conn = conn
+	s.connMu.Unlock()
+
+	s.logger().Info("connected")
+	s.health.connected()
+	s.flow.set(false, "")
+	s.watchBlocked(conn)
 
 	if s.config.OnConnect() != nil {
 		s.config.OnConnect()(s)
 	}
 
-	s.conn.NotifyClose(errCh)
+	if s.config.Setup() != nil {
+		if err = s.config.Setup()(s); err != nil {
+			s.logger().Error("setup failed", "error", err)
+			conn.Close()
+			return nil, fmt.Errorf("%w: %w", ErrSetupFailed, err)
+		}
+	}
 
-	return s.conn, errCh, nil
+	return errCh, nil
+}
+
+// connection returns the current Connection, nil before the first connect.
+func (s *MyAMQP) connection() Connection {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.conn
 }
 
 // Close closes the connection to the AMQP server and cancels the reconnect goroutine.
 func (s *MyAMQP) Close() error {
-	// Cancel the context, stopping the setupReconnect goroutine
+	s.connMu.Lock()
+	// Cancel the context, stopping the Run loop
 	if s.rCancel != nil {
 		s.rCancel()
 	}
 
-	// Close the connection and nil it
-	var err error
-	if s.conn != nil && !s.conn.IsClosed() {
-		err = s.conn.Close()
-		s.conn = nil
+	// Nil the connection and close it
+	conn := s.conn
+	s.conn = nil
+	s.connMu.Unlock()
+
+	if conn != nil && !conn.IsClosed() {
+		return conn.Close()
+	}
+
+	return nil
+}
+
+// logger returns the Logger of the Config with the connection name and vhost of the current connection.
+func (s *MyAMQP) logger() *slog.Logger {
+	logger := s.config.Logger()
+	conn := s.connection()
+	if conn == nil {
+		return logger
+	}
+
+	if name, ok := conn.Config().Properties["connection_name"].(string); ok {
+		logger = logger.With("connection_name", name)
 	}
 
-	return err
+	return logger.With("vhost", conn.Config().Vhost)
 }
@@ -0,0 +1,280 @@
+package myamqp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/dmasior/myamqp/fakebroker"
+	"github.com/dmasior/myamqp/faultinject"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const testTimeout = 10 * time.Second
+
+var errSetup = errors.New("setup error")
+
+// reconnectMetrics signals each reconnect attempt.
+type reconnectMetrics struct {
+	myamqp.NoopMetrics
+	reconnects chan int
+}
+
+func (m reconnectMetrics) Reconnect(attempt int) {
+	select {
+	case m.reconnects <- attempt:
+	default:
+	}
+}
+
+// runEnv is a MyAMQP connected to a fake broker through a fault injection proxy.
+type runEnv struct {
+	proxy      *faultinject.Proxy
+	amqp       *myamqp.MyAMQP
+	connected  chan struct{}
+	reconnects chan int
+	mu         sync.Mutex
+	errs       []error
+}
+
+func newRunEnv(t *testing.T, policy *myamqp.ReconnectPolicy, setupFails int) *runEnv {
+	t.Helper()
+
+	broker := fakebroker.New().WithHeartbeat(time.Second)
+	t.Cleanup(func() { broker.Close() })
+	addr, err := broker.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	proxy, err := faultinject.NewProxy(addr.String())
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+
+	env := &runEnv{
+		proxy:      proxy,
+		connected:  make(chan struct{}, 10),
+		reconnects: make(chan int, 10),
+	}
+
+	setups := 0
+	config, err := myamqp.NewConfig(func() (*amqp091.Connection, error) {
+		return amqp091.Dial("amqp://guest:guest@" + proxy.Addr() + "/")
+	})
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	config = config.
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))).
+		WithMetrics(reconnectMetrics{reconnects: env.reconnects}).
+		WithReconnectPolicy(policy.WithErrorListener(func(err error) {
+			env.mu.Lock()
+			defer env.mu.Unlock()
+			env.errs = append(env.errs, err)
+		})).
+		WithSetup(func(*myamqp.MyAMQP) error {
+			setups++
+			if setups <= setupFails {
+				return errSetup
+			}
+			env.connected <- struct{}{}
+			return nil
+		})
+
+	env.amqp, err = myamqp.New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return env
+}
+
+// listenerErr reports whether the error listener of the ReconnectPolicy received the error.
+func (e *runEnv) listenerErr(target error) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitReconnect waits for the next reconnect attempt.
+func (e *runEnv) waitReconnect(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-e.reconnects:
+	case <-time.After(testTimeout):
+		t.Fatal("no reconnect")
+	}
+}
+
+func TestRun(t *testing.T) {
+	reset := func(t *testing.T, env *runEnv) { env.proxy.Reset() }
+
+	tests := []struct {
+		name         string
+		policy       func() *myamqp.ReconnectPolicy
+		setupFails   int
+		faults       []func(t *testing.T, env *runEnv)
+		wantConnects int
+		wantErr      error
+		wantListener error
+	}{
+		{
+			name:         "reset connection reconnects",
+			policy:       func() *myamqp.ReconnectPolicy { return myamqp.NewReconnectPolicy(myamqp.MaxReconnectUnlimited, 0) },
+			faults:       []func(t *testing.T, env *runEnv){reset},
+			wantConnects: 2,
+			wantErr:      context.Canceled,
+		},
+		{
+			name:   "dropped connection reconnects",
+			policy: func() *myamqp.ReconnectPolicy { return myamqp.NewReconnectPolicy(myamqp.MaxReconnectUnlimited, 0) },
+			faults: []func(t *testing.T, env *runEnv){
+				func(t *testing.T, env *runEnv) { env.proxy.Drop() },
+			},
+			wantConnects: 2,
+			wantErr:      context.Canceled,
+		},
+		{
+			name:   "blackholed connection is detected by heartbeats",
+			policy: func() *myamqp.ReconnectPolicy { return myamqp.NewReconnectPolicy(myamqp.MaxReconnectUnlimited, 0) },
+			faults: []func(t *testing.T, env *runEnv){
+				func(t *testing.T, env *runEnv) {
+					env.proxy.Blackhole()
+					env.waitReconnect(t)
+					env.proxy.Heal()
+				},
+			},
+			wantConnects: 2,
+			wantErr:      context.Canceled,
+		},
+		{
+			name:   "rejected reconnects reach the max",
+			policy: func() *myamqp.ReconnectPolicy { return myamqp.NewReconnectPolicy(2, 0) },
+			faults: []func(t *testing.T, env *runEnv){
+				func(t *testing.T, env *runEnv) {
+					env.proxy.Reject()
+					env.proxy.Reset()
+				},
+			},
+			wantConnects: 1,
+			wantErr:      myamqp.ErrMaxReconnectsReached,
+			wantListener: myamqp.ErrMaxReconnectsReached,
+		},
+		{
+			name:         "max applies to the total reconnects",
+			policy:       func() *myamqp.ReconnectPolicy { return myamqp.NewReconnectPolicy(1, 0) },
+			faults:       []func(t *testing.T, env *runEnv){reset, reset},
+			wantConnects: 2,
+			wantErr:      myamqp.ErrMaxReconnectsReached,
+		},
+		{
+			name: "reset on connect applies the max to consecutive reconnects",
+			policy: func() *myamqp.ReconnectPolicy {
+				return myamqp.NewReconnectPolicy(1, 0).WithResetOnConnect(true)
+			},
+			faults:       []func(t *testing.T, env *runEnv){reset, reset},
+			wantConnects: 3,
+			wantErr:      context.Canceled,
+		},
+		{
+			name:         "failed setup reconnects",
+			policy:       func() *myamqp.ReconnectPolicy { return myamqp.NewReconnectPolicy(myamqp.MaxReconnectUnlimited, 0) },
+			setupFails:   1,
+			wantConnects: 1,
+			wantErr:      context.Canceled,
+			wantListener: myamqp.ErrSetupFailed,
+		},
+		{
+			name:         "failed setup reaches the max",
+			policy:       func() *myamqp.ReconnectPolicy { return myamqp.NewReconnectPolicy(0, 0) },
+			setupFails:   1,
+			wantConnects: 0,
+			wantErr:      myamqp.ErrMaxReconnectsReached,
+			wantListener: errSetup,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newRunEnv(t, tt.policy(), tt.setupFails)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			runErr := make(chan error, 1)
+			go func() { runErr <- env.amqp.Run(ctx) }()
+
+			connects := 0
+			var err error
+		loop:
+			for {
+				select {
+				case <-env.connected:
+					if connects < len(tt.faults) {
+						tt.faults[connects](t, env)
+					} else {
+						cancel()
+					}
+					connects++
+				case err = <-runErr:
+					break loop
+				case <-time.After(testTimeout):
+					t.Fatalf("Run did not return, %d connects", connects)
+				}
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run: got error %v, want %v", err, tt.wantErr)
+			}
+			if connects != tt.wantConnects {
+				t.Errorf("got %d connects, want %d", connects, tt.wantConnects)
+			}
+			if tt.wantListener != nil && !env.listenerErr(tt.wantListener) {
+				t.Errorf("error listener did not receive %v", tt.wantListener)
+			}
+		})
+	}
+}
+
+func TestRunContextCanceledDuringBackoff(t *testing.T) {
+	env := newRunEnv(t, myamqp.NewReconnectPolicy(myamqp.MaxReconnectUnlimited, time.Hour), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() { runErr <- env.amqp.Run(ctx) }()
+
+	select {
+	case <-env.connected:
+	case <-time.After(testTimeout):
+		t.Fatal("not connected")
+	}
+
+	env.proxy.Reset()
+	env.waitReconnect(t)
+	cancel()
+
+	select {
+	case err := <-runErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run: got error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Run did not return during the backoff")
+	}
+	if !env.listenerErr(context.Canceled) {
+		t.Error("error listener did not receive the context error")
+	}
+}
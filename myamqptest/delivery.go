@@ -0,0 +1,266 @@
+package myamqptest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrUnknownDeliveryTag = errors.New("unknown delivery tag")
+)
+
+// Outcome represents how a delivery was settled.
+type Outcome int
+
+const (
+	// Unacknowledged means the delivery was neither acked, nacked nor rejected.
+	Unacknowledged Outcome = iota
+	Acked
+	Nacked
+	Rejected
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Acked:
+		return "acked"
+	case Nacked:
+		return "nacked"
+	case Rejected:
+		return "rejected"
+	default:
+		return "unacknowledged"
+	}
+}
+
+// Settlement represents how and when a delivery was settled.
+type Settlement struct {
+	Outcome  Outcome
+	Requeue  bool
+	Multiple bool
+	At       time.Time
+}
+
+// Acknowledger is an amqp091.Acknowledger recording how deliveries are settled.
+// Settling a delivery twice, or an unknown delivery, records an ErrUnknownDeliveryTag
+// and returns it, as the AMQP server closes the channel in that case.
+// It is safe for concurrent use.
+type Acknowledger struct {
+	tag         uint64
+	pending     map[uint64]struct{}
+	settlements map[uint64]Settlement
+	errs        []error
+	mu          sync.Mutex
+}
+
+var _ amqp091.Acknowledger = (*Acknowledger)(nil)
+
+// NewAcknowledger creates a new Acknowledger.
+func NewAcknowledger() *Acknowledger {
+	return &Acknowledger{
+		pending:     make(map[uint64]struct{}),
+		settlements: make(map[uint64]Settlement),
+	}
+}
+
+// nextTag registers a new pending delivery and returns its tag.
+func (a *Acknowledger) nextTag() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tag++
+	a.pending[a.tag] = struct{}{}
+	return a.tag
+}
+
+// Ack records the deliveries as acked.
+func (a *Acknowledger) Ack(tag uint64, multiple bool) error {
+	return a.settle(tag, Settlement{Outcome: Acked, Multiple: multiple})
+}
+
+// Nack records the deliveries as nacked.
+func (a *Acknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	return a.settle(tag, Settlement{Outcome: Nacked, Multiple: multiple, Requeue: requeue})
+}
+
+// Reject records the delivery as rejected.
+func (a *Acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.settle(tag, Settlement{Outcome: Rejected, Requeue: requeue})
+}
+
+func (a *Acknowledger) settle(tag uint64, s Settlement) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s.At = time.Now()
+
+	var tags []uint64
+	if s.Multiple {
+		for t := range a.pending {
+			if t <= tag {
+				tags = append(tags, t)
+			}
+		}
+	} else if _, ok := a.pending[tag]; ok {
+		tags = append(tags, tag)
+	}
+
+	if len(tags) == 0 {
+		err := fmt.Errorf("%w %d: %s", ErrUnknownDeliveryTag, tag, s.Outcome)
+		a.errs = append(a.errs, err)
+		return err
+	}
+
+	for _, t := range tags {
+		delete(a.pending, t)
+		a.settlements[t] = s
+	}
+
+	return nil
+}
+
+// Settlement returns how the delivery with the given tag was settled.
+func (a *Acknowledger) Settlement(tag uint64) Settlement {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.settlements[tag]
+}
+
+// Outcome returns the Outcome of the delivery with the given tag.
+func (a *Acknowledger) Outcome(tag uint64) Outcome {
+	return a.Settlement(tag).Outcome
+}
+
+// Pending returns the tags of the deliveries not settled yet, in ascending order.
+func (a *Acknowledger) Pending() []uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tags := make([]uint64, 0, len(a.pending))
+	for t := range a.pending {
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	return tags
+}
+
+// Errors returns the errors of settling unknown or already settled deliveries.
+func (a *Acknowledger) Errors() []error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]error(nil), a.errs...)
+}
+
+// DeliveryBuilder builds amqp091.Delivery values for handler tests.
+type DeliveryBuilder struct {
+	delivery amqp091.Delivery
+}
+
+// NewDelivery creates a new DeliveryBuilder with the given body.
+func NewDelivery(body []byte) *DeliveryBuilder {
+	return &DeliveryBuilder{
+		delivery: amqp091.Delivery{Body: body},
+	}
+}
+
+// WithPublishing sets the properties, headers and body of the Publishing on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithPublishing(p amqp091.Publishing) *DeliveryBuilder {
+	b.delivery.Headers = p.Headers
+	b.delivery.ContentType = p.ContentType
+	b.delivery.ContentEncoding = p.ContentEncoding
+	b.delivery.DeliveryMode = p.DeliveryMode
+	b.delivery.Priority = p.Priority
+	b.delivery.CorrelationId = p.CorrelationId
+	b.delivery.ReplyTo = p.ReplyTo
+	b.delivery.Expiration = p.Expiration
+	b.delivery.MessageId = p.MessageId
+	b.delivery.Timestamp = p.Timestamp
+	b.delivery.Type = p.Type
+	b.delivery.UserId = p.UserId
+	b.delivery.AppId = p.AppId
+	b.delivery.Body = p.Body
+	return b
+}
+
+// WithExchange sets the exchange on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithExchange(exchange string) *DeliveryBuilder {
+	b.delivery.Exchange = exchange
+	return b
+}
+
+// WithRoutingKey sets the routing key on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithRoutingKey(routingKey string) *DeliveryBuilder {
+	b.delivery.RoutingKey = routingKey
+	return b
+}
+
+// WithConsumerTag sets the consumer tag on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithConsumerTag(consumerTag string) *DeliveryBuilder {
+	b.delivery.ConsumerTag = consumerTag
+	return b
+}
+
+// WithRedelivered sets the redelivered flag on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithRedelivered(redelivered bool) *DeliveryBuilder {
+	b.delivery.Redelivered = redelivered
+	return b
+}
+
+// WithHeader sets a header on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithHeader(key string, value interface{}) *DeliveryBuilder {
+	if b.delivery.Headers == nil {
+		b.delivery.Headers = amqp091.Table{}
+	}
+	b.delivery.Headers[key] = value
+	return b
+}
+
+// WithContentType sets the content type on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithContentType(contentType string) *DeliveryBuilder {
+	b.delivery.ContentType = contentType
+	return b
+}
+
+// WithType sets the type on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithType(typ string) *DeliveryBuilder {
+	b.delivery.Type = typ
+	return b
+}
+
+// WithMessageID sets the message id on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithMessageID(messageID string) *DeliveryBuilder {
+	b.delivery.MessageId = messageID
+	return b
+}
+
+// WithCorrelationID sets the correlation id on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithCorrelationID(correlationID string) *DeliveryBuilder {
+	b.delivery.CorrelationId = correlationID
+	return b
+}
+
+// WithReplyTo sets the reply to on the DeliveryBuilder.
+func (b *DeliveryBuilder) WithReplyTo(replyTo string) *DeliveryBuilder {
+	b.delivery.ReplyTo = replyTo
+	return b
+}
+
+// Build returns the Delivery, settled through the given Acknowledger under a new delivery tag.
+func (b *DeliveryBuilder) Build(ack *Acknowledger) amqp091.Delivery {
+	d := b.delivery
+	if d.Headers != nil {
+		d.Headers = make(amqp091.Table, len(b.delivery.Headers))
+		for k, v := range b.delivery.Headers {
+			d.Headers[k] = v
+		}
+	}
+	d.Acknowledger = ack
+	d.DeliveryTag = ack.nextTag()
+	return d
+}
@@ -0,0 +1,123 @@
+package myamqptest
+
+import (
+	"context"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultHandlerTimeout is the timeout of RunHandler when none is given.
+const DefaultHandlerTimeout = time.Second
+
+// HandlerResult represents the result of running a HandleFunc with RunHandler.
+type HandlerResult struct {
+	// Deliveries are the deliveries fed to the handler, in order.
+	Deliveries   []amqp091.Delivery
+	Acknowledger *Acknowledger
+	// Done is true when the handler signaled done, DoneErr is the error it signaled.
+	Done    bool
+	DoneErr error
+	// TimedOut is true when the handler did not receive all deliveries or signal done within the timeout,
+	// or before the context of RunHandlerContext was done.
+	TimedOut bool
+}
+
+// RunHandler feeds the deliveries to the handler, closes the deliveries channel as a cancelled consumer does,
+// and waits for the handler to signal done. A zero timeout uses DefaultHandlerTimeout.
+func RunHandler(handler myamqp.HandleFunc, timeout time.Duration, deliveries ...*DeliveryBuilder) *HandlerResult {
+	if timeout == 0 {
+		timeout = DefaultHandlerTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return RunHandlerContext(ctx, handler, deliveries...)
+}
+
+// RunHandlerContext is like RunHandler, stopping when the context is done instead of after a timeout.
+// When stopped, the deliveries channel is closed, so the handler returns as for a cancelled consumer
+// and its goroutine does not leak. The done channel is buffered, the handler can signal done after the stop.
+func RunHandlerContext(ctx context.Context, handler myamqp.HandleFunc, deliveries ...*DeliveryBuilder) *HandlerResult {
+	result := &HandlerResult{
+		Acknowledger: NewAcknowledger(),
+	}
+	for _, b := range deliveries {
+		result.Deliveries = append(result.Deliveries, b.Build(result.Acknowledger))
+	}
+
+	ch := make(chan amqp091.Delivery)
+	done := make(chan error, 1)
+	go handler(ch, done)
+
+	for _, d := range result.Deliveries {
+		select {
+		case ch <- d:
+		case err := <-done:
+			close(ch)
+			result.Done, result.DoneErr = true, err
+			return result
+		case <-ctx.Done():
+			close(ch)
+			result.TimedOut = true
+			return result
+		}
+	}
+	close(ch)
+
+	select {
+	case err := <-done:
+		result.Done, result.DoneErr = true, err
+	case <-ctx.Done():
+		result.TimedOut = true
+	}
+
+	return result
+}
+
+// Outcome returns the Outcome of the i-th delivery.
+func (r *HandlerResult) Outcome(i int) Outcome {
+	return r.Acknowledger.Outcome(r.Deliveries[i].DeliveryTag)
+}
+
+// Settlement returns the Settlement of the i-th delivery.
+func (r *HandlerResult) Settlement(i int) Settlement {
+	return r.Acknowledger.Settlement(r.Deliveries[i].DeliveryTag)
+}
+
+// Acked returns the acked deliveries.
+func (r *HandlerResult) Acked() []amqp091.Delivery {
+	return r.filter(func(s Settlement) bool { return s.Outcome == Acked })
+}
+
+// Nacked returns the nacked deliveries.
+func (r *HandlerResult) Nacked() []amqp091.Delivery {
+	return r.filter(func(s Settlement) bool { return s.Outcome == Nacked })
+}
+
+// Rejected returns the rejected deliveries.
+func (r *HandlerResult) Rejected() []amqp091.Delivery {
+	return r.filter(func(s Settlement) bool { return s.Outcome == Rejected })
+}
+
+// Requeued returns the nacked or rejected deliveries with requeue.
+func (r *HandlerResult) Requeued() []amqp091.Delivery {
+	return r.filter(func(s Settlement) bool { return s.Outcome != Unacknowledged && s.Requeue })
+}
+
+// Unacknowledged returns the deliveries neither acked, nacked nor rejected.
+func (r *HandlerResult) Unacknowledged() []amqp091.Delivery {
+	return r.filter(func(s Settlement) bool { return s.Outcome == Unacknowledged })
+}
+
+func (r *HandlerResult) filter(match func(Settlement) bool) []amqp091.Delivery {
+	var deliveries []amqp091.Delivery
+	for _, d := range r.Deliveries {
+		if match(r.Acknowledger.Settlement(d.DeliveryTag)) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries
+}
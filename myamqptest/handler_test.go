@@ -0,0 +1,231 @@
+package myamqptest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp/myamqptest"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var errHandler = errors.New("handler error")
+
+// settleByBody acks, rejects or requeues the deliveries by their body, and signals done when the channel is closed.
+func settleByBody(deliveries <-chan amqp091.Delivery, done chan error) {
+	for d := range deliveries {
+		switch string(d.Body) {
+		case "ack":
+			d.Ack(false)
+		case "reject":
+			d.Reject(false)
+		case "requeue":
+			d.Nack(false, true)
+		}
+	}
+	done <- nil
+}
+
+func TestRunHandler(t *testing.T) {
+	result := myamqptest.RunHandler(settleByBody, time.Second,
+		myamqptest.NewDelivery([]byte("ack")),
+		myamqptest.NewDelivery([]byte("reject")),
+		myamqptest.NewDelivery([]byte("requeue")),
+		myamqptest.NewDelivery([]byte("ignore")),
+	)
+
+	if !result.Done || result.DoneErr != nil || result.TimedOut {
+		t.Fatalf("got done %v, error %v, timed out %v", result.Done, result.DoneErr, result.TimedOut)
+	}
+
+	tests := []struct {
+		name string
+		got  []amqp091.Delivery
+		want []string
+	}{
+		{name: "acked", got: result.Acked(), want: []string{"ack"}},
+		{name: "nacked", got: result.Nacked(), want: []string{"requeue"}},
+		{name: "rejected", got: result.Rejected(), want: []string{"reject"}},
+		{name: "requeued", got: result.Requeued(), want: []string{"requeue"}},
+		{name: "unacknowledged", got: result.Unacknowledged(), want: []string{"ignore"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.got) != len(tt.want) {
+				t.Fatalf("got %d deliveries, want %v", len(tt.got), tt.want)
+			}
+			for i, d := range tt.got {
+				if string(d.Body) != tt.want[i] {
+					t.Errorf("delivery %d: got body %q, want %q", i, d.Body, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if got := result.Outcome(0); got != myamqptest.Acked {
+		t.Errorf("outcome: got %s, want %s", got, myamqptest.Acked)
+	}
+	if s := result.Settlement(2); s.Outcome != myamqptest.Nacked || !s.Requeue || s.At.IsZero() {
+		t.Errorf("settlement: got %+v", s)
+	}
+}
+
+func TestRunHandlerDoneBeforeAllDeliveries(t *testing.T) {
+	result := myamqptest.RunHandler(func(deliveries <-chan amqp091.Delivery, done chan error) {
+		d := <-deliveries
+		d.Ack(false)
+		done <- errHandler
+	}, time.Second,
+		myamqptest.NewDelivery([]byte("first")),
+		myamqptest.NewDelivery([]byte("second")),
+	)
+
+	if !result.Done || !errors.Is(result.DoneErr, errHandler) || result.TimedOut {
+		t.Fatalf("got done %v, error %v, timed out %v", result.Done, result.DoneErr, result.TimedOut)
+	}
+	if got := len(result.Unacknowledged()); got != 1 {
+		t.Errorf("got %d unacknowledged deliveries, want 1", got)
+	}
+}
+
+func TestRunHandlerStopsHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(exited chan struct{}) func(<-chan amqp091.Delivery, chan error)
+	}{
+		{
+			name: "slow handler",
+			handler: func(exited chan struct{}) func(<-chan amqp091.Delivery, chan error) {
+				return func(deliveries <-chan amqp091.Delivery, done chan error) {
+					defer close(exited)
+					for d := range deliveries {
+						time.Sleep(20 * time.Millisecond)
+						d.Ack(false)
+					}
+					done <- nil
+				}
+			},
+		},
+		{
+			name: "handler signaling done late",
+			handler: func(exited chan struct{}) func(<-chan amqp091.Delivery, chan error) {
+				return func(deliveries <-chan amqp091.Delivery, done chan error) {
+					defer close(exited)
+					for range deliveries {
+					}
+					time.Sleep(50 * time.Millisecond)
+					done <- nil
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exited := make(chan struct{})
+			result := myamqptest.RunHandler(tt.handler(exited), 10*time.Millisecond,
+				myamqptest.NewDelivery([]byte("1")),
+				myamqptest.NewDelivery([]byte("2")),
+				myamqptest.NewDelivery([]byte("3")),
+			)
+
+			if !result.TimedOut || result.Done {
+				t.Fatalf("got timed out %v, done %v", result.TimedOut, result.Done)
+			}
+
+			select {
+			case <-exited:
+			case <-time.After(time.Second):
+				t.Fatal("handler goroutine did not return after the timeout")
+			}
+		})
+	}
+}
+
+func TestRunHandlerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exited := make(chan struct{})
+
+	// The handler never signals done, it is stopped by the cancel after the first delivery.
+	result := myamqptest.RunHandlerContext(ctx, func(deliveries <-chan amqp091.Delivery, done chan error) {
+		defer close(exited)
+		<-deliveries
+		cancel()
+		for range deliveries {
+		}
+	}, myamqptest.NewDelivery([]byte("1")), myamqptest.NewDelivery([]byte("2")))
+
+	if !result.TimedOut || result.Done {
+		t.Fatalf("got timed out %v, done %v", result.TimedOut, result.Done)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("handler goroutine did not return after the cancel")
+	}
+}
+
+func TestAcknowledger(t *testing.T) {
+	ack := myamqptest.NewAcknowledger()
+	var deliveries []amqp091.Delivery
+	for i := 0; i < 4; i++ {
+		deliveries = append(deliveries, myamqptest.NewDelivery(nil).Build(ack))
+	}
+
+	if err := deliveries[1].Ack(false); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := deliveries[2].Nack(true, false); err != nil {
+		t.Fatalf("Nack multiple: %v", err)
+	}
+
+	want := []myamqptest.Outcome{myamqptest.Nacked, myamqptest.Acked, myamqptest.Nacked, myamqptest.Unacknowledged}
+	for i, d := range deliveries {
+		if got := ack.Outcome(d.DeliveryTag); got != want[i] {
+			t.Errorf("delivery %d: got %s, want %s", i, got, want[i])
+		}
+	}
+	if s := ack.Settlement(deliveries[0].DeliveryTag); !s.Multiple {
+		t.Errorf("settlement of a multiple nack: got %+v", s)
+	}
+	if pending := ack.Pending(); len(pending) != 1 || pending[0] != deliveries[3].DeliveryTag {
+		t.Errorf("pending: got %v, want [%d]", pending, deliveries[3].DeliveryTag)
+	}
+
+	// Settling twice fails as the server closes the channel.
+	if err := deliveries[1].Reject(true); !errors.Is(err, myamqptest.ErrUnknownDeliveryTag) {
+		t.Errorf("second settle: got error %v, want %v", err, myamqptest.ErrUnknownDeliveryTag)
+	}
+	if errs := ack.Errors(); len(errs) != 1 {
+		t.Errorf("got errors %v, want 1", errs)
+	}
+}
+
+func TestDeliveryBuilder(t *testing.T) {
+	ack := myamqptest.NewAcknowledger()
+	builder := myamqptest.NewDelivery([]byte("body")).
+		WithExchange("orders").
+		WithRoutingKey("order.created").
+		WithHeader("attempt", 1).
+		WithContentType("application/json").
+		WithType("OrderCreated").
+		WithMessageID("id").
+		WithRedelivered(true)
+
+	first := builder.Build(ack)
+	second := builder.WithHeader("attempt", 2).Build(ack)
+
+	if first.DeliveryTag == second.DeliveryTag {
+		t.Errorf("deliveries share the tag %d", first.DeliveryTag)
+	}
+	if first.Headers["attempt"] != 1 || second.Headers["attempt"] != 2 {
+		t.Errorf("headers are shared between deliveries: %v, %v", first.Headers, second.Headers)
+	}
+	if first.Exchange != "orders" || first.RoutingKey != "order.created" || first.ContentType != "application/json" ||
+		first.Type != "OrderCreated" || first.MessageId != "id" || !first.Redelivered || string(first.Body) != "body" {
+		t.Errorf("unexpected delivery %+v", first)
+	}
+}
@@ -0,0 +1,220 @@
+package myamqptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Matcher matches a Published message.
+type Matcher struct {
+	desc  string
+	match func(Published) bool
+}
+
+// MatchFunc creates a Matcher with the given description and match function.
+func MatchFunc(desc string, match func(Published) bool) Matcher {
+	return Matcher{desc: desc, match: match}
+}
+
+// Match reports whether the message matches.
+func (m Matcher) Match(p Published) bool {
+	return m.match(p)
+}
+
+func (m Matcher) String() string {
+	return m.desc
+}
+
+// All matches messages matching all matchers. It matches any message when there are none.
+func All(matchers ...Matcher) Matcher {
+	descs := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		descs = append(descs, m.desc)
+	}
+
+	return MatchFunc("{"+strings.Join(descs, ", ")+"}", func(p Published) bool {
+		for _, m := range matchers {
+			if !m.Match(p) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Not matches messages not matching the matcher.
+func Not(m Matcher) Matcher {
+	return MatchFunc("not "+m.desc, func(p Published) bool {
+		return !m.Match(p)
+	})
+}
+
+// Exchange matches messages published to the exchange.
+func Exchange(name string) Matcher {
+	return MatchFunc(fmt.Sprintf("exchange=%q", name), func(p Published) bool {
+		return p.Exchange == name
+	})
+}
+
+// RoutingKey matches messages published with the routing key.
+func RoutingKey(key string) Matcher {
+	return MatchFunc(fmt.Sprintf("routing_key=%q", key), func(p Published) bool {
+		return p.RoutingKey == key
+	})
+}
+
+// Mandatory matches messages published with the mandatory flag.
+func Mandatory(mandatory bool) Matcher {
+	return MatchFunc(fmt.Sprintf("mandatory=%t", mandatory), func(p Published) bool {
+		return p.Mandatory == mandatory
+	})
+}
+
+// Type matches messages with the type property, e.g. "OrderCreated".
+func Type(typ string) Matcher {
+	return MatchFunc(fmt.Sprintf("type=%q", typ), func(p Published) bool {
+		return p.Publishing.Type == typ
+	})
+}
+
+// ContentType matches messages with the content type property.
+func ContentType(contentType string) Matcher {
+	return MatchFunc(fmt.Sprintf("content_type=%q", contentType), func(p Published) bool {
+		return p.Publishing.ContentType == contentType
+	})
+}
+
+// CorrelationID matches messages with the correlation id property.
+func CorrelationID(correlationID string) Matcher {
+	return MatchFunc(fmt.Sprintf("correlation_id=%q", correlationID), func(p Published) bool {
+		return p.Publishing.CorrelationId == correlationID
+	})
+}
+
+// HasHeader matches messages with the header, whatever its value.
+func HasHeader(key string) Matcher {
+	return MatchFunc(fmt.Sprintf("header %q", key), func(p Published) bool {
+		_, ok := p.Publishing.Headers[key]
+		return ok
+	})
+}
+
+// Header matches messages with the header set to the value. Integers of any size compare equal.
+func Header(key string, value interface{}) Matcher {
+	return MatchFunc(fmt.Sprintf("header %q=%v", key, value), func(p Published) bool {
+		got, ok := p.Publishing.Headers[key]
+		return ok && valuesEqual(got, value)
+	})
+}
+
+// Body matches messages with exactly the body.
+func Body(body []byte) Matcher {
+	return MatchFunc(fmt.Sprintf("body=%q", body), func(p Published) bool {
+		return bytes.Equal(p.Publishing.Body, body)
+	})
+}
+
+// BodyJSON matches messages with a JSON body whose value at the path equals the value, compared as JSON.
+// The path is a dot separated list of object keys and array indexes, e.g. "order.items.0.sku".
+// An empty path compares the whole body.
+func BodyJSON(path string, value interface{}) Matcher {
+	return MatchFunc(fmt.Sprintf("body %s=%v", jsonPathDesc(path), value), func(p Published) bool {
+		var body interface{}
+		if err := json.Unmarshal(p.Publishing.Body, &body); err != nil {
+			return false
+		}
+
+		got, ok := lookupJSONPath(body, path)
+		if !ok {
+			return false
+		}
+
+		want, err := normalizeJSON(value)
+		if err != nil {
+			return false
+		}
+
+		return reflect.DeepEqual(got, want)
+	})
+}
+
+func jsonPathDesc(path string) string {
+	if path == "" {
+		return "$"
+	}
+	return "$." + path
+}
+
+func lookupJSONPath(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return value, true
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// normalizeJSON converts the value to what json.Unmarshal produces for it.
+func normalizeJSON(value interface{}) (interface{}, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized interface{}
+	err = json.Unmarshal(b, &normalized)
+
+	return normalized, err
+}
+
+func valuesEqual(got, want interface{}) bool {
+	if g, ok := toInt64(got); ok {
+		w, ok := toInt64(want)
+		return ok && g == w
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	}
+	return 0, false
+}
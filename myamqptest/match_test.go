@@ -0,0 +1,233 @@
+package myamqptest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dmasior/myamqp/myamqptest"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestMatchers(t *testing.T) {
+	published := myamqptest.Published{
+		Exchange:   "orders",
+		RoutingKey: "order.created",
+		Mandatory:  true,
+		Publishing: amqp091.Publishing{
+			Type:          "OrderCreated",
+			ContentType:   "application/json",
+			CorrelationId: "correlation",
+			Headers:       amqp091.Table{"attempt": int32(2), "tenant": "acme"},
+			Body:          []byte(`{"id":1,"items":[{"sku":"abc","qty":2}],"paid":false}`),
+		},
+	}
+
+	tests := []struct {
+		matcher myamqptest.Matcher
+		want    bool
+	}{
+		{matcher: myamqptest.Exchange("orders"), want: true},
+		{matcher: myamqptest.Exchange("invoices"), want: false},
+		{matcher: myamqptest.RoutingKey("order.created"), want: true},
+		{matcher: myamqptest.RoutingKey("order.deleted"), want: false},
+		{matcher: myamqptest.Mandatory(true), want: true},
+		{matcher: myamqptest.Mandatory(false), want: false},
+		{matcher: myamqptest.Type("OrderCreated"), want: true},
+		{matcher: myamqptest.Type("OrderDeleted"), want: false},
+		{matcher: myamqptest.ContentType("application/json"), want: true},
+		{matcher: myamqptest.ContentType("application/x-protobuf"), want: false},
+		{matcher: myamqptest.CorrelationID("correlation"), want: true},
+		{matcher: myamqptest.CorrelationID("other"), want: false},
+		{matcher: myamqptest.HasHeader("tenant"), want: true},
+		{matcher: myamqptest.HasHeader("missing"), want: false},
+		{matcher: myamqptest.Header("tenant", "acme"), want: true},
+		{matcher: myamqptest.Header("tenant", "other"), want: false},
+		{matcher: myamqptest.Header("attempt", 2), want: true},
+		{matcher: myamqptest.Header("attempt", int64(2)), want: true},
+		{matcher: myamqptest.Header("attempt", 3), want: false},
+		{matcher: myamqptest.Header("attempt", "2"), want: false},
+		{matcher: myamqptest.Header("missing", nil), want: false},
+		{matcher: myamqptest.Body([]byte(`{"id":1,"items":[{"sku":"abc","qty":2}],"paid":false}`)), want: true},
+		{matcher: myamqptest.Body([]byte(`{}`)), want: false},
+		{matcher: myamqptest.BodyJSON("id", 1), want: true},
+		{matcher: myamqptest.BodyJSON("id", 2), want: false},
+		{matcher: myamqptest.BodyJSON("items.0.sku", "abc"), want: true},
+		{matcher: myamqptest.BodyJSON("items.0", map[string]interface{}{"sku": "abc", "qty": 2}), want: true},
+		{matcher: myamqptest.BodyJSON("items.1.sku", "abc"), want: false},
+		{matcher: myamqptest.BodyJSON("items.x", "abc"), want: false},
+		{matcher: myamqptest.BodyJSON("id.nested", 1), want: false},
+		{matcher: myamqptest.BodyJSON("paid", false), want: true},
+		{matcher: myamqptest.BodyJSON("missing", nil), want: false},
+		{matcher: myamqptest.BodyJSON("", map[string]interface{}{"id": 1, "items": []interface{}{map[string]interface{}{"sku": "abc", "qty": 2}}, "paid": false}), want: true},
+		{matcher: myamqptest.All(), want: true},
+		{matcher: myamqptest.All(myamqptest.Exchange("orders"), myamqptest.Type("OrderCreated")), want: true},
+		{matcher: myamqptest.All(myamqptest.Exchange("orders"), myamqptest.Type("OrderDeleted")), want: false},
+		{matcher: myamqptest.Not(myamqptest.Type("OrderDeleted")), want: true},
+		{matcher: myamqptest.Not(myamqptest.Type("OrderCreated")), want: false},
+		{matcher: myamqptest.MatchFunc("custom", func(p myamqptest.Published) bool { return len(p.Publishing.Body) > 0 }), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.matcher.String(), func(t *testing.T) {
+			if got := tt.matcher.Match(published); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBodyJSONInvalidBody(t *testing.T) {
+	published := myamqptest.Published{Publishing: amqp091.Publishing{Body: []byte("not json")}}
+	if myamqptest.BodyJSON("", "not json").Match(published) {
+		t.Error("matched an invalid JSON body")
+	}
+}
+
+func TestMatcherString(t *testing.T) {
+	tests := []struct {
+		matcher myamqptest.Matcher
+		want    string
+	}{
+		{matcher: myamqptest.RoutingKey("order.created"), want: `routing_key="order.created"`},
+		{matcher: myamqptest.Not(myamqptest.Type("OrderCreated")), want: `not type="OrderCreated"`},
+		{matcher: myamqptest.BodyJSON("items.0.sku", "abc"), want: `body $.items.0.sku=abc`},
+		{matcher: myamqptest.BodyJSON("", 1), want: `body $=1`},
+		{
+			matcher: myamqptest.All(myamqptest.Exchange("orders"), myamqptest.Header("attempt", 2)),
+			want:    `{exchange="orders", header "attempt"=2}`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.matcher.String(); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+// recordingT records the failures of assertions.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecordingProducer(t *testing.T) {
+	ctx := context.Background()
+	producer := myamqptest.NewRecordingProducer("orders")
+
+	producer.Publish(ctx, "order.created", false, false, amqp091.Publishing{Type: "OrderCreated", Body: []byte(`{"id":1}`)})
+	producer.Publish(ctx, "order.paid", false, false, amqp091.Publishing{Type: "OrderPaid", Body: []byte(`{"id":1}`)})
+	confirm, err := producer.PublishWithDeferredConfirm(ctx, "order.created", true, false, amqp091.Publishing{Type: "OrderCreated", Body: []byte(`{"id":2}`)})
+	if confirm != nil || err != nil {
+		t.Fatalf("PublishWithDeferredConfirm: got %v, %v", confirm, err)
+	}
+
+	published := producer.Published()
+	if len(published) != 3 || published[0].Exchange != "orders" || published[0].Confirm || !published[2].Confirm || !published[2].Mandatory {
+		t.Fatalf("unexpected published messages %v", published)
+	}
+	if found := producer.Find(myamqptest.RoutingKey("order.created"), myamqptest.BodyJSON("id", 2)); len(found) != 1 {
+		t.Errorf("Find: got %v", found)
+	}
+
+	tests := []struct {
+		name   string
+		assert func(t testing.TB)
+		fails  bool
+	}{
+		{
+			name:   "published",
+			assert: func(t testing.TB) { producer.AssertPublished(t, myamqptest.Type("OrderPaid")) },
+		},
+		{
+			name:   "not published",
+			assert: func(t testing.TB) { producer.AssertPublished(t, myamqptest.Type("OrderDeleted")) },
+			fails:  true,
+		},
+		{
+			name:   "absent",
+			assert: func(t testing.TB) { producer.AssertNotPublished(t, myamqptest.Type("OrderDeleted")) },
+		},
+		{
+			name:   "unexpectedly present",
+			assert: func(t testing.TB) { producer.AssertNotPublished(t, myamqptest.Type("OrderPaid")) },
+			fails:  true,
+		},
+		{
+			name:   "count",
+			assert: func(t testing.TB) { producer.AssertPublishedCount(t, 2, myamqptest.Type("OrderCreated")) },
+		},
+		{
+			name:   "wrong count",
+			assert: func(t testing.TB) { producer.AssertPublishedCount(t, 1, myamqptest.Type("OrderCreated")) },
+			fails:  true,
+		},
+		{
+			name: "in order with messages in between",
+			assert: func(t testing.TB) {
+				producer.AssertPublishedInOrder(t,
+					myamqptest.BodyJSON("id", 1),
+					myamqptest.All(myamqptest.Type("OrderCreated"), myamqptest.BodyJSON("id", 2)),
+				)
+			},
+		},
+		{
+			name: "out of order",
+			assert: func(t testing.TB) {
+				producer.AssertPublishedInOrder(t, myamqptest.Type("OrderPaid"), myamqptest.BodyJSON("id", 1))
+			},
+			fails: true,
+		},
+		{
+			name: "same message for two steps",
+			assert: func(t testing.TB) {
+				producer.AssertPublishedInOrder(t, myamqptest.Type("OrderPaid"), myamqptest.Type("OrderPaid"))
+			},
+			fails: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &recordingT{TB: t}
+			tt.assert(rt)
+			if failed := len(rt.errors) > 0; failed != tt.fails {
+				t.Errorf("got failed %v, want %v: %v", failed, tt.fails, rt.errors)
+			}
+		})
+	}
+}
+
+func TestRecordingProducerErrors(t *testing.T) {
+	producer := myamqptest.NewRecordingProducer("orders")
+	errPublish := errors.New("publish error")
+
+	producer.SetError(errPublish)
+	if err := producer.Publish(context.Background(), "key", false, false, amqp091.Publishing{}); !errors.Is(err, errPublish) {
+		t.Errorf("Publish: got error %v, want %v", err, errPublish)
+	}
+
+	producer.SetError(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := producer.Publish(ctx, "key", false, false, amqp091.Publishing{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Publish: got error %v, want %v", err, context.Canceled)
+	}
+	if published := producer.Published(); len(published) != 0 {
+		t.Errorf("failed publishes were recorded: %v", published)
+	}
+
+	producer.Publish(context.Background(), "key", false, false, amqp091.Publishing{})
+	producer.Reset()
+	if published := producer.Published(); len(published) != 0 {
+		t.Errorf("Reset: got %v", published)
+	}
+}
@@ -0,0 +1,181 @@
+// Package myamqptest provides utilities for testing code using myamqp without an AMQP server.
+package myamqptest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Published represents a message published through a RecordingProducer.
+type Published struct {
+	Exchange   string
+	RoutingKey string
+	Mandatory  bool
+	Immediate  bool
+	// Confirm is true when the message was published with PublishWithDeferredConfirm.
+	Confirm    bool
+	Publishing amqp091.Publishing
+}
+
+func (p Published) String() string {
+	return fmt.Sprintf("exchange=%q routing_key=%q type=%q body=%q", p.Exchange, p.RoutingKey, p.Publishing.Type, p.Publishing.Body)
+}
+
+// RecordingProducer is a myamqp.Publisher recording all publishes instead of sending them.
+// It is safe for concurrent use.
+type RecordingProducer struct {
+	exchange  string
+	published []Published
+	err       error
+	mu        sync.Mutex
+}
+
+var _ myamqp.Publisher = (*RecordingProducer)(nil)
+
+// NewRecordingProducer creates a new RecordingProducer publishing to the given exchange.
+func NewRecordingProducer(exchange string) *RecordingProducer {
+	return &RecordingProducer{
+		exchange: exchange,
+	}
+}
+
+// SetError sets the error returned by the next publishes, which are then not recorded. Nil clears it.
+func (r *RecordingProducer) SetError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// Publish records the message.
+func (r *RecordingProducer) Publish(ctx context.Context, routingKey string, mandatory, immediate bool, msg amqp091.Publishing) error {
+	return r.record(ctx, routingKey, mandatory, immediate, false, msg)
+}
+
+// PublishWithDeferredConfirm records the message. It returns a nil DeferredConfirmation,
+// as a Producer does when its channel is not in confirm mode.
+func (r *RecordingProducer) PublishWithDeferredConfirm(ctx context.Context, routingKey string, mandatory, immediate bool, msg amqp091.Publishing) (*amqp091.DeferredConfirmation, error) {
+	return nil, r.record(ctx, routingKey, mandatory, immediate, true, msg)
+}
+
+func (r *RecordingProducer) record(ctx context.Context, routingKey string, mandatory, immediate, confirm bool, msg amqp091.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	r.published = append(r.published, Published{
+		Exchange:   r.exchange,
+		RoutingKey: routingKey,
+		Mandatory:  mandatory,
+		Immediate:  immediate,
+		Confirm:    confirm,
+		Publishing: msg,
+	})
+
+	return nil
+}
+
+// Published returns the recorded messages, in publish order.
+func (r *RecordingProducer) Published() []Published {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Published(nil), r.published...)
+}
+
+// Find returns the recorded messages matching all matchers, in publish order.
+func (r *RecordingProducer) Find(matchers ...Matcher) []Published {
+	m := All(matchers...)
+
+	var found []Published
+	for _, p := range r.Published() {
+		if m.Match(p) {
+			found = append(found, p)
+		}
+	}
+
+	return found
+}
+
+// Reset clears the recorded messages.
+func (r *RecordingProducer) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.published = nil
+}
+
+// AssertPublished fails the test unless a recorded message matches all matchers. It returns the first match.
+func (r *RecordingProducer) AssertPublished(t testing.TB, matchers ...Matcher) Published {
+	t.Helper()
+
+	found := r.Find(matchers...)
+	if len(found) == 0 {
+		t.Errorf("no message published matching %s\n%s", All(matchers...), r.describe())
+		return Published{}
+	}
+
+	return found[0]
+}
+
+// AssertNotPublished fails the test if a recorded message matches all matchers.
+func (r *RecordingProducer) AssertNotPublished(t testing.TB, matchers ...Matcher) {
+	t.Helper()
+
+	if found := r.Find(matchers...); len(found) > 0 {
+		t.Errorf("unexpected message published matching %s: %s", All(matchers...), found[0])
+	}
+}
+
+// AssertPublishedCount fails the test unless exactly count recorded messages match all matchers.
+func (r *RecordingProducer) AssertPublishedCount(t testing.TB, count int, matchers ...Matcher) {
+	t.Helper()
+
+	if found := r.Find(matchers...); len(found) != count {
+		t.Errorf("%d messages published matching %s, want %d\n%s", len(found), All(matchers...), count, r.describe())
+	}
+}
+
+// AssertPublishedInOrder fails the test unless the recorded messages contain a message matching each step,
+// in the order of the steps. Other messages may be published in between. Use All to combine matchers in a step.
+func (r *RecordingProducer) AssertPublishedInOrder(t testing.TB, steps ...Matcher) {
+	t.Helper()
+
+	published := r.Published()
+	i := 0
+	for _, step := range steps {
+		for i < len(published) && !step.Match(published[i]) {
+			i++
+		}
+		if i == len(published) {
+			t.Errorf("no message published matching %s in order\n%s", step, r.describe())
+			return
+		}
+		i++
+	}
+}
+
+func (r *RecordingProducer) describe() string {
+	published := r.Published()
+	if len(published) == 0 {
+		return "no messages published"
+	}
+
+	var b strings.Builder
+	b.WriteString("published messages:")
+	for i, p := range published {
+		fmt.Fprintf(&b, "\n  %d: %s", i, p)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package myamqp
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// OffsetStore stores the last processed stream offset per consumer name.
+type OffsetStore interface {
+	// Load returns the last stored offset for the consumer name.
+	// The ok is false when there is no offset stored yet.
+	Load(consumerName string) (offset int64, ok bool, err error)
+	// Store stores the offset for the consumer name.
+	Store(consumerName string, offset int64) error
+}
+
+// MemoryOffsetStore is an OffsetStore that keeps offsets in memory.
+// Offsets survive reconnects of MyAMQP, but not restarts of the process.
+type MemoryOffsetStore struct {
+	offsets map[string]int64
+	mu      sync.Mutex
+}
+
+// NewMemoryOffsetStore creates a new MemoryOffsetStore.
+func NewMemoryOffsetStore() *MemoryOffsetStore {
+	return &MemoryOffsetStore{
+		offsets: make(map[string]int64),
+	}
+}
+
+// Load returns the last stored offset for the consumer name.
+func (m *MemoryOffsetStore) Load(consumerName string) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	offset, ok := m.offsets[consumerName]
+	return offset, ok, nil
+}
+
+// Store stores the offset for the consumer name.
+func (m *MemoryOffsetStore) Store(consumerName string, offset int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.offsets[consumerName] = offset
+	return nil
+}
+
+// FileOffsetStore is an OffsetStore that keeps offsets in a JSON file.
+// The file is rewritten atomically on every Store.
+type FileOffsetStore struct {
+	path    string
+	offsets map[string]int64
+	mu      sync.Mutex
+}
+
+// NewFileOffsetStore creates a new FileOffsetStore with the given file path.
+// Offsets already stored in the file are loaded, a missing file is created on the first Store.
+func NewFileOffsetStore(path string) (*FileOffsetStore, error) {
+	offsets := make(map[string]int64)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &offsets); err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileOffsetStore{
+		path:    path,
+		offsets: offsets,
+	}, nil
+}
+
+// Load returns the last stored offset for the consumer name.
+func (f *FileOffsetStore) Load(consumerName string) (int64, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	offset, ok := f.offsets[consumerName]
+	return offset, ok, nil
+}
+
+// Store stores the offset for the consumer name and writes the file.
+func (f *FileOffsetStore) Store(consumerName string, offset int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.offsets[consumerName] = offset
+
+	data, err := json.Marshal(f.offsets)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so a crash never leaves a truncated file behind.
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -14,13 +14,14 @@ var (
 
 // ExchangeOptions represents options for configuring an exchange.
 type ExchangeOptions struct {
-	name       string
-	kind       string
-	durable    bool
-	autoDelete bool
-	internal   bool
-	noWait     bool
-	args       amqp091.Table
+	name        string
+	kind        string
+	durable     bool
+	autoDelete  bool
+	internal    bool
+	noWait      bool
+	args        amqp091.Table
+	declareMode DeclareMode
 }
 
 // NewExchangeOptions creates a new ExchangeOptions with the given name and kind.
@@ -61,15 +62,25 @@ func (eo *ExchangeOptions) WithArgs(args amqp091.Table) *ExchangeOptions {
 	return eo
 }
 
+// WithDeclareMode sets the DeclareMode on the ExchangeOptions.
+// Defaults to DeclareModeDeclare.
+func (eo *ExchangeOptions) WithDeclareMode(mode DeclareMode) *ExchangeOptions {
+	eo.declareMode = mode
+	return eo
+}
+
 // QueueOptions represents options for configuring a queue.
 type QueueOptions struct {
-	name       string
-	routingKey string
-	durable    bool
-	autoDelete bool
-	exclusive  bool
-	noWait     bool
-	args       amqp091.Table
+	name        string
+	routingKey  string
+	durable     bool
+	autoDelete  bool
+	exclusive   bool
+	noWait      bool
+	args        amqp091.Table
+	typedArgs   queueArgs
+	declareMode DeclareMode
+	skipBind    bool
 }
 
 // NewQueueOptions creates a new QueueOptions with the given name.
@@ -110,29 +121,62 @@ func (qo *QueueOptions) WithNoWait(noWait bool) *QueueOptions {
 }
 
 // WithArgs sets the args on the QueueOptions.
+// The args are used for both declaring and binding the queue. See the typed setters in queueargs.go
+// for the common x-arguments.
 func (qo *QueueOptions) WithArgs(args amqp091.Table) *QueueOptions {
 	qo.args = args
 	return qo
 }
 
+// WithDeclareMode sets the DeclareMode on the QueueOptions.
+// Defaults to DeclareModeDeclare. A declared or passive queue is bound to the exchange, which requires
+// only write permission on the queue and read permission on the exchange, a skipped queue is not.
+func (qo *QueueOptions) WithDeclareMode(mode DeclareMode) *QueueOptions {
+	qo.declareMode = mode
+	return qo
+}
+
+// WithSkipBind sets the skipBind on the QueueOptions.
+// When true, the queue is not bound to the exchange, e.g. when the binding already exists.
+func (qo *QueueOptions) WithSkipBind(skipBind bool) *QueueOptions {
+	qo.skipBind = skipBind
+	return qo
+}
+
 // ConsumerOptions represents options for configuring a consumer.
 type ConsumerOptions struct {
-	name         string
-	autoAck      bool
-	exclusive    bool
-	noLocal      bool
-	noWait       bool
-	args         amqp091.Table
-	exchangeOpts *ExchangeOptions
-	queueOpts    *QueueOptions
+	name          string
+	autoAck       bool
+	exclusive     bool
+	noLocal       bool
+	noWait        bool
+	args          amqp091.Table
+	exchangeOpts  *ExchangeOptions
+	queueOpts     *QueueOptions
+	streamOpts    *StreamOptions
+	claimCheck    *ClaimCheck
+	decompression *Decompression
+	validation    *MessageValidation
 }
 
 // NewConsumerOptions creates a new ConsumerOptions with the given name, ExchangeOptions, and QueueOptions.
+// ExchangeOptions may be nil to consume from an existing queue, see NewQueueConsumerOptions.
 func NewConsumerOptions(name string, exchangeOptions *ExchangeOptions, queueOptions *QueueOptions) *ConsumerOptions {
 	return &ConsumerOptions{
-		name:         name,
-		exchangeOpts: exchangeOptions,
-		queueOpts:    queueOptions,
+		name:          name,
+		exchangeOpts:  exchangeOptions,
+		queueOpts:     queueOptions,
+		decompression: NewDecompression(),
+	}
+}
+
+// NewQueueConsumerOptions creates a new ConsumerOptions with the given name and QueueOptions.
+// The consumer does not declare an exchange nor bind the queue, it consumes from the queue directly.
+func NewQueueConsumerOptions(name string, queueOptions *QueueOptions) *ConsumerOptions {
+	return &ConsumerOptions{
+		name:          name,
+		queueOpts:     queueOptions,
+		decompression: NewDecompression(),
 	}
 }
 
@@ -160,6 +204,33 @@ func (co *ConsumerOptions) WithQueueOptions(queueOpts *QueueOptions) *ConsumerOp
 	return co
 }
 
+// WithStreamOptions sets the StreamOptions on the ConsumerOptions, making it a stream consumer.
+func (co *ConsumerOptions) WithStreamOptions(streamOpts *StreamOptions) *ConsumerOptions {
+	co.streamOpts = streamOpts
+	return co
+}
+
+// WithClaimCheck sets the ClaimCheck on the ConsumerOptions, resolving offloaded bodies from its BlobStore.
+func (co *ConsumerOptions) WithClaimCheck(claimCheck *ClaimCheck) *ConsumerOptions {
+	co.claimCheck = claimCheck
+	return co
+}
+
+// WithDecompression sets the Decompression on the ConsumerOptions, decompressing the bodies
+// of deliveries compressed by a Producer with a Compression. Deliveries are decompressed by default
+// with NewDecompression, a nil Decompression turns it off.
+func (co *ConsumerOptions) WithDecompression(decompression *Decompression) *ConsumerOptions {
+	co.decompression = decompression
+	return co
+}
+
+// WithValidation sets the MessageValidation on the ConsumerOptions.
+// Deliveries are validated after decompression, invalid deliveries never reach the handler.
+func (co *ConsumerOptions) WithValidation(validation *MessageValidation) *ConsumerOptions {
+	co.validation = validation
+	return co
+}
+
 // WithExclusive sets the exclusive on the ConsumerOptions.
 func (co *ConsumerOptions) WithExclusive(exclusive bool) *ConsumerOptions {
 	co.exclusive = exclusive
@@ -186,8 +257,13 @@ func (co *ConsumerOptions) WithArgs(args amqp091.Table) *ConsumerOptions {
 
 // ProducerOptions represents options for configuring a producer.
 type ProducerOptions struct {
-	exchangeOpts *ExchangeOptions
-	queueOpts    *QueueOptions
+	exchangeOpts  *ExchangeOptions
+	queueOpts     *QueueOptions
+	compression   *Compression
+	claimCheck    *ClaimCheck
+	validation    *MessageValidation
+	blockedPolicy *BlockedPolicy
+	confirm       bool
 }
 
 // NewProducerOptions creates a new ProducerOptions with the given ExchangeOptions.
@@ -203,6 +279,40 @@ func (po *ProducerOptions) WithQueueOptions(queueOpts *QueueOptions) *ProducerOp
 	return po
 }
 
+// WithCompression sets the Compression on the ProducerOptions.
+func (po *ProducerOptions) WithCompression(compression *Compression) *ProducerOptions {
+	po.compression = compression
+	return po
+}
+
+// WithClaimCheck sets the ClaimCheck on the ProducerOptions.
+// Bodies are offloaded after compression, so the BlobStore holds the compressed body.
+func (po *ProducerOptions) WithClaimCheck(claimCheck *ClaimCheck) *ProducerOptions {
+	po.claimCheck = claimCheck
+	return po
+}
+
+// WithValidation sets the MessageValidation on the ProducerOptions.
+// Messages are validated before compression, invalid messages are not published.
+func (po *ProducerOptions) WithValidation(validation *MessageValidation) *ProducerOptions {
+	po.validation = validation
+	return po
+}
+
+// WithBlockedPolicy sets the BlockedPolicy on the ProducerOptions.
+// Without a BlockedPolicy, publishing on a blocked connection blocks until it is unblocked or ctx is done.
+func (po *ProducerOptions) WithBlockedPolicy(blockedPolicy *BlockedPolicy) *ProducerOptions {
+	po.blockedPolicy = blockedPolicy
+	return po
+}
+
+// WithConfirm sets the confirm on the ProducerOptions.
+// When true, the channel is put in confirm mode and PublishWithDeferredConfirm returns a DeferredConfirmation.
+func (po *ProducerOptions) WithConfirm(confirm bool) *ProducerOptions {
+	po.confirm = confirm
+	return po
+}
+
 // Qos represents options for configuring Qos.
 type Qos struct {
 	prefetchCount int
@@ -2,19 +2,43 @@ package myamqp
 
 import (
 	"context"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Publisher publishes messages. It is implemented by Producer,
+// depend on it to replace the Producer in tests, e.g. with myamqptest.RecordingProducer.
+type Publisher interface {
+	Publish(ctx context.Context, routingKey string, mandatory, immediate bool, msg amqp091.Publishing) error
+	PublishWithDeferredConfirm(ctx context.Context, routingKey string, mandatory, immediate bool, msg amqp091.Publishing) (*amqp091.DeferredConfirmation, error)
+}
+
+var _ Publisher = (*Producer)(nil)
+
 // Producer represents an AMQP producer.
 type Producer struct {
-	channel *amqp091.Channel
+	channel Channel
 	options *ProducerOptions
+	tracing *Tracing
+	metrics Metrics
+	logger  *slog.Logger
+	flow    *flowControl
+	buffer  []bufferedPublishing
+	// flushing is set while a flushBuffer goroutine runs, so a single one publishes the buffer.
+	flushing bool
+	bufMu    sync.Mutex
+
+	unregister func()
 }
 
 // Producer creates a new producer with the given ProducerOptions.
 func (s *MyAMQP) Producer(options *ProducerOptions) (*Producer, error) {
-	if s.conn == nil || s.conn.IsClosed() {
+	conn := s.connection()
+	if conn == nil || conn.IsClosed() {
 		return nil, ErrNotConnected
 	}
 
@@ -26,10 +50,32 @@ func (s *MyAMQP) Producer(options *ProducerOptions) (*Producer, error) {
 		return nil, ErrExchangeOptionsCannotBeNil
 	}
 
-	channel, err := s.conn.Channel()
+	if options.compression != nil {
+		if err := options.compression.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.claimCheck != nil {
+		if err := options.claimCheck.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.queueOpts != nil {
+		if err := options.queueOpts.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	logger := s.logger().With("exchange", options.exchangeOpts.name)
+
+	channel, err := conn.Channel()
 	if err != nil {
+		logger.Error("channel open failed", "error", err)
 		return nil, err
 	}
+	logChannelClose(logger, channel)
 
 	if s.config.Qos() != nil {
 		err = channel.Qos(
@@ -38,43 +84,28 @@ func (s *MyAMQP) Producer(options *ProducerOptions) (*Producer, error) {
 			s.config.Qos().Global(),
 		)
 		if err != nil {
-			s.conn.Close()
-			s.conn = nil
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if options.confirm {
+		if err = channel.Confirm(false); err != nil {
+			logger.Error("confirm mode failed", "error", err)
 			return nil, err
 		}
 	}
 
-	if err = channel.ExchangeDeclare(
-		options.exchangeOpts.name,
-		options.exchangeOpts.kind,
-		options.exchangeOpts.durable,
-		options.exchangeOpts.autoDelete,
-		options.exchangeOpts.internal,
-		options.exchangeOpts.noWait,
-		options.exchangeOpts.args,
-	); err != nil {
+	if err = declareExchange(logger, channel, options.exchangeOpts); err != nil {
 		return nil, err
 	}
 
 	if options.queueOpts != nil {
-		if _, err = channel.QueueDeclare(
-			options.queueOpts.name,
-			options.queueOpts.durable,
-			options.queueOpts.autoDelete,
-			options.queueOpts.exclusive,
-			options.queueOpts.noWait,
-			options.queueOpts.args,
-		); err != nil {
+		if err = declareQueue(logger, channel, options.queueOpts); err != nil {
 			return nil, err
 		}
 
-		if err = channel.QueueBind(
-			options.queueOpts.name,
-			options.queueOpts.routingKey,
-			options.exchangeOpts.name,
-			options.queueOpts.noWait,
-			options.queueOpts.args,
-		); err != nil {
+		if err = bindQueue(logger, channel, options.exchangeOpts, options.queueOpts); err != nil {
 			return nil, err
 		}
 	}
@@ -82,14 +113,46 @@ func (s *MyAMQP) Producer(options *ProducerOptions) (*Producer, error) {
 	producer := &Producer{
 		options: options,
 		channel: channel,
+		tracing: s.config.Tracing(),
+		metrics: s.config.Metrics(),
+		logger:  logger,
+		flow:    s.flow,
 	}
 
+	go observeReturns(producer.metrics, channel.NotifyReturn(make(chan amqp091.Return, 1)))
+
+	s.health.addProducer(producer)
+	producer.unregister = func() { s.health.removeProducer(producer) }
+
 	return producer, nil
 }
 
 // Publish publishes a message to the AMQP server.
-func (s *Producer) Publish(ctx context.Context, routingKey string, mandatory, immediate bool, msg amqp091.Publishing) error {
-	return s.channel.PublishWithContext(
+func (s *Producer) Publish(ctx context.Context, routingKey string, mandatory, immediate bool, msg amqp091.Publishing) (err error) {
+	if s.tracing != nil {
+		var span trace.Span
+		ctx, span, msg = s.tracing.startPublish(ctx, s.options.exchangeOpts.name, routingKey, msg)
+		defer func() { endSpan(span, err) }()
+	}
+
+	msg, offloaded, err := s.prepare(ctx, routingKey, msg)
+	if err != nil {
+		s.logger.Error("publish failed", "routing_key", routingKey, "error", err)
+		return err
+	}
+	// Nothing refers to an offloaded body when the reference message is not published.
+	defer func() {
+		if err != nil {
+			s.releaseOffloaded(offloaded)
+		}
+	}()
+
+	buffered, err := s.checkBlocked(ctx, routingKey, mandatory, immediate, false, msg, offloaded)
+	if err != nil || buffered {
+		return err
+	}
+
+	err = s.channel.PublishWithContext(
 		ctx,
 		s.options.exchangeOpts.name,
 		routingKey,
@@ -97,11 +160,39 @@ func (s *Producer) Publish(ctx context.Context, routingKey string, mandatory, im
 		immediate,
 		msg,
 	)
+	s.metrics.Publish(s.options.exchangeOpts.name, routingKey, err)
+	if err != nil {
+		s.logger.Error("publish failed", "routing_key", routingKey, "error", err)
+	}
+
+	return err
 }
 
 // PublishWithDeferredConfirm publishes a message to the AMQP server and returns a DeferredConfirmation.
-func (s *Producer) PublishWithDeferredConfirm(ctx context.Context, routingKey string, mandatory, immediate bool, msg amqp091.Publishing) (*amqp091.DeferredConfirmation, error) {
-	return s.channel.PublishWithDeferredConfirmWithContext(
+func (s *Producer) PublishWithDeferredConfirm(ctx context.Context, routingKey string, mandatory, immediate bool, msg amqp091.Publishing) (_ *amqp091.DeferredConfirmation, err error) {
+	if s.tracing != nil {
+		var span trace.Span
+		ctx, span, msg = s.tracing.startPublish(ctx, s.options.exchangeOpts.name, routingKey, msg)
+		defer func() { endSpan(span, err) }()
+	}
+
+	msg, offloaded, err := s.prepare(ctx, routingKey, msg)
+	if err != nil {
+		s.logger.Error("publish failed", "routing_key", routingKey, "error", err)
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			s.releaseOffloaded(offloaded)
+		}
+	}()
+
+	if _, err = s.checkBlocked(ctx, routingKey, mandatory, immediate, true, msg, offloaded); err != nil {
+		return nil, err
+	}
+
+	published := time.Now()
+	confirm, err := s.channel.PublishWithDeferredConfirmWithContext(
 		ctx,
 		s.options.exchangeOpts.name,
 		routingKey,
@@ -109,4 +200,70 @@ func (s *Producer) PublishWithDeferredConfirm(ctx context.Context, routingKey st
 		immediate,
 		msg,
 	)
+	s.metrics.Publish(s.options.exchangeOpts.name, routingKey, err)
+	if err != nil {
+		s.logger.Error("publish failed", "routing_key", routingKey, "error", err)
+		return nil, err
+	}
+
+	// The confirmation is nil when the channel is not in confirm mode.
+	if confirm != nil {
+		go observeConfirm(s.metrics, s.options.exchangeOpts.name, confirm, published)
+	}
+
+	return confirm, nil
+}
+
+// Close closes the channel of the producer and removes it from the HealthStatus.
+func (s *Producer) Close() error {
+	s.unregister()
+
+	if s.channel.IsClosed() {
+		return nil
+	}
+
+	return s.channel.Close()
+}
+
+// NotifyReturn registers a listener for messages published with mandatory or immediate which the AMQP server returned.
+// See amqp091.Channel.NotifyReturn.
+func (s *Producer) NotifyReturn(receiver chan amqp091.Return) chan amqp091.Return {
+	return s.channel.NotifyReturn(receiver)
+}
+
+// prepare applies the ProducerOptions to the message before publishing,
+// and returns the BlobStore key of the body offloaded by the ClaimCheck, if any.
+func (s *Producer) prepare(ctx context.Context, routingKey string, msg amqp091.Publishing) (amqp091.Publishing, string, error) {
+	var err error
+	if s.options.validation != nil {
+		if err = s.options.validation.Validate(s.options.exchangeOpts.name, routingKey, msg.Body); err != nil {
+			return msg, "", err
+		}
+	}
+
+	if s.options.compression != nil {
+		if msg, err = s.options.compression.compress(msg); err != nil {
+			return msg, "", err
+		}
+	}
+
+	var offloaded string
+	if s.options.claimCheck != nil {
+		if msg, offloaded, err = s.options.claimCheck.offload(ctx, msg); err != nil {
+			return msg, "", err
+		}
+	}
+
+	return msg, offloaded, nil
+}
+
+// releaseOffloaded deletes the body offloaded for a message which was not published.
+func (s *Producer) releaseOffloaded(key string) {
+	if key == "" {
+		return
+	}
+
+	if err := s.options.claimCheck.store.Delete(context.Background(), key); err != nil {
+		s.logger.Warn("claim check delete failed", "key", key, "error", err)
+	}
 }
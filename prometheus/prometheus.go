@@ -0,0 +1,179 @@
+// Package prometheus provides a myamqp.Metrics exposing Prometheus metrics.
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ myamqp.Metrics = (*Metrics)(nil)
+
+// Metrics is a myamqp.Metrics exposing Prometheus metrics per exchange and queue.
+// It implements prometheus.Collector, register it with a prometheus.Registerer.
+type Metrics struct {
+	connects        *prometheus.CounterVec
+	reconnects      prometheus.Counter
+	published       *prometheus.CounterVec
+	confirms        *prometheus.CounterVec
+	confirmLatency  *prometheus.HistogramVec
+	returns         *prometheus.CounterVec
+	deliveries      *prometheus.CounterVec
+	settled         *prometheus.CounterVec
+	inFlight        *prometheus.GaugeVec
+	handlerDuration *prometheus.HistogramVec
+}
+
+// NewMetrics creates a new Metrics with metric names prefixed by the given namespace.
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		connects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "connects_total",
+			Help:      "Number of connection attempts by result.",
+		}, []string{"result"}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Number of reconnect attempts.",
+		}),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "published_total",
+			Help:      "Number of published messages by exchange and result.",
+		}, []string{"exchange", "result"}),
+		confirms: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "confirms_total",
+			Help:      "Number of publisher confirms by exchange and result.",
+		}, []string{"exchange", "result"}),
+		confirmLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "confirm_latency_seconds",
+			Help:      "Time from publish to publisher confirm by exchange.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"exchange"}),
+		returns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "returned_total",
+			Help:      "Number of returned unroutable messages by exchange.",
+		}, []string{"exchange"}),
+		deliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "delivered_total",
+			Help:      "Number of received deliveries by queue.",
+		}, []string{"queue"}),
+		settled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "settled_total",
+			Help:      "Number of acked, nacked and rejected deliveries by queue.",
+		}, []string{"queue", "outcome", "requeue"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "in_flight",
+			Help:      "Number of received deliveries not acked, nacked or rejected yet by queue.",
+		}, []string{"queue"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Time from a delivery being received to being acked, nacked or rejected by queue.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"queue"}),
+	}
+}
+
+func (p *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		p.connects,
+		p.reconnects,
+		p.published,
+		p.confirms,
+		p.confirmLatency,
+		p.returns,
+		p.deliveries,
+		p.settled,
+		p.inFlight,
+		p.handlerDuration,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (p *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range p.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (p *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range p.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (p *Metrics) Connect(err error) {
+	p.connects.WithLabelValues(resultLabel(err)).Inc()
+}
+
+func (p *Metrics) Reconnect(int) {
+	p.reconnects.Inc()
+}
+
+func (p *Metrics) Publish(exchange, _ string, err error) {
+	p.published.WithLabelValues(exchange, resultLabel(err)).Inc()
+}
+
+func (p *Metrics) Confirm(exchange string, acked bool, latency time.Duration) {
+	outcome := "ack"
+	if !acked {
+		outcome = "nack"
+	}
+	p.confirms.WithLabelValues(exchange, outcome).Inc()
+	p.confirmLatency.WithLabelValues(exchange).Observe(latency.Seconds())
+}
+
+func (p *Metrics) Return(exchange, _ string) {
+	p.returns.WithLabelValues(exchange).Inc()
+}
+
+func (p *Metrics) Deliver(queue string, autoAck bool) {
+	p.deliveries.WithLabelValues(queue).Inc()
+	if !autoAck {
+		p.inFlight.WithLabelValues(queue).Inc()
+	}
+}
+
+func (p *Metrics) Ack(queue string) {
+	p.settle(queue, "ack", false)
+}
+
+func (p *Metrics) Nack(queue string, requeue bool) {
+	p.settle(queue, "nack", requeue)
+}
+
+func (p *Metrics) Reject(queue string, requeue bool) {
+	p.settle(queue, "reject", requeue)
+}
+
+func (p *Metrics) HandlerDuration(queue string, duration time.Duration) {
+	p.handlerDuration.WithLabelValues(queue).Observe(duration.Seconds())
+}
+
+func (p *Metrics) Unsettled(queue string, count int) {
+	p.inFlight.WithLabelValues(queue).Sub(float64(count))
+}
+
+func (p *Metrics) settle(queue, outcome string, requeue bool) {
+	p.settled.WithLabelValues(queue, outcome, strconv.FormatBool(requeue)).Inc()
+	p.inFlight.WithLabelValues(queue).Dec()
+}
+
+// resultLabel returns the result label value of an operation which failed with err, if not nil.
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -0,0 +1,56 @@
+package prometheus_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	myamqpprometheus "github.com/dmasior/myamqp/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := myamqpprometheus.NewMetrics("myamqp")
+
+	metrics.Connect(nil)
+	metrics.Connect(errors.New("refused"))
+	metrics.Publish("orders", "order.created", nil)
+	metrics.Confirm("orders", false, time.Millisecond)
+	metrics.Deliver("orders", false)
+	metrics.Deliver("orders", false)
+	metrics.Deliver("orders", false)
+	metrics.Deliver("orders", true)
+	metrics.Ack("orders")
+	metrics.Nack("orders", true)
+	metrics.Unsettled("orders", 1)
+
+	want := `
+# HELP myamqp_connects_total Number of connection attempts by result.
+# TYPE myamqp_connects_total counter
+myamqp_connects_total{result="error"} 1
+myamqp_connects_total{result="success"} 1
+# HELP myamqp_confirms_total Number of publisher confirms by exchange and result.
+# TYPE myamqp_confirms_total counter
+myamqp_confirms_total{exchange="orders",result="nack"} 1
+# HELP myamqp_delivered_total Number of received deliveries by queue.
+# TYPE myamqp_delivered_total counter
+myamqp_delivered_total{queue="orders"} 4
+# HELP myamqp_in_flight Number of received deliveries not acked, nacked or rejected yet by queue.
+# TYPE myamqp_in_flight gauge
+myamqp_in_flight{queue="orders"} 0
+# HELP myamqp_published_total Number of published messages by exchange and result.
+# TYPE myamqp_published_total counter
+myamqp_published_total{exchange="orders",result="success"} 1
+# HELP myamqp_settled_total Number of acked, nacked and rejected deliveries by queue.
+# TYPE myamqp_settled_total counter
+myamqp_settled_total{outcome="ack",queue="orders",requeue="false"} 1
+myamqp_settled_total{outcome="nack",queue="orders",requeue="true"} 1
+`
+	err := testutil.CollectAndCompare(metrics, strings.NewReader(want),
+		"myamqp_connects_total", "myamqp_confirms_total", "myamqp_delivered_total",
+		"myamqp_in_flight", "myamqp_published_total", "myamqp_settled_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,268 @@
+package myamqp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrInvalidQueueOptions = errors.New("invalid queue options")
+)
+
+// QueueType represents the x-queue-type of a queue.
+type QueueType string
+
+const (
+	QueueTypeClassic QueueType = "classic"
+	QueueTypeQuorum  QueueType = "quorum"
+	QueueTypeStream  QueueType = "stream"
+)
+
+// Overflow represents the x-overflow behaviour of a queue when its max length is reached.
+type Overflow string
+
+const (
+	OverflowDropHead         Overflow = "drop-head"
+	OverflowRejectPublish    Overflow = "reject-publish"
+	OverflowRejectPublishDLX Overflow = "reject-publish-dlx"
+)
+
+const (
+	argQueueType            = "x-queue-type"
+	argMaxLength            = "x-max-length"
+	argMaxLengthBytes       = "x-max-length-bytes"
+	argOverflow             = "x-overflow"
+	argMessageTTL           = "x-message-ttl"
+	argExpires              = "x-expires"
+	argMaxPriority          = "x-max-priority"
+	argSingleActiveConsumer = "x-single-active-consumer"
+	argDeliveryLimit        = "x-delivery-limit"
+	argQueueMode            = "x-queue-mode"
+)
+
+// queueArgs holds the typed queue arguments set on the QueueOptions.
+type queueArgs struct {
+	queueType            QueueType
+	maxLength            *int64
+	maxLengthBytes       *int64
+	overflow             Overflow
+	messageTTL           *time.Duration
+	expires              *time.Duration
+	maxPriority          *uint8
+	singleActiveConsumer bool
+	deliveryLimit        *int64
+	lazyMode             bool
+}
+
+// WithQueueType sets the x-queue-type on the QueueOptions.
+func (qo *QueueOptions) WithQueueType(queueType QueueType) *QueueOptions {
+	qo.typedArgs.queueType = queueType
+	return qo
+}
+
+// WithMaxLength sets the x-max-length on the QueueOptions.
+func (qo *QueueOptions) WithMaxLength(maxLength int64) *QueueOptions {
+	qo.typedArgs.maxLength = &maxLength
+	return qo
+}
+
+// WithMaxLengthBytes sets the x-max-length-bytes on the QueueOptions, the max total size of the message bodies.
+func (qo *QueueOptions) WithMaxLengthBytes(maxLengthBytes int64) *QueueOptions {
+	qo.typedArgs.maxLengthBytes = &maxLengthBytes
+	return qo
+}
+
+// WithOverflow sets the x-overflow on the QueueOptions.
+func (qo *QueueOptions) WithOverflow(overflow Overflow) *QueueOptions {
+	qo.typedArgs.overflow = overflow
+	return qo
+}
+
+// WithMessageTTL sets the x-message-ttl on the QueueOptions. The TTL is sent in milliseconds.
+func (qo *QueueOptions) WithMessageTTL(ttl time.Duration) *QueueOptions {
+	qo.typedArgs.messageTTL = &ttl
+	return qo
+}
+
+// WithExpires sets the x-expires on the QueueOptions. The expiry is sent in milliseconds.
+func (qo *QueueOptions) WithExpires(expires time.Duration) *QueueOptions {
+	qo.typedArgs.expires = &expires
+	return qo
+}
+
+// WithMaxPriority sets the x-max-priority on the QueueOptions.
+func (qo *QueueOptions) WithMaxPriority(maxPriority uint8) *QueueOptions {
+	qo.typedArgs.maxPriority = &maxPriority
+	return qo
+}
+
+// WithSingleActiveConsumer sets the x-single-active-consumer on the QueueOptions.
+func (qo *QueueOptions) WithSingleActiveConsumer(singleActiveConsumer bool) *QueueOptions {
+	qo.typedArgs.singleActiveConsumer = singleActiveConsumer
+	return qo
+}
+
+// WithDeliveryLimit sets the x-delivery-limit on the QueueOptions. It is supported by quorum queues only.
+func (qo *QueueOptions) WithDeliveryLimit(deliveryLimit int64) *QueueOptions {
+	qo.typedArgs.deliveryLimit = &deliveryLimit
+	return qo
+}
+
+// WithLazyMode sets the x-queue-mode to lazy on the QueueOptions. It is supported by classic queues only.
+func (qo *QueueOptions) WithLazyMode(lazyMode bool) *QueueOptions {
+	qo.typedArgs.lazyMode = lazyMode
+	return qo
+}
+
+// Validate checks the QueueOptions for invalid values and incompatible combinations of arguments.
+// It is called by MyAMQP before contacting the AMQP server.
+func (qo *QueueOptions) Validate() error {
+	a := qo.typedArgs
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: queue %q: %s", ErrInvalidQueueOptions, qo.name, fmt.Sprintf(format, args...))
+	}
+
+	switch a.queueType {
+	case "", QueueTypeClassic, QueueTypeQuorum, QueueTypeStream:
+	default:
+		return invalid("unknown queue type %q", a.queueType)
+	}
+
+	switch a.overflow {
+	case "", OverflowDropHead, OverflowRejectPublish, OverflowRejectPublishDLX:
+	default:
+		return invalid("unknown overflow %q", a.overflow)
+	}
+
+	if a.maxLength != nil && *a.maxLength < 0 {
+		return invalid("max length must not be negative")
+	}
+	if a.maxLengthBytes != nil && *a.maxLengthBytes < 0 {
+		return invalid("max length bytes must not be negative")
+	}
+	if a.messageTTL != nil && *a.messageTTL < 0 {
+		return invalid("message ttl must not be negative")
+	}
+	if a.expires != nil && *a.expires < time.Millisecond {
+		return invalid("expires must be at least 1ms")
+	}
+	if a.maxPriority != nil && *a.maxPriority == 0 {
+		return invalid("max priority must be between 1 and 255")
+	}
+	if a.deliveryLimit != nil && *a.deliveryLimit < 0 {
+		return invalid("delivery limit must not be negative")
+	}
+	if a.overflow != "" && !qo.hasMaxLength() {
+		return invalid("overflow requires max length or max length bytes")
+	}
+
+	switch a.queueType {
+	case QueueTypeQuorum, QueueTypeStream:
+		if !qo.durable {
+			return invalid("%s queues must be durable", a.queueType)
+		}
+		if qo.exclusive {
+			return invalid("%s queues cannot be exclusive", a.queueType)
+		}
+		if qo.autoDelete {
+			return invalid("%s queues cannot be auto-deleted", a.queueType)
+		}
+		if a.maxPriority != nil {
+			return invalid("%s queues do not support max priority", a.queueType)
+		}
+		if a.lazyMode {
+			return invalid("%s queues do not support lazy mode", a.queueType)
+		}
+	}
+
+	switch a.queueType {
+	case QueueTypeQuorum:
+		if a.overflow == OverflowRejectPublishDLX {
+			return invalid("quorum queues do not support overflow %q", a.overflow)
+		}
+	case QueueTypeStream:
+		if a.maxLength != nil {
+			return invalid("stream queues do not support max length")
+		}
+		if a.overflow != "" {
+			return invalid("stream queues do not support overflow")
+		}
+		if a.messageTTL != nil {
+			return invalid("stream queues do not support message ttl")
+		}
+		if a.expires != nil {
+			return invalid("stream queues do not support expires")
+		}
+		if a.singleActiveConsumer {
+			return invalid("stream queues do not support single active consumer")
+		}
+		if a.deliveryLimit != nil {
+			return invalid("stream queues do not support delivery limit")
+		}
+	default:
+		if a.deliveryLimit != nil {
+			return invalid("delivery limit is supported by quorum queues only")
+		}
+	}
+
+	return nil
+}
+
+// hasMaxLength reports whether the queue has a max length or a max length bytes, typed or set with WithArgs.
+func (qo *QueueOptions) hasMaxLength() bool {
+	if qo.typedArgs.maxLength != nil || qo.typedArgs.maxLengthBytes != nil {
+		return true
+	}
+	_, length := qo.args[argMaxLength]
+	_, lengthBytes := qo.args[argMaxLengthBytes]
+	return length || lengthBytes
+}
+
+// declareArgs returns the args used to declare the queue, the typed arguments take precedence over WithArgs.
+func (qo *QueueOptions) declareArgs() amqp091.Table {
+	a := qo.typedArgs
+	args := amqp091.Table{}
+	for k, v := range qo.args {
+		args[k] = v
+	}
+
+	if a.queueType != "" {
+		args[argQueueType] = string(a.queueType)
+	}
+	if a.maxLength != nil {
+		args[argMaxLength] = *a.maxLength
+	}
+	if a.maxLengthBytes != nil {
+		args[argMaxLengthBytes] = *a.maxLengthBytes
+	}
+	if a.overflow != "" {
+		args[argOverflow] = string(a.overflow)
+	}
+	if a.messageTTL != nil {
+		args[argMessageTTL] = a.messageTTL.Milliseconds()
+	}
+	if a.expires != nil {
+		args[argExpires] = a.expires.Milliseconds()
+	}
+	if a.maxPriority != nil {
+		args[argMaxPriority] = *a.maxPriority
+	}
+	if a.singleActiveConsumer {
+		args[argSingleActiveConsumer] = true
+	}
+	if a.deliveryLimit != nil {
+		args[argDeliveryLimit] = *a.deliveryLimit
+	}
+	if a.lazyMode {
+		args[argQueueMode] = "lazy"
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	return args
+}
@@ -0,0 +1,166 @@
+package myamqp_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestQueueOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		queue   *myamqp.QueueOptions
+		wantErr bool
+	}{
+		{
+			name:  "no arguments",
+			queue: myamqp.NewQueueOptions("queue"),
+		},
+		{
+			name: "quorum queue",
+			queue: myamqp.NewQueueOptions("queue").WithDurable(true).WithQueueType(myamqp.QueueTypeQuorum).
+				WithMaxLength(10).WithOverflow(myamqp.OverflowRejectPublish).WithDeliveryLimit(5),
+		},
+		{
+			name:  "overflow with max length bytes",
+			queue: myamqp.NewQueueOptions("queue").WithMaxLengthBytes(1024).WithOverflow(myamqp.OverflowDropHead),
+		},
+		{
+			name: "overflow with max length bytes set with args",
+			queue: myamqp.NewQueueOptions("queue").WithArgs(amqp091.Table{"x-max-length-bytes": int64(1024)}).
+				WithOverflow(myamqp.OverflowRejectPublish),
+		},
+		{
+			name: "overflow with max length set with args",
+			queue: myamqp.NewQueueOptions("queue").WithArgs(amqp091.Table{"x-max-length": int64(10)}).
+				WithOverflow(myamqp.OverflowRejectPublish),
+		},
+		{
+			name:    "overflow without max length",
+			queue:   myamqp.NewQueueOptions("queue").WithOverflow(myamqp.OverflowRejectPublish),
+			wantErr: true,
+		},
+		{
+			name:    "unknown queue type",
+			queue:   myamqp.NewQueueOptions("queue").WithQueueType("lazy"),
+			wantErr: true,
+		},
+		{
+			name:    "unknown overflow",
+			queue:   myamqp.NewQueueOptions("queue").WithMaxLength(10).WithOverflow("drop-tail"),
+			wantErr: true,
+		},
+		{
+			name:    "negative max length",
+			queue:   myamqp.NewQueueOptions("queue").WithMaxLength(-1),
+			wantErr: true,
+		},
+		{
+			name:    "negative max length bytes",
+			queue:   myamqp.NewQueueOptions("queue").WithMaxLengthBytes(-1),
+			wantErr: true,
+		},
+		{
+			name:    "expires below 1ms",
+			queue:   myamqp.NewQueueOptions("queue").WithExpires(time.Microsecond),
+			wantErr: true,
+		},
+		{
+			name:    "zero max priority",
+			queue:   myamqp.NewQueueOptions("queue").WithMaxPriority(0),
+			wantErr: true,
+		},
+		{
+			name:    "non-durable quorum queue",
+			queue:   myamqp.NewQueueOptions("queue").WithQueueType(myamqp.QueueTypeQuorum),
+			wantErr: true,
+		},
+		{
+			name:    "quorum queue with max priority",
+			queue:   myamqp.NewQueueOptions("queue").WithDurable(true).WithQueueType(myamqp.QueueTypeQuorum).WithMaxPriority(5),
+			wantErr: true,
+		},
+		{
+			name: "quorum queue with reject-publish-dlx",
+			queue: myamqp.NewQueueOptions("queue").WithDurable(true).WithQueueType(myamqp.QueueTypeQuorum).
+				WithMaxLength(10).WithOverflow(myamqp.OverflowRejectPublishDLX),
+			wantErr: true,
+		},
+		{
+			name:    "stream queue with message ttl",
+			queue:   myamqp.NewQueueOptions("queue").WithDurable(true).WithQueueType(myamqp.QueueTypeStream).WithMessageTTL(time.Hour),
+			wantErr: true,
+		},
+		{
+			name:    "delivery limit on a classic queue",
+			queue:   myamqp.NewQueueOptions("queue").WithDeliveryLimit(5),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.queue.Validate()
+			if tt.wantErr != errors.Is(err, myamqp.ErrInvalidQueueOptions) {
+				t.Fatalf("Validate: got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Validate: got error %v", err)
+			}
+		})
+	}
+}
+
+func TestQueueArgsDeclared(t *testing.T) {
+	broker := newBroker(t)
+	amqp := connect(t, broker)
+
+	queue := myamqp.NewQueueOptions("queue").
+		WithArgs(amqp091.Table{"x-message-ttl": int64(1000), "x-custom": "value"}).
+		WithMaxLength(10).
+		WithOverflow(myamqp.OverflowRejectPublish).
+		WithMessageTTL(time.Minute).
+		WithExpires(time.Hour).
+		WithMaxPriority(5).
+		WithSingleActiveConsumer(true)
+	if err := amqp.Declare(nil, queue); err != nil {
+		t.Fatalf("Declare: %v", err)
+	}
+
+	info, ok := broker.Queue("queue")
+	if !ok {
+		t.Fatal("queue not declared")
+	}
+
+	// The typed arguments take precedence over WithArgs.
+	want := amqp091.Table{
+		"x-max-length":             int64(10),
+		"x-overflow":               "reject-publish",
+		"x-message-ttl":            int64(60000),
+		"x-expires":                int64(3600000),
+		"x-max-priority":           uint8(5),
+		"x-single-active-consumer": true,
+		"x-custom":                 "value",
+	}
+	for k, v := range want {
+		if got := info.Args[k]; got != v {
+			t.Errorf("%s: got %v (%T), want %v (%T)", k, got, got, v, v)
+		}
+	}
+}
+
+func TestDeclareRejectsInvalidQueue(t *testing.T) {
+	broker := newBroker(t)
+	amqp := connect(t, broker)
+
+	err := amqp.Declare(nil, myamqp.NewQueueOptions("queue").WithOverflow(myamqp.OverflowRejectPublish))
+	if !errors.Is(err, myamqp.ErrInvalidQueueOptions) {
+		t.Fatalf("Declare: got error %v, want %v", err, myamqp.ErrInvalidQueueOptions)
+	}
+	if _, ok := broker.Queue("queue"); ok {
+		t.Error("invalid queue declared")
+	}
+}
@@ -13,11 +13,12 @@ var (
 )
 
 type ReconnectPolicy struct {
-	count       int
-	countMu     sync.Mutex
-	max         int
-	backoff     time.Duration
-	errListener func(error)
+	count          int
+	countMu        sync.Mutex
+	max            int
+	backoff        time.Duration
+	errListener    func(error)
+	resetOnConnect bool
 }
 
 // NewReconnectPolicy creates a new ReconnectPolicy with the given max and backoff.
@@ -35,7 +36,16 @@ func (r *ReconnectPolicy) WithErrorListener(handler func(error)) *ReconnectPolic
 	return r
 }
 
+// WithResetOnConnect sets whether the count is reset after each successful connection.
+// By default the max applies to the total number of reconnects, with the reset to consecutive failed reconnects.
+func (r *ReconnectPolicy) WithResetOnConnect(reset bool) *ReconnectPolicy {
+	r.resetOnConnect = reset
+	return r
+}
+
 func (r *ReconnectPolicy) Count() int {
+	r.countMu.Lock()
+	defer r.countMu.Unlock()
 	return r.count
 }
 
@@ -51,8 +61,19 @@ func (r *ReconnectPolicy) ErrListener() func(error) {
 	return r.errListener
 }
 
+func (r *ReconnectPolicy) ResetOnConnect() bool {
+	return r.resetOnConnect
+}
+
 func (r *ReconnectPolicy) Inc() {
 	r.countMu.Lock()
 	defer r.countMu.Unlock()
 	r.count++
 }
+
+// Reset resets the count. It is called after a successful connection when WithResetOnConnect is set.
+func (r *ReconnectPolicy) Reset() {
+	r.countMu.Lock()
+	defer r.countMu.Unlock()
+	r.count = 0
+}
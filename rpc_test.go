@@ -0,0 +1,255 @@
+package myamqp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// respond replies to the requests of the queue with the upper-cased body, and records the requests.
+func respond(t *testing.T, amqp *myamqp.MyAMQP, queue string) <-chan amqp091.Delivery {
+	t.Helper()
+
+	producer, err := amqp.Producer(myamqp.NewProducerOptions(defaultExchange()))
+	if err != nil {
+		t.Fatalf("Producer: %v", err)
+	}
+	t.Cleanup(func() { producer.Close() })
+
+	requests := make(chan amqp091.Delivery, 10)
+	deliveries := consume(t, amqp, myamqp.NewQueueConsumerOptions("responder", myamqp.NewQueueOptions(queue)))
+	go func() {
+		for d := range deliveries {
+			requests <- d
+			producer.Publish(context.Background(), d.ReplyTo, false, false, amqp091.Publishing{
+				CorrelationId: d.CorrelationId,
+				Body:          bytes.ToUpper(d.Body),
+			})
+			d.Ack(false)
+		}
+	}()
+
+	return requests
+}
+
+func newRPCClient(t *testing.T, amqp *myamqp.MyAMQP, options *myamqp.RPCClientOptions) *myamqp.RPCClient {
+	t.Helper()
+
+	client, err := amqp.RPCClient(options)
+	if err != nil {
+		t.Fatalf("RPCClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestRPCClientCall(t *testing.T) {
+	amqp := connect(t, newBroker(t))
+	requests := respond(t, amqp, "rpc")
+	client := newRPCClient(t, amqp, myamqp.NewRPCClientOptions())
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	for _, body := range []string{"ping", "pong"} {
+		reply, err := client.Call(ctx, "rpc", amqp091.Publishing{Body: []byte(body)})
+		if err != nil {
+			t.Fatalf("Call: %v", err)
+		}
+		if want := bytes.ToUpper([]byte(body)); !bytes.Equal(reply.Body, want) {
+			t.Errorf("reply: got %q, want %q", reply.Body, want)
+		}
+
+		request := receive(t, requests)
+		if request.ReplyTo == "" || request.CorrelationId != reply.CorrelationId {
+			t.Errorf("request: reply to %q, correlation id %q, reply correlation id %q",
+				request.ReplyTo, request.CorrelationId, reply.CorrelationId)
+		}
+		// The expiration is set from the deadline of the context.
+		if ttl, err := strconv.Atoi(request.Expiration); err != nil || ttl <= 0 || ttl > int(waitTimeout.Milliseconds()) {
+			t.Errorf("request expiration: got %q", request.Expiration)
+		}
+	}
+}
+
+func TestRPCClientUnroutable(t *testing.T) {
+	amqp := connect(t, newBroker(t))
+	client := newRPCClient(t, amqp, myamqp.NewRPCClientOptions().WithMandatory(true))
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	if _, err := client.Call(ctx, "missing", amqp091.Publishing{}); !errors.Is(err, myamqp.ErrRPCUnroutable) {
+		t.Errorf("Call: got error %v, want %v", err, myamqp.ErrRPCUnroutable)
+	}
+}
+
+func TestRPCClientContextDone(t *testing.T) {
+	amqp := connect(t, newBroker(t))
+	if err := amqp.Declare(nil, myamqp.NewQueueOptions("rpc")); err != nil {
+		t.Fatalf("Declare: %v", err)
+	}
+	client := newRPCClient(t, amqp, myamqp.NewRPCClientOptions())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Call(ctx, "rpc", amqp091.Publishing{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRPCClientClose(t *testing.T) {
+	amqp := connect(t, newBroker(t))
+	if err := amqp.Declare(nil, myamqp.NewQueueOptions("rpc")); err != nil {
+		t.Fatalf("Declare: %v", err)
+	}
+	client, err := amqp.RPCClient(myamqp.NewRPCClientOptions())
+	if err != nil {
+		t.Fatalf("RPCClient: %v", err)
+	}
+
+	callErr := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), "rpc", amqp091.Publishing{})
+		callErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	client.Close()
+
+	select {
+	case err := <-callErr:
+		if !errors.Is(err, myamqp.ErrRPCClientClosed) {
+			t.Errorf("pending Call: got error %v, want %v", err, myamqp.ErrRPCClientClosed)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("pending Call did not fail on Close")
+	}
+
+	if _, err = client.Call(context.Background(), "rpc", amqp091.Publishing{}); !errors.Is(err, myamqp.ErrRPCClientClosed) {
+		t.Errorf("Call after Close: got error %v, want %v", err, myamqp.ErrRPCClientClosed)
+	}
+}
+
+func TestRPCServer(t *testing.T) {
+	type order struct {
+		ID int `json:"id"`
+	}
+	errNotFound := errors.New("order not found")
+
+	amqp := connect(t, newBroker(t))
+	server, err := amqp.RPCServer(
+		myamqp.NewQueueConsumerOptions("server", myamqp.NewQueueOptions("rpc")),
+		func(request amqp091.Delivery) (interface{}, error) {
+			switch string(request.Body) {
+			case "json":
+				return order{ID: 1}, nil
+			case "bytes":
+				return []byte("raw"), nil
+			case "rpc error":
+				return nil, &myamqp.RPCError{Message: "custom"}
+			case "unencodable":
+				return func() {}, nil
+			case "nil":
+				return nil, nil
+			default:
+				return nil, errNotFound
+			}
+		},
+	)
+	if err != nil {
+		t.Fatalf("RPCServer: %v", err)
+	}
+	t.Cleanup(func() { server.Cancel() })
+	client := newRPCClient(t, amqp, myamqp.NewRPCClientOptions())
+
+	tests := []struct {
+		request         string
+		wantBody        string
+		wantContentType string
+		wantErr         string
+	}{
+		{request: "json", wantBody: `{"id":1}`, wantContentType: "application/json"},
+		{request: "bytes", wantBody: "raw"},
+		{request: "nil", wantBody: ""},
+		{request: "error", wantErr: "rpc: order not found"},
+		{request: "rpc error", wantErr: "rpc: custom"},
+		{request: "unencodable", wantErr: "rpc: json: unsupported type: func()"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.request, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+			defer cancel()
+
+			reply, err := client.Call(ctx, "rpc", amqp091.Publishing{Body: []byte(tt.request)})
+			if tt.wantErr != "" {
+				var rpcErr *myamqp.RPCError
+				if !errors.As(err, &rpcErr) || err.Error() != tt.wantErr {
+					t.Fatalf("Call: got error %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if string(reply.Body) != tt.wantBody || reply.ContentType != tt.wantContentType {
+				t.Errorf("reply: got %q (%s), want %q (%s)", reply.Body, reply.ContentType, tt.wantBody, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestRPCServerAcksRequests(t *testing.T) {
+	broker := newBroker(t)
+	amqp := connect(t, broker)
+
+	handled := make(chan string, 10)
+	server, err := amqp.RPCServer(
+		myamqp.NewQueueConsumerOptions("server", myamqp.NewQueueOptions("rpc")),
+		func(request amqp091.Delivery) (interface{}, error) {
+			handled <- string(request.Body)
+			return nil, nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("RPCServer: %v", err)
+	}
+	t.Cleanup(func() { server.Cancel() })
+
+	// A request without ReplyTo is acked after the handler returns.
+	if err = broker.Publish("", "rpc", amqp091.Publishing{Body: []byte("no reply")}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case body := <-handled:
+		if body != "no reply" {
+			t.Errorf("handled %q", body)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("request not handled")
+	}
+	waitFor(t, "the request to be acked", func() bool {
+		info, _ := broker.Queue("rpc")
+		return info.Messages == 0 && info.Unacked == 0
+	})
+}
+
+func TestRPCServerAutoAck(t *testing.T) {
+	amqp := connect(t, newBroker(t))
+
+	_, err := amqp.RPCServer(
+		myamqp.NewQueueConsumerOptions("server", myamqp.NewQueueOptions("rpc")).WithAutoAck(true),
+		func(amqp091.Delivery) (interface{}, error) { return nil, nil },
+	)
+	if !errors.Is(err, myamqp.ErrRPCServerAutoAck) {
+		t.Errorf("RPCServer: got error %v, want %v", err, myamqp.ErrRPCServerAutoAck)
+	}
+}
@@ -0,0 +1,237 @@
+package myamqp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrRPCClientClosed = errors.New("rpc client closed")
+	ErrRPCUnroutable   = errors.New("rpc request returned as unroutable")
+)
+
+// DirectReplyTo is the pseudo-queue used by RabbitMQ for direct reply-to.
+const DirectReplyTo = "amq.rabbitmq.reply-to"
+
+// RPCClientOptions represents options for configuring an RPC client.
+type RPCClientOptions struct {
+	exchangeOpts *ExchangeOptions
+	mandatory    bool
+}
+
+// NewRPCClientOptions creates a new RPCClientOptions publishing to the default exchange.
+func NewRPCClientOptions() *RPCClientOptions {
+	return &RPCClientOptions{}
+}
+
+// WithExchangeOptions sets the ExchangeOptions on the RPCClientOptions.
+// The exchange is declared according to its DeclareMode. When not set, requests are published to the default exchange.
+func (ro *RPCClientOptions) WithExchangeOptions(exchangeOpts *ExchangeOptions) *RPCClientOptions {
+	ro.exchangeOpts = exchangeOpts
+	return ro
+}
+
+// WithMandatory sets the mandatory on the RPCClientOptions.
+// When true, a request that cannot be routed to a queue fails with ErrRPCUnroutable
+// instead of waiting for the context to be done.
+func (ro *RPCClientOptions) WithMandatory(mandatory bool) *RPCClientOptions {
+	ro.mandatory = mandatory
+	return ro
+}
+
+type rpcResult struct {
+	delivery amqp091.Delivery
+	err      error
+}
+
+// RPCClient represents a request/reply client using RabbitMQ direct reply-to.
+// It is safe for concurrent use.
+type RPCClient struct {
+	channel  Channel
+	options  *RPCClientOptions
+	prefix   string
+	seq      uint64
+	pending  map[string]chan rpcResult
+	closeErr error
+	mu       sync.Mutex
+	done     chan struct{}
+}
+
+// RPCClient creates a new RPC client with the given RPCClientOptions.
+// Pending calls fail with ErrRPCClientClosed when the channel or the connection is closed,
+// create a new RPCClient in the OnConnect callback to continue after a reconnect.
+func (s *MyAMQP) RPCClient(options *RPCClientOptions) (*RPCClient, error) {
+	conn := s.connection()
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+
+	if options == nil {
+		return nil, ErrOptionsCannotBeNil
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.exchangeOpts != nil {
+		if err = declareExchange(s.logger(), channel, options.exchangeOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	// Direct reply-to requires consuming in no-ack mode on the same channel the requests are published on.
+	deliveries, err := channel.Consume(DirectReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, 8)
+	if _, err = rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	client := &RPCClient{
+		channel: channel,
+		options: options,
+		prefix:  hex.EncodeToString(prefix),
+		pending: make(map[string]chan rpcResult),
+		done:    make(chan struct{}),
+	}
+
+	returns := channel.NotifyReturn(make(chan amqp091.Return, 1))
+	closes := channel.NotifyClose(make(chan *amqp091.Error, 1))
+
+	go client.handleReturns(returns)
+	go client.handleReplies(deliveries, closes)
+
+	return client, nil
+}
+
+// Call publishes the request and waits for the reply with the matching CorrelationId.
+// When the reply is an RPCError envelope sent by an RPCServer, it is returned together with the *RPCError.
+// The ReplyTo and CorrelationId of the request are set by the client. When the context has a deadline
+// and the request has no Expiration, the Expiration is set to the time left.
+func (c *RPCClient) Call(ctx context.Context, routingKey string, request amqp091.Publishing) (amqp091.Delivery, error) {
+	correlationID, resultCh, err := c.register()
+	if err != nil {
+		return amqp091.Delivery{}, err
+	}
+	defer c.unregister(correlationID)
+
+	request.ReplyTo = DirectReplyTo
+	request.CorrelationId = correlationID
+	if deadline, ok := ctx.Deadline(); ok && request.Expiration == "" {
+		ttl := time.Until(deadline).Milliseconds()
+		if ttl < 1 {
+			ttl = 1
+		}
+		request.Expiration = strconv.FormatInt(ttl, 10)
+	}
+
+	if err = c.channel.PublishWithContext(
+		ctx,
+		c.exchangeName(),
+		routingKey,
+		c.options.mandatory,
+		false,
+		request,
+	); err != nil {
+		return amqp091.Delivery{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return amqp091.Delivery{}, ctx.Err()
+	case result := <-resultCh:
+		if result.err != nil {
+			return result.delivery, result.err
+		}
+		return result.delivery, ParseRPCError(result.delivery)
+	}
+}
+
+// Close cancels the reply consumer and closes the channel. Pending calls fail with ErrRPCClientClosed.
+func (c *RPCClient) Close() error {
+	err := c.channel.Close()
+	<-c.done
+	return err
+}
+
+func (c *RPCClient) exchangeName() string {
+	if c.options.exchangeOpts == nil {
+		return ""
+	}
+	return c.options.exchangeOpts.name
+}
+
+func (c *RPCClient) register() (string, chan rpcResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closeErr != nil {
+		return "", nil, c.closeErr
+	}
+
+	c.seq++
+	correlationID := c.prefix + "-" + strconv.FormatUint(c.seq, 10)
+	resultCh := make(chan rpcResult, 1)
+	c.pending[correlationID] = resultCh
+
+	return correlationID, resultCh, nil
+}
+
+func (c *RPCClient) unregister(correlationID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, correlationID)
+}
+
+func (c *RPCClient) resolve(correlationID string, result rpcResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if resultCh, ok := c.pending[correlationID]; ok {
+		delete(c.pending, correlationID)
+		resultCh <- result
+	}
+}
+
+func (c *RPCClient) handleReturns(returns <-chan amqp091.Return) {
+	for r := range returns {
+		c.resolve(r.CorrelationId, rpcResult{
+			err: fmt.Errorf("%w: %d %s", ErrRPCUnroutable, r.ReplyCode, r.ReplyText),
+		})
+	}
+}
+
+func (c *RPCClient) handleReplies(deliveries <-chan amqp091.Delivery, closes <-chan *amqp091.Error) {
+	defer close(c.done)
+
+	for d := range deliveries {
+		c.resolve(d.CorrelationId, rpcResult{delivery: d})
+	}
+
+	// The deliveries channel is closed together with the AMQP channel, fail all pending calls.
+	closeErr := ErrRPCClientClosed
+	if amqpErr, ok := <-closes; ok && amqpErr != nil {
+		closeErr = fmt.Errorf("%w: %v", ErrRPCClientClosed, amqpErr)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeErr = closeErr
+	for correlationID, resultCh := range c.pending {
+		delete(c.pending, correlationID)
+		resultCh <- rpcResult{err: closeErr}
+	}
+}
@@ -0,0 +1,183 @@
+package myamqp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrRPCServerAutoAck = errors.New("rpc server cannot use auto ack")
+)
+
+// RPCErrorHeader is the header set on replies carrying an RPCError envelope.
+const RPCErrorHeader = "x-rpc-error"
+
+// RPCError represents an error returned by an RPC handler. It is sent to the caller as a JSON envelope.
+type RPCError struct {
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return "rpc: " + e.Message
+}
+
+// ParseRPCError returns the RPCError carried by the reply, or nil when the reply is not an error envelope.
+func ParseRPCError(reply amqp091.Delivery) error {
+	if isErr, _ := reply.Headers[RPCErrorHeader].(bool); !isErr {
+		return nil
+	}
+
+	rpcErr := &RPCError{}
+	if err := json.Unmarshal(reply.Body, rpcErr); err != nil {
+		return &RPCError{Message: string(reply.Body)}
+	}
+
+	return rpcErr
+}
+
+// RPCHandlerFunc is a function that handles an RPC request.
+// A []byte result is sent as the reply body as is, any other non-nil result is encoded as JSON.
+// A non-nil error is sent as an RPCError envelope.
+type RPCHandlerFunc func(request amqp091.Delivery) (interface{}, error)
+
+// RPCServer represents a consumer that replies to the ReplyTo of each request.
+type RPCServer struct {
+	consumer *Consumer
+	channel  Channel
+}
+
+// RPCServer creates a new RPC server with the given ConsumerOptions and RPCHandlerFunc.
+// Each request is acked after its reply is confirmed by the AMQP server, and nacked with requeue
+// when the reply cannot be published. Requests without ReplyTo are acked after the handler returns.
+func (s *MyAMQP) RPCServer(options *ConsumerOptions, handler RPCHandlerFunc) (*RPCServer, error) {
+	conn := s.connection()
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+
+	if options == nil {
+		return nil, ErrOptionsCannotBeNil
+	}
+
+	if options.autoAck {
+		return nil, ErrRPCServerAutoAck
+	}
+
+	// Replies are published on a dedicated channel in confirm mode.
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = channel.Confirm(false); err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	server := &RPCServer{
+		channel: channel,
+	}
+
+	consumer, err := s.Consumer(options, func(deliveries <-chan amqp091.Delivery, done chan error) {
+		for d := range deliveries {
+			server.handle(d, handler)
+		}
+
+		done <- nil
+	})
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+	server.consumer = consumer
+
+	return server, nil
+}
+
+// SetCloseContext sets a context that closes the RPC server.
+func (r *RPCServer) SetCloseContext(ctx context.Context) chan error {
+	chErr := make(chan error)
+	go func() {
+		<-ctx.Done()
+		if err := r.Cancel(); err != nil {
+			chErr <- err
+		}
+	}()
+
+	return chErr
+}
+
+// Cancel cancels the consumer, waits for the in-flight request to finish and closes the reply channel.
+func (r *RPCServer) Cancel() error {
+	if err := r.consumer.Cancel(); err != nil {
+		return err
+	}
+
+	return r.channel.Close()
+}
+
+func (r *RPCServer) handle(d amqp091.Delivery, handler RPCHandlerFunc) {
+	result, handlerErr := handler(d)
+	if d.ReplyTo == "" {
+		d.Ack(false)
+		return
+	}
+
+	reply, err := newRPCReply(d, result, handlerErr)
+	if err != nil {
+		// The result cannot be encoded, reply with the encoding error instead.
+		reply, _ = newRPCReply(d, nil, err)
+	}
+
+	// Replies go to the default exchange, routed by the ReplyTo of the request.
+	confirm, err := r.channel.PublishWithDeferredConfirmWithContext(context.Background(), "", d.ReplyTo, false, false, reply)
+	if err != nil {
+		d.Nack(false, true)
+		return
+	}
+
+	if acked, err := confirm.WaitContext(context.Background()); err != nil || !acked {
+		d.Nack(false, true)
+		return
+	}
+
+	d.Ack(false)
+}
+
+func newRPCReply(d amqp091.Delivery, result interface{}, handlerErr error) (amqp091.Publishing, error) {
+	reply := amqp091.Publishing{
+		CorrelationId: d.CorrelationId,
+	}
+
+	if handlerErr != nil {
+		rpcErr := &RPCError{Message: handlerErr.Error()}
+		errors.As(handlerErr, &rpcErr)
+
+		body, err := json.Marshal(rpcErr)
+		if err != nil {
+			return reply, err
+		}
+		reply.Headers = amqp091.Table{RPCErrorHeader: true}
+		reply.ContentType = "application/json"
+		reply.Body = body
+		return reply, nil
+	}
+
+	switch v := result.(type) {
+	case nil:
+	case []byte:
+		reply.Body = v
+	default:
+		body, err := json.Marshal(v)
+		if err != nil {
+			return reply, err
+		}
+		reply.ContentType = "application/json"
+		reply.Body = body
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,368 @@
+package myamqp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrShovelSkip             = errors.New("shovel skip")
+	ErrShovelConsumerClosed   = errors.New("shovel consumer closed")
+	ErrShovelChannelClosed    = errors.New("shovel channel closed")
+	ErrSourceCannotBeNil      = errors.New("source cannot be nil")
+	ErrDestinationCannotBeNil = errors.New("destination cannot be nil")
+)
+
+const (
+	// DefaultShovelPrefetch is the number of deliveries a Shovel holds unacknowledged by default.
+	DefaultShovelPrefetch = 100
+	// DefaultShovelRetryInterval is the time a Shovel waits by default before restarting after an error.
+	DefaultShovelRetryInterval = time.Second
+)
+
+// ShovelTransform transforms a delivery from the source into the message published to the destination,
+// and returns its routing key. Returning ErrShovelSkip acks the delivery without publishing it,
+// any other error rejects it without requeue, e.g. to dead-letter it.
+type ShovelTransform func(d amqp091.Delivery) (routingKey string, msg amqp091.Publishing, err error)
+
+// ShovelOptions represents options for a Shovel.
+type ShovelOptions struct {
+	name          string
+	queueOpts     *QueueOptions
+	exchangeOpts  *ExchangeOptions
+	transform     ShovelTransform
+	rate          float64
+	prefetch      int
+	retryInterval time.Duration
+}
+
+// NewShovelOptions creates a new ShovelOptions moving messages from the queue of the QueueOptions
+// to the exchange of the ExchangeOptions, with their original routing keys, properties and headers.
+// The name is the consumer tag on the source, generated when empty.
+func NewShovelOptions(name string, queueOptions *QueueOptions, exchangeOptions *ExchangeOptions) *ShovelOptions {
+	return &ShovelOptions{
+		name:          name,
+		queueOpts:     queueOptions,
+		exchangeOpts:  exchangeOptions,
+		prefetch:      DefaultShovelPrefetch,
+		retryInterval: DefaultShovelRetryInterval,
+	}
+}
+
+// WithTransform sets the ShovelTransform on the ShovelOptions.
+func (so *ShovelOptions) WithTransform(transform ShovelTransform) *ShovelOptions {
+	so.transform = transform
+	return so
+}
+
+// WithRate sets the max number of messages moved per second on the ShovelOptions. 0 does not limit.
+func (so *ShovelOptions) WithRate(perSecond float64) *ShovelOptions {
+	so.rate = perSecond
+	return so
+}
+
+// WithPrefetch sets the number of deliveries held unacknowledged on the ShovelOptions,
+// which is also the max number of messages awaiting their confirm on the destination.
+func (so *ShovelOptions) WithPrefetch(prefetch int) *ShovelOptions {
+	so.prefetch = prefetch
+	return so
+}
+
+// WithRetryInterval sets the time waited before restarting after an error on the ShovelOptions,
+// e.g. while the source or the destination reconnects.
+func (so *ShovelOptions) WithRetryInterval(retryInterval time.Duration) *ShovelOptions {
+	so.retryInterval = retryInterval
+	return so
+}
+
+// ShovelStats represents the number of deliveries a Shovel handled.
+type ShovelStats struct {
+	// Moved is the number of deliveries published, confirmed and acked on the source.
+	Moved uint64
+	// Skipped is the number of deliveries acked without publishing, see ErrShovelSkip.
+	Skipped uint64
+	// Rejected is the number of deliveries the ShovelTransform failed on.
+	Rejected uint64
+	// Requeued is the number of deliveries requeued on the source as the destination nacked them.
+	Requeued uint64
+	// Returned is the number of deliveries rejected on the source as the destination could not route them.
+	Returned uint64
+}
+
+// Shovel moves messages from a queue on a source MyAMQP to an exchange on a destination MyAMQP.
+// A delivery is acked on the source only once the destination confirmed the message, so a message
+// is never lost but may be moved twice when a connection drops. Messages are published with the mandatory
+// flag, a delivery whose message the destination cannot route is rejected without requeue on the source,
+// e.g. to dead-letter it.
+type Shovel struct {
+	source      *MyAMQP
+	destination *MyAMQP
+	options     *ShovelOptions
+	logger      *slog.Logger
+
+	moved    atomic.Uint64
+	skipped  atomic.Uint64
+	rejected atomic.Uint64
+	requeued atomic.Uint64
+	returned atomic.Uint64
+}
+
+// NewShovel creates a new Shovel with the given ShovelOptions. The source and the destination can be
+// the same MyAMQP, and are run, and reconnected, by their own Run.
+func NewShovel(source, destination *MyAMQP, options *ShovelOptions) (*Shovel, error) {
+	if source == nil {
+		return nil, ErrSourceCannotBeNil
+	}
+
+	if destination == nil {
+		return nil, ErrDestinationCannotBeNil
+	}
+
+	if options == nil {
+		return nil, ErrOptionsCannotBeNil
+	}
+
+	if options.queueOpts == nil {
+		return nil, ErrQueueOptionsCannotBeNil
+	}
+
+	if options.exchangeOpts == nil {
+		return nil, ErrExchangeOptionsCannotBeNil
+	}
+
+	if err := options.queueOpts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Shovel{
+		source:      source,
+		destination: destination,
+		options:     options,
+		logger:      source.logger().With("queue", options.queueOpts.name, "exchange", options.exchangeOpts.name),
+	}, nil
+}
+
+// Stats returns the ShovelStats.
+func (sh *Shovel) Stats() ShovelStats {
+	return ShovelStats{
+		Moved:    sh.moved.Load(),
+		Skipped:  sh.skipped.Load(),
+		Rejected: sh.rejected.Load(),
+		Requeued: sh.requeued.Load(),
+		Returned: sh.returned.Load(),
+	}
+}
+
+// Run runs the Shovel until ctx is done. When the source or the destination is not connected or
+// its connection drops, the deliveries not moved yet are requeued and the Shovel restarts after the retry interval.
+func (sh *Shovel) Run(ctx context.Context) error {
+	for {
+		err := sh.run(ctx)
+		if ctx.Err() != nil {
+			sh.logger.Info("shovel stopped", "reason", ctx.Err())
+			return ctx.Err()
+		}
+		sh.logger.Warn("shovel interrupted", "error", err, "retry_interval", sh.options.retryInterval)
+
+		retry := time.NewTimer(sh.options.retryInterval)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			sh.logger.Info("shovel stopped", "reason", ctx.Err())
+			return ctx.Err()
+		case <-retry.C:
+		}
+	}
+}
+
+// inFlight is a delivery whose message awaits its confirm on the destination.
+type inFlight struct {
+	delivery   amqp091.Delivery
+	routingKey string
+	msg        amqp091.Publishing
+	confirm    *amqp091.DeferredConfirmation
+	returned   bool
+}
+
+// run moves messages until ctx is done or a channel closes. Closing the source channel
+// requeues the deliveries in flight.
+func (sh *Shovel) run(ctx context.Context) error {
+	srcConn, dstConn := sh.source.connection(), sh.destination.connection()
+	if srcConn == nil || srcConn.IsClosed() || dstConn == nil || dstConn.IsClosed() {
+		return ErrNotConnected
+	}
+
+	srcCh, err := srcConn.Channel()
+	if err != nil {
+		return err
+	}
+	defer srcCh.Close()
+	srcClosed := srcCh.NotifyClose(make(chan *amqp091.Error, 1))
+
+	dstCh, err := dstConn.Channel()
+	if err != nil {
+		return err
+	}
+	defer dstCh.Close()
+	dstClosed := dstCh.NotifyClose(make(chan *amqp091.Error, 1))
+
+	// The returns channel is unbuffered, so a return is received before the confirm of its message.
+	// It is drained once run returns, so closing the channels does not wait for a return to be received.
+	returns := dstCh.NotifyReturn(make(chan amqp091.Return))
+	defer func() {
+		go func() {
+			for range returns {
+			}
+		}()
+	}()
+
+	if err = srcCh.Qos(sh.options.prefetch, 0, false); err != nil {
+		return err
+	}
+
+	if err = declareQueue(sh.logger, srcCh, sh.options.queueOpts); err != nil {
+		return err
+	}
+
+	if err = dstCh.Confirm(false); err != nil {
+		return err
+	}
+
+	if err = declareExchange(sh.logger, dstCh, sh.options.exchangeOpts); err != nil {
+		return err
+	}
+
+	deliveries, err := srcCh.Consume(sh.options.queueOpts.name, sh.options.name, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	sh.logger.Info("shovel started")
+
+	limiter := newRateLimiter(sh.options.rate)
+	var pending []*inFlight
+
+	// held is a message waiting for the rate limiter, no delivery is received meanwhile.
+	var held *inFlight
+	var release <-chan time.Time
+	publish := func(f *inFlight) error {
+		f.confirm, err = dstCh.PublishWithDeferredConfirmWithContext(ctx, sh.options.exchangeOpts.name, f.routingKey, true, false, f.msg)
+		if err != nil {
+			sh.logger.Error("publish failed", "routing_key", f.routingKey, "error", err)
+			return err
+		}
+		pending = append(pending, f)
+		return nil
+	}
+
+	for {
+		var confirmed <-chan struct{}
+		if len(pending) > 0 {
+			confirmed = pending[0].confirm.Done()
+		}
+		incoming := deliveries
+		if held != nil {
+			incoming = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-srcClosed:
+			return fmt.Errorf("%w: %v", ErrShovelChannelClosed, err)
+		case err := <-dstClosed:
+			return fmt.Errorf("%w: %v", ErrShovelChannelClosed, err)
+		case r := <-returns:
+			sh.markReturned(pending, r)
+		case <-confirmed:
+			if err = sh.settle(pending[0]); err != nil {
+				return err
+			}
+			pending = pending[1:]
+		case <-release:
+			if err = publish(held); err != nil {
+				return err
+			}
+			held, release = nil, nil
+		case d, ok := <-incoming:
+			if !ok {
+				return ErrShovelConsumerClosed
+			}
+
+			f := &inFlight{delivery: d, routingKey: d.RoutingKey, msg: publishingFromDelivery(d)}
+			if sh.options.transform != nil {
+				f.routingKey, f.msg, err = sh.options.transform(d)
+				if err != nil {
+					if err = sh.drop(d, err); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+
+			if delay := limiter.reserve(); delay > 0 {
+				held, release = f, time.After(delay)
+				continue
+			}
+			if err = publish(f); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// markReturned marks the message in flight returned by the destination. Returns come in publish order,
+// and a return is received before the confirm of its message, so it is the oldest message not yet returned
+// with the same routing key and content. An identical message published earlier is routed the same, so which one is marked does not matter.
+func (sh *Shovel) markReturned(pending []*inFlight, r amqp091.Return) {
+	for _, f := range pending {
+		if f.returned || f.routingKey != r.RoutingKey || f.msg.MessageId != r.MessageId ||
+			f.msg.CorrelationId != r.CorrelationId || !bytes.Equal(f.msg.Body, r.Body) {
+			continue
+		}
+		f.returned = true
+		return
+	}
+
+	sh.logger.Warn("unmatched return from destination", "routing_key", r.RoutingKey)
+}
+
+// settle acks the delivery on the source once the destination confirmed its message, or requeues it
+// when the message is nacked, or rejects it when the message is returned.
+func (sh *Shovel) settle(f *inFlight) error {
+	if f.returned {
+		sh.logger.Warn("message returned by destination, rejecting", "routing_key", f.routingKey)
+		sh.returned.Add(1)
+		return f.delivery.Reject(false)
+	}
+
+	if f.confirm.Acked() {
+		sh.moved.Add(1)
+		return f.delivery.Ack(false)
+	}
+
+	sh.logger.Warn("message nacked by destination, requeueing", "routing_key", f.delivery.RoutingKey)
+	sh.requeued.Add(1)
+
+	return f.delivery.Nack(false, true)
+}
+
+// drop acks a skipped delivery, or rejects it when the ShovelTransform failed.
+func (sh *Shovel) drop(d amqp091.Delivery, err error) error {
+	if errors.Is(err, ErrShovelSkip) {
+		sh.skipped.Add(1)
+		return d.Ack(false)
+	}
+
+	sh.logger.Error("transform failed, rejecting", "routing_key", d.RoutingKey, "error", err)
+	sh.rejected.Add(1)
+
+	return d.Reject(false)
+}
@@ -0,0 +1,180 @@
+package myamqp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp"
+	"github.com/dmasior/myamqp/fakebroker"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var errBadMessage = errors.New("bad message")
+
+// newShovelBroker returns a broker with the source queue, dead-lettering to the dead queue,
+// and the destination exchange routing the moved routing key to the moved queue.
+func newShovelBroker(t *testing.T) *fakebroker.Broker {
+	t.Helper()
+
+	broker := newBroker(t)
+	broker.DeclareExchange("dlx", amqp091.ExchangeFanout)
+	broker.DeclareExchange("destination", amqp091.ExchangeDirect)
+	for _, q := range []struct {
+		name, exchange, routingKey string
+		args                       amqp091.Table
+	}{
+		{name: "source", args: amqp091.Table{"x-dead-letter-exchange": "dlx"}},
+		{name: "dead", exchange: "dlx"},
+		{name: "moved", exchange: "destination", routingKey: "moved"},
+	} {
+		if err := broker.DeclareQueue(q.name, q.exchange, q.routingKey, q.args); err != nil {
+			t.Fatalf("DeclareQueue %s: %v", q.name, err)
+		}
+	}
+	return broker
+}
+
+// runShovel runs a Shovel from the source queue to the destination exchange until the end of the test.
+func runShovel(t *testing.T, broker *fakebroker.Broker, configure func(*myamqp.ShovelOptions) *myamqp.ShovelOptions) *myamqp.Shovel {
+	t.Helper()
+
+	amqp := connect(t, broker)
+	options := myamqp.NewShovelOptions("shovel",
+		myamqp.NewQueueOptions("source").WithDeclareMode(myamqp.DeclareModePassive),
+		myamqp.NewExchangeOptions("destination", myamqp.ExchangeTypeDirect).WithDeclareMode(myamqp.DeclareModePassive),
+	).WithRetryInterval(10 * time.Millisecond)
+	if configure != nil {
+		options = configure(options)
+	}
+
+	shovel, err := myamqp.NewShovel(amqp, amqp, options)
+	if err != nil {
+		t.Fatalf("NewShovel: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- shovel.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("Run: got error %v, want %v", err, context.Canceled)
+		}
+	})
+
+	return shovel
+}
+
+// publishSource publishes a message of the type with the routing key to the source queue.
+func publishSource(t *testing.T, broker *fakebroker.Broker, routingKey, typ string) {
+	t.Helper()
+
+	err := broker.Publish("", "source", amqp091.Publishing{Type: typ, Headers: amqp091.Table{"routing_key": routingKey}, Body: []byte(typ)})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+// routeByHeader routes the messages by their routing_key header, skips the skip type and fails on the bad type.
+func routeByHeader(d amqp091.Delivery) (string, amqp091.Publishing, error) {
+	switch d.Type {
+	case "skip":
+		return "", amqp091.Publishing{}, myamqp.ErrShovelSkip
+	case "bad":
+		return "", amqp091.Publishing{}, errBadMessage
+	}
+	routingKey, _ := d.Headers["routing_key"].(string)
+	return routingKey, amqp091.Publishing{Type: d.Type, Body: d.Body}, nil
+}
+
+func TestShovel(t *testing.T) {
+	broker := newShovelBroker(t)
+	shovel := runShovel(t, broker, func(o *myamqp.ShovelOptions) *myamqp.ShovelOptions {
+		return o.WithTransform(routeByHeader)
+	})
+
+	publishSource(t, broker, "moved", "first")
+	publishSource(t, broker, "", "skip")
+	publishSource(t, broker, "", "bad")
+	publishSource(t, broker, "unroutable", "returned")
+	publishSource(t, broker, "moved", "last")
+
+	want := myamqp.ShovelStats{Moved: 2, Skipped: 1, Rejected: 1, Returned: 1}
+	waitFor(t, "all messages to be handled", func() bool { return shovel.Stats() == want })
+
+	waitFor(t, "the source to be empty", func() bool {
+		info, _ := broker.Queue("source")
+		return info.Messages == 0 && info.Unacked == 0
+	})
+
+	tests := []struct {
+		queue string
+		want  []string
+	}{
+		{queue: "moved", want: []string{"first", "last"}},
+		// The rejected and the returned deliveries are dead-lettered by the source queue.
+		{queue: "dead", want: []string{"bad", "returned"}},
+	}
+	for _, tt := range tests {
+		messages := broker.Messages(tt.queue)
+		if len(messages) != len(tt.want) {
+			t.Fatalf("%s: got %d messages, want %v", tt.queue, len(messages), tt.want)
+		}
+		for i, m := range messages {
+			if string(m.Body) != tt.want[i] {
+				t.Errorf("%s: message %d: got %q, want %q", tt.queue, i, m.Body, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestShovelReturnedIdenticalMessages(t *testing.T) {
+	broker := newShovelBroker(t)
+	shovel := runShovel(t, broker, func(o *myamqp.ShovelOptions) *myamqp.ShovelOptions {
+		return o.WithTransform(routeByHeader)
+	})
+
+	for i := 0; i < 10; i++ {
+		publishSource(t, broker, "unroutable", "returned")
+		publishSource(t, broker, "moved", "moved")
+	}
+
+	want := myamqp.ShovelStats{Moved: 10, Returned: 10}
+	waitFor(t, "all messages to be handled", func() bool { return shovel.Stats() == want })
+	if got := len(broker.Messages("dead")); got != 10 {
+		t.Errorf("got %d dead-lettered messages, want 10", got)
+	}
+}
+
+func TestShovelRateLimitSettles(t *testing.T) {
+	broker := newShovelBroker(t)
+	shovel := runShovel(t, broker, func(o *myamqp.ShovelOptions) *myamqp.ShovelOptions {
+		return o.WithTransform(routeByHeader).WithRate(2)
+	})
+
+	publishSource(t, broker, "moved", "first")
+	publishSource(t, broker, "moved", "second")
+
+	// The first message is settled while the second one waits for the rate limiter.
+	waitFor(t, "the first message to be moved", func() bool { return shovel.Stats().Moved == 1 })
+	if info, _ := broker.Queue("source"); info.Unacked != 1 {
+		t.Errorf("got %d unacked source messages, want the one held by the rate limiter", info.Unacked)
+	}
+	waitFor(t, "the second message to be moved", func() bool { return shovel.Stats().Moved == 2 })
+}
+
+func TestShovelRestarts(t *testing.T) {
+	broker := newShovelBroker(t)
+	shovel := runShovel(t, broker, func(o *myamqp.ShovelOptions) *myamqp.ShovelOptions {
+		return o.WithTransform(routeByHeader)
+	})
+
+	publishSource(t, broker, "moved", "first")
+	waitFor(t, "the first message to be moved", func() bool { return shovel.Stats().Moved == 1 })
+
+	broker.CloseConnections()
+	publishSource(t, broker, "moved", "second")
+	waitFor(t, "the second message to be moved after the reconnect", func() bool { return shovel.Stats().Moved == 2 })
+}
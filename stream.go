@@ -0,0 +1,199 @@
+package myamqp
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrStreamAutoAck      = errors.New("stream consumer cannot use auto ack")
+	ErrStreamQueueType    = errors.New("stream consumer requires a stream queue type")
+	ErrStreamConsumerName = errors.New("stream consumer with offset store requires a consumer name")
+)
+
+const (
+	argStreamOffset = "x-stream-offset"
+
+	// DefaultStreamPrefetchCount is the prefetch count used by stream consumers unless set otherwise.
+	DefaultStreamPrefetchCount = 100
+)
+
+// StreamOffset represents the position in a stream from which a consumer starts.
+// The zero value starts from the next message, like StreamOffsetNext.
+type StreamOffset struct {
+	value interface{}
+}
+
+// StreamOffsetFirst starts from the first message available in the stream.
+func StreamOffsetFirst() StreamOffset {
+	return StreamOffset{value: "first"}
+}
+
+// StreamOffsetLast starts from the last written chunk of messages in the stream.
+func StreamOffsetLast() StreamOffset {
+	return StreamOffset{value: "last"}
+}
+
+// StreamOffsetNext starts from the next message written to the stream.
+func StreamOffsetNext() StreamOffset {
+	return StreamOffset{value: "next"}
+}
+
+// StreamOffsetAt starts from the given offset.
+func StreamOffsetAt(offset int64) StreamOffset {
+	return StreamOffset{value: offset}
+}
+
+// StreamOffsetTimestamp starts from the first chunk written at or after the given time.
+func StreamOffsetTimestamp(t time.Time) StreamOffset {
+	return StreamOffset{value: t}
+}
+
+// StreamOptions represents options for consuming from a stream queue.
+type StreamOptions struct {
+	offset        StreamOffset
+	prefetchCount int
+	offsetStore   OffsetStore
+}
+
+// NewStreamOptions creates a new StreamOptions starting from the next message
+// with DefaultStreamPrefetchCount.
+func NewStreamOptions() *StreamOptions {
+	return &StreamOptions{
+		offset:        StreamOffsetNext(),
+		prefetchCount: DefaultStreamPrefetchCount,
+	}
+}
+
+// WithOffset sets the StreamOffset on the StreamOptions.
+// It is used when the OffsetStore has no offset stored for the consumer yet.
+func (so *StreamOptions) WithOffset(offset StreamOffset) *StreamOptions {
+	so.offset = offset
+	return so
+}
+
+// WithPrefetchCount sets the prefetchCount on the StreamOptions.
+func (so *StreamOptions) WithPrefetchCount(prefetchCount int) *StreamOptions {
+	so.prefetchCount = prefetchCount
+	return so
+}
+
+// WithOffsetStore sets the OffsetStore on the StreamOptions.
+// The highest offset of the acked deliveries is stored under the consumer name,
+// and a new consumer with the same name resumes after the stored offset.
+func (so *StreamOptions) WithOffsetStore(store OffsetStore) *StreamOptions {
+	so.offsetStore = store
+	return so
+}
+
+func (so *StreamOptions) validate(options *ConsumerOptions) error {
+	if options.autoAck {
+		return ErrStreamAutoAck
+	}
+
+	queueOpts := options.queueOpts
+	if queueOpts.declareMode == DeclareModeDeclare && queueOpts.typedArgs.queueType != QueueTypeStream {
+		return ErrStreamQueueType
+	}
+
+	if so.offsetStore != nil && options.name == "" {
+		return ErrStreamConsumerName
+	}
+
+	return nil
+}
+
+// consumeArgs returns the consumer args with the x-stream-offset to start from.
+func (so *StreamOptions) consumeArgs(consumerName string, args amqp091.Table) (amqp091.Table, error) {
+	offset := so.offset.value
+	if offset == nil {
+		offset = StreamOffsetNext().value
+	}
+	if so.offsetStore != nil {
+		stored, ok, err := so.offsetStore.Load(consumerName)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			offset = stored + 1
+		}
+	}
+
+	consumeArgs := amqp091.Table{}
+	for k, v := range args {
+		consumeArgs[k] = v
+	}
+	consumeArgs[argStreamOffset] = offset
+
+	return consumeArgs, nil
+}
+
+// trackOffsets forwards the deliveries with an Acknowledger that stores the offset
+// of each acked delivery in the OffsetStore.
+func (so *StreamOptions) trackOffsets(consumerName string, deliveries <-chan amqp091.Delivery) <-chan amqp091.Delivery {
+	if so.offsetStore == nil {
+		return deliveries
+	}
+
+	tracker := &offsetTracker{store: so.offsetStore, consumerName: consumerName}
+	tracked := make(chan amqp091.Delivery)
+	go func() {
+		defer close(tracked)
+		for d := range deliveries {
+			if offset, ok := d.Headers[argStreamOffset].(int64); ok {
+				d.Acknowledger = &offsetAcknowledger{
+					Acknowledger: d.Acknowledger,
+					tracker:      tracker,
+					offset:       offset,
+				}
+			}
+			tracked <- d
+		}
+	}()
+
+	return tracked
+}
+
+// offsetTracker stores the highest acked offset of a consumer, so acks out of order
+// never move the stored offset backwards.
+type offsetTracker struct {
+	store        OffsetStore
+	consumerName string
+
+	mu     sync.Mutex
+	max    int64
+	hasMax bool
+}
+
+func (t *offsetTracker) storeOffset(offset int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.hasMax && offset <= t.max {
+		return nil
+	}
+
+	if err := t.store.Store(t.consumerName, offset); err != nil {
+		return err
+	}
+	t.max, t.hasMax = offset, true
+
+	return nil
+}
+
+type offsetAcknowledger struct {
+	amqp091.Acknowledger
+	tracker *offsetTracker
+	offset  int64
+}
+
+func (a *offsetAcknowledger) Ack(tag uint64, multiple bool) error {
+	if err := a.Acknowledger.Ack(tag, multiple); err != nil {
+		return err
+	}
+
+	return a.tracker.storeOffset(a.offset)
+}
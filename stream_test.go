@@ -0,0 +1,160 @@
+package myamqp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// nopAcknowledger is an amqp091.Acknowledger settling nothing.
+type nopAcknowledger struct{}
+
+func (nopAcknowledger) Ack(uint64, bool) error        { return nil }
+func (nopAcknowledger) Nack(uint64, bool, bool) error { return nil }
+func (nopAcknowledger) Reject(uint64, bool) error     { return nil }
+
+func TestStreamConsumeArgs(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	stored := NewMemoryOffsetStore()
+	if err := stored.Store("consumer", 41); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		options *StreamOptions
+		want    interface{}
+	}{
+		{name: "zero options start at next", options: &StreamOptions{}, want: "next"},
+		{name: "default", options: NewStreamOptions(), want: "next"},
+		{name: "first", options: NewStreamOptions().WithOffset(StreamOffsetFirst()), want: "first"},
+		{name: "last", options: NewStreamOptions().WithOffset(StreamOffsetLast()), want: "last"},
+		{name: "offset", options: NewStreamOptions().WithOffset(StreamOffsetAt(5)), want: int64(5)},
+		{name: "timestamp", options: NewStreamOptions().WithOffset(StreamOffsetTimestamp(at)), want: at},
+		{
+			name:    "empty store uses the offset",
+			options: NewStreamOptions().WithOffset(StreamOffsetFirst()).WithOffsetStore(NewMemoryOffsetStore()),
+			want:    "first",
+		},
+		{
+			name:    "stored offset resumes after it",
+			options: NewStreamOptions().WithOffset(StreamOffsetFirst()).WithOffsetStore(stored),
+			want:    int64(42),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := amqp091.Table{"x-priority": int32(1)}
+			got, err := tt.options.consumeArgs("consumer", args)
+			if err != nil {
+				t.Fatalf("consumeArgs: %v", err)
+			}
+
+			if !reflect.DeepEqual(got[argStreamOffset], tt.want) {
+				t.Errorf("offset: got %v, want %v", got[argStreamOffset], tt.want)
+			}
+			if got["x-priority"] != int32(1) {
+				t.Errorf("consumer args not kept: %v", got)
+			}
+			if _, ok := args[argStreamOffset]; ok {
+				t.Error("consumer args modified")
+			}
+		})
+	}
+}
+
+func TestStreamOptionsValidate(t *testing.T) {
+	streamQueue := NewQueueOptions("stream").WithDurable(true).WithQueueType(QueueTypeStream)
+
+	tests := []struct {
+		name    string
+		stream  *StreamOptions
+		options *ConsumerOptions
+		wantErr error
+	}{
+		{
+			name:    "valid",
+			stream:  NewStreamOptions().WithOffsetStore(NewMemoryOffsetStore()),
+			options: NewQueueConsumerOptions("consumer", streamQueue),
+		},
+		{
+			name:    "auto ack",
+			stream:  NewStreamOptions(),
+			options: NewQueueConsumerOptions("consumer", streamQueue).WithAutoAck(true),
+			wantErr: ErrStreamAutoAck,
+		},
+		{
+			name:    "declared classic queue",
+			stream:  NewStreamOptions(),
+			options: NewQueueConsumerOptions("consumer", NewQueueOptions("queue")),
+			wantErr: ErrStreamQueueType,
+		},
+		{
+			name:    "passive queue of unknown type",
+			stream:  NewStreamOptions(),
+			options: NewQueueConsumerOptions("consumer", NewQueueOptions("stream").WithDeclareMode(DeclareModePassive)),
+		},
+		{
+			name:    "offset store without consumer name",
+			stream:  NewStreamOptions().WithOffsetStore(NewMemoryOffsetStore()),
+			options: NewQueueConsumerOptions("", streamQueue),
+			wantErr: ErrStreamConsumerName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.stream.validate(tt.options); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validate: got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamTrackOffsets(t *testing.T) {
+	store := NewMemoryOffsetStore()
+	options := NewStreamOptions().WithOffsetStore(store)
+
+	deliveries := make(chan amqp091.Delivery, 4)
+	for i, offset := range []interface{}{int64(10), int64(11), int64(12), nil} {
+		d := amqp091.Delivery{Acknowledger: nopAcknowledger{}, DeliveryTag: uint64(i + 1)}
+		if offset != nil {
+			d.Headers = amqp091.Table{argStreamOffset: offset}
+		}
+		deliveries <- d
+	}
+	close(deliveries)
+
+	var tracked []amqp091.Delivery
+	for d := range options.trackOffsets("consumer", deliveries) {
+		tracked = append(tracked, d)
+	}
+	if len(tracked) != 4 {
+		t.Fatalf("got %d deliveries, want 4", len(tracked))
+	}
+
+	steps := []struct {
+		name   string
+		settle func() error
+		want   int64
+		stored bool
+	}{
+		{name: "nack does not store", settle: func() error { return tracked[1].Nack(false, true) }},
+		{name: "ack stores", settle: func() error { return tracked[2].Ack(false) }, want: 12, stored: true},
+		{name: "lower ack does not move back", settle: func() error { return tracked[0].Ack(false) }, want: 12, stored: true},
+		{name: "delivery without offset", settle: func() error { return tracked[3].Ack(false) }, want: 12, stored: true},
+	}
+	for _, step := range steps {
+		if err := step.settle(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		got, ok, err := store.Load("consumer")
+		if err != nil || ok != step.stored || got != step.want {
+			t.Errorf("%s: got offset %d, stored %v, error %v, want %d", step.name, got, ok, err, step.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package myamqp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrInvalidTLSOptions = errors.New("invalid TLS options")
+	ErrTLSRequiresURL    = errors.New("TLS options require a Config created with NewConfigFromURL or NewConfigFromEnv")
+)
+
+// TLSOptions represents the TLS settings of a connection. The certificate files are read on each
+// connection attempt, so certificates rotated on disk are used on the next reconnect without a restart.
+type TLSOptions struct {
+	caCertFile   string
+	certFile     string
+	keyFile      string
+	serverName   string
+	externalAuth bool
+}
+
+// NewTLSOptions creates a new TLSOptions verifying the AMQP server with the system certificate pool.
+func NewTLSOptions() *TLSOptions {
+	return &TLSOptions{}
+}
+
+// WithCACertFile sets the PEM file of the CA certificates verifying the AMQP server on the TLSOptions.
+// The file can be a bundle of several certificates.
+func (o *TLSOptions) WithCACertFile(caCertFile string) *TLSOptions {
+	o.caCertFile = caCertFile
+	return o
+}
+
+// WithClientCertFile sets the PEM files of the client certificate and its key on the TLSOptions, for mutual TLS.
+func (o *TLSOptions) WithClientCertFile(certFile, keyFile string) *TLSOptions {
+	o.certFile = certFile
+	o.keyFile = keyFile
+	return o
+}
+
+// WithServerName sets the server name verified on the certificate of the AMQP server on the TLSOptions.
+// Defaults to the host of the URL.
+func (o *TLSOptions) WithServerName(serverName string) *TLSOptions {
+	o.serverName = serverName
+	return o
+}
+
+// WithExternalAuth sets the external auth on the TLSOptions. When true, the connection authenticates
+// with SASL EXTERNAL, i.e. with the client certificate instead of the username and password of the URL.
+func (o *TLSOptions) WithExternalAuth(externalAuth bool) *TLSOptions {
+	o.externalAuth = externalAuth
+	return o
+}
+
+// TLSConfig reads the certificate files and returns the tls.Config.
+func (o *TLSOptions) TLSConfig() (*tls.Config, error) {
+	if (o.certFile == "") != (o.keyFile == "") {
+		return nil, fmt.Errorf("%w: client certificate requires both a certificate and a key file", ErrInvalidTLSOptions)
+	}
+
+	if o.externalAuth && o.certFile == "" {
+		return nil, fmt.Errorf("%w: external auth requires a client certificate", ErrInvalidTLSOptions)
+	}
+
+	tlsConfig := &tls.Config{ServerName: o.serverName}
+
+	if o.caCertFile != "" {
+		pem, err := os.ReadFile(o.caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: CA certificate: %v", ErrInvalidTLSOptions, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: CA certificate: no PEM certificate in %s", ErrInvalidTLSOptions, o.caCertFile)
+		}
+	}
+
+	if o.certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: client certificate: %v", ErrInvalidTLSOptions, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// DialFunc returns a DialFunc dialing the amqps URL with the config and the TLSOptions,
+// reading the certificate files on each call. Use it with NewConfig, a Config created with
+// NewConfigFromURL or NewConfigFromEnv takes the TLSOptions with WithTLS instead.
+func (o *TLSOptions) DialFunc(url string, config amqp091.Config) DialFunc {
+	return func() (*amqp091.Connection, error) {
+		return o.dial(url, config)
+	}
+}
+
+// validate checks the TLSOptions of a Config against its URL, and that the certificate files can be read.
+func (o *TLSOptions) validate(url string) error {
+	if url == "" {
+		return ErrTLSRequiresURL
+	}
+
+	uri, err := amqp091.ParseURI(url)
+	if err != nil {
+		return err
+	}
+
+	if uri.Scheme != "amqps" {
+		return fmt.Errorf("%w: requires an amqps URL", ErrInvalidTLSOptions)
+	}
+
+	_, err = o.TLSConfig()
+
+	return err
+}
+
+func (o *TLSOptions) dial(url string, config amqp091.Config) (*amqp091.Connection, error) {
+	uri, err := amqp091.ParseURI(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if uri.Scheme != "amqps" {
+		return nil, fmt.Errorf("%w: requires an amqps URL", ErrInvalidTLSOptions)
+	}
+
+	if config.TLSClientConfig, err = o.TLSConfig(); err != nil {
+		return nil, err
+	}
+
+	if config.TLSClientConfig.ServerName == "" {
+		config.TLSClientConfig.ServerName = uri.Host
+	}
+
+	if config.Locale == "" {
+		config.Locale = defaultLocale
+	}
+
+	if o.externalAuth {
+		config.SASL = []amqp091.Authentication{&amqp091.ExternalAuth{}}
+	}
+
+	return amqp091.DialConfig(url, config)
+}
@@ -0,0 +1,161 @@
+package myamqp_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dmasior/myamqp"
+)
+
+// writeCertificate writes a new self-signed certificate and its key as PEM files, and returns the DER of the certificate.
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	return der
+}
+
+func TestTLSConfigReadsRotatedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	caFile, caKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+
+	opts := myamqp.NewTLSOptions().
+		WithCACertFile(caFile).
+		WithClientCertFile(certFile, keyFile).
+		WithServerName("rabbit").
+		WithExternalAuth(true)
+
+	for _, name := range []string{"first", "rotated"} {
+		cert := writeCertificate(t, certFile, keyFile, name)
+		ca := writeCertificate(t, caFile, caKeyFile, name+" CA")
+
+		tlsConfig, err := opts.TLSConfig()
+		if err != nil {
+			t.Fatalf("%s: TLSConfig: %v", name, err)
+		}
+
+		if tlsConfig.ServerName != "rabbit" {
+			t.Errorf("%s: got server name %q, want rabbit", name, tlsConfig.ServerName)
+		}
+		if len(tlsConfig.Certificates) != 1 || !bytes.Equal(tlsConfig.Certificates[0].Certificate[0], cert) {
+			t.Errorf("%s: the client certificate is not the one on disk", name)
+		}
+
+		caCert, err := x509.ParseCertificate(ca)
+		if err != nil {
+			t.Fatalf("ParseCertificate: %v", err)
+		}
+		want := x509.NewCertPool()
+		want.AddCert(caCert)
+		if tlsConfig.RootCAs == nil || !tlsConfig.RootCAs.Equal(want) {
+			t.Errorf("%s: the CA certificates are not the ones on disk", name)
+		}
+	}
+}
+
+func TestTLSConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	writeCertificate(t, certFile, keyFile, "client")
+	notPEM := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts *myamqp.TLSOptions
+	}{
+		{name: "certificate without key", opts: myamqp.NewTLSOptions().WithClientCertFile(certFile, "")},
+		{name: "key without certificate", opts: myamqp.NewTLSOptions().WithClientCertFile("", keyFile)},
+		{name: "external auth without certificate", opts: myamqp.NewTLSOptions().WithExternalAuth(true)},
+		{name: "missing CA file", opts: myamqp.NewTLSOptions().WithCACertFile(filepath.Join(dir, "missing.pem"))},
+		{name: "CA file without certificate", opts: myamqp.NewTLSOptions().WithCACertFile(notPEM)},
+		{name: "mismatched key", opts: myamqp.NewTLSOptions().WithClientCertFile(certFile, notPEM)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.opts.TLSConfig(); !errors.Is(err, myamqp.ErrInvalidTLSOptions) {
+				t.Errorf("got error %v, want %v", err, myamqp.ErrInvalidTLSOptions)
+			}
+		})
+	}
+}
+
+func TestWithTLSRequiresURL(t *testing.T) {
+	config, err := myamqp.NewConfig(newBroker(t).Dial)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+
+	_, err = myamqp.New(config.WithTLS(myamqp.NewTLSOptions()))
+	if !errors.Is(err, myamqp.ErrTLSRequiresURL) {
+		t.Errorf("New: got error %v, want %v", err, myamqp.ErrTLSRequiresURL)
+	}
+}
+
+func TestNewConfigFromURLWithTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	writeCertificate(t, certFile, keyFile, "client")
+
+	config, err := myamqp.NewConfigFromURL("amqps://rabbit?cacertfile=" + certFile + "&certfile=" + certFile +
+		"&keyfile=" + keyFile + "&auth_mechanism=external")
+	if err != nil {
+		t.Fatalf("NewConfigFromURL: %v", err)
+	}
+	if config.TLS() == nil {
+		t.Fatal("no TLS options")
+	}
+
+	// The Config keeps the file names, so a certificate rotated after NewConfigFromURL is used.
+	rotated := writeCertificate(t, certFile, keyFile, "rotated")
+	tlsConfig, err := config.TLS().TLSConfig()
+	if err != nil {
+		t.Fatalf("TLSConfig: %v", err)
+	}
+	if len(tlsConfig.Certificates) != 1 || !bytes.Equal(tlsConfig.Certificates[0].Certificate[0], rotated) {
+		t.Error("the client certificate is not the rotated one")
+	}
+}
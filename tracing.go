@@ -0,0 +1,237 @@
+package myamqp
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/dmasior/myamqp"
+
+// Tracing represents options for OpenTelemetry tracing.
+// Producers inject the span context into the Publishing headers, consumers extract it as the parent
+// of the span of each delivery. Spans follow the OpenTelemetry messaging semantic conventions.
+type Tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracing creates a new Tracing with the given TracerProvider, using the W3C trace context
+// and baggage propagators. When tp is nil, the global TracerProvider is used.
+func NewTracing(tp trace.TracerProvider) *Tracing {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return &Tracing{
+		tracer:     tp.Tracer(tracerName),
+		propagator: defaultPropagator(),
+	}
+}
+
+func defaultPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// WithPropagator sets the TextMapPropagator on the Tracing.
+func (t *Tracing) WithPropagator(propagator propagation.TextMapPropagator) *Tracing {
+	t.propagator = propagator
+	return t
+}
+
+// HeadersCarrier adapts amqp091.Table to a propagation.TextMapCarrier.
+type HeadersCarrier amqp091.Table
+
+// Get returns the string value of the header.
+func (c HeadersCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+// Set sets the header.
+func (c HeadersCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys returns the header names.
+func (c HeadersCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// DeliveryContext returns the context of the delivery. For deliveries of a traced consumer it carries
+// the consumer span, otherwise it carries the span context propagated in the headers, if any.
+func DeliveryContext(d amqp091.Delivery) context.Context {
+	if ack, ok := d.Acknowledger.(*tracingAcknowledger); ok {
+		return ack.ctx
+	}
+
+	return defaultPropagator().Extract(context.Background(), HeadersCarrier(d.Headers))
+}
+
+// startPublish starts the producer span and injects its context into a copy of the message headers.
+func (t *Tracing) startPublish(ctx context.Context, exchange, routingKey string, msg amqp091.Publishing) (context.Context, trace.Span, amqp091.Publishing) {
+	ctx, span := t.tracer.Start(ctx, spanName(exchange, "publish"),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.operation.type", "send"),
+			attribute.String("messaging.operation.name", "publish"),
+			attribute.String("messaging.destination.name", exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+			attribute.Int("messaging.message.body.size", len(msg.Body)),
+		),
+	)
+	if msg.MessageId != "" {
+		span.SetAttributes(attribute.String("messaging.message.id", msg.MessageId))
+	}
+	if msg.CorrelationId != "" {
+		span.SetAttributes(attribute.String("messaging.message.conversation_id", msg.CorrelationId))
+	}
+
+	headers := amqp091.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	t.propagator.Inject(ctx, HeadersCarrier(headers))
+	msg.Headers = headers
+
+	return ctx, span, msg
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceDeliveries forwards the deliveries with a consumer span started for each of them.
+// The span ends when the delivery is acked, nacked or rejected, or right away with autoAck.
+func (t *Tracing) traceDeliveries(queue string, deliveries <-chan amqp091.Delivery, autoAck bool) <-chan amqp091.Delivery {
+	tracker := &spanTracker{spans: make(map[uint64]trace.Span)}
+	traced := make(chan amqp091.Delivery)
+	go func() {
+		defer close(traced)
+		for d := range deliveries {
+			parent := t.propagator.Extract(context.Background(), HeadersCarrier(d.Headers))
+			ctx, span := t.tracer.Start(parent, spanName(queue, "process"),
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(
+					attribute.String("messaging.system", "rabbitmq"),
+					attribute.String("messaging.operation.type", "process"),
+					attribute.String("messaging.operation.name", "consume"),
+					attribute.String("messaging.destination.name", d.Exchange),
+					attribute.String("messaging.rabbitmq.destination.routing_key", d.RoutingKey),
+					attribute.String("messaging.consumer.group.name", queue),
+					attribute.String("messaging.rabbitmq.message.delivery_tag", strconv.FormatUint(d.DeliveryTag, 10)),
+					attribute.Int("messaging.message.body.size", len(d.Body)),
+				),
+			)
+			if d.MessageId != "" {
+				span.SetAttributes(attribute.String("messaging.message.id", d.MessageId))
+			}
+			if d.CorrelationId != "" {
+				span.SetAttributes(attribute.String("messaging.message.conversation_id", d.CorrelationId))
+			}
+
+			if !autoAck {
+				tracker.track(d.DeliveryTag, span)
+			}
+			d.Acknowledger = &tracingAcknowledger{
+				Acknowledger: d.Acknowledger,
+				ctx:          ctx,
+				tracker:      tracker,
+			}
+
+			traced <- d
+
+			if autoAck {
+				span.End()
+			}
+		}
+	}()
+
+	return traced
+}
+
+func spanName(destination, operation string) string {
+	if destination == "" {
+		destination = "(default)"
+	}
+	return destination + " " + operation
+}
+
+// spanTracker keeps the consumer spans of the unsettled deliveries of a channel by delivery tag,
+// so a multiple ack, nack or reject ends the span of every delivery it settles.
+type spanTracker struct {
+	mu    sync.Mutex
+	spans map[uint64]trace.Span
+}
+
+func (t *spanTracker) track(tag uint64, span trace.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans[tag] = span
+}
+
+// settle forgets the spans of the deliveries settled by the tag and returns them.
+func (t *spanTracker) settle(tag uint64, multiple bool) []trace.Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var settled []trace.Span
+	for delivered, span := range t.spans {
+		if delivered == tag || (multiple && delivered < tag) {
+			settled = append(settled, span)
+			delete(t.spans, delivered)
+		}
+	}
+
+	return settled
+}
+
+type tracingAcknowledger struct {
+	amqp091.Acknowledger
+	ctx     context.Context
+	tracker *spanTracker
+}
+
+func (a *tracingAcknowledger) Ack(tag uint64, multiple bool) error {
+	err := a.Acknowledger.Ack(tag, multiple)
+	for _, span := range a.tracker.settle(tag, multiple) {
+		endSpan(span, err)
+	}
+	return err
+}
+
+func (a *tracingAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	err := a.Acknowledger.Nack(tag, multiple, requeue)
+	for _, span := range a.tracker.settle(tag, multiple) {
+		span.SetAttributes(attribute.Bool("messaging.rabbitmq.requeue", requeue))
+		span.SetStatus(codes.Error, "nacked")
+		endSpan(span, err)
+	}
+	return err
+}
+
+func (a *tracingAcknowledger) Reject(tag uint64, requeue bool) error {
+	err := a.Acknowledger.Reject(tag, requeue)
+	for _, span := range a.tracker.settle(tag, false) {
+		span.SetAttributes(attribute.Bool("messaging.rabbitmq.requeue", requeue))
+		span.SetStatus(codes.Error, "rejected")
+		endSpan(span, err)
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package myamqp_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dmasior/myamqp"
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// connectTraced connects with a Tracing recording the ended spans in the returned exporter.
+func connectTraced(t *testing.T) (*myamqp.MyAMQP, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	amqp := connect(t, newBroker(t), func(c *myamqp.Config) *myamqp.Config {
+		return c.WithTracing(myamqp.NewTracing(tp))
+	})
+	return amqp, exporter
+}
+
+// endedSpans returns the ended spans of the kind.
+func endedSpans(exporter *tracetest.InMemoryExporter, kind trace.SpanKind) tracetest.SpanStubs {
+	var spans tracetest.SpanStubs
+	for _, span := range exporter.GetSpans() {
+		if span.SpanKind == kind {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// publishTraced publishes the bodies to the orders queue with a traced producer.
+func publishTraced(t *testing.T, amqp *myamqp.MyAMQP, bodies ...string) {
+	t.Helper()
+
+	producer, err := amqp.Producer(myamqp.NewProducerOptions(defaultExchange()).
+		WithQueueOptions(myamqp.NewQueueOptions("orders").WithSkipBind(true)))
+	if err != nil {
+		t.Fatalf("Producer: %v", err)
+	}
+	defer producer.Close()
+
+	for _, body := range bodies {
+		if err = producer.Publish(context.Background(), "orders", false, false, amqp091.Publishing{Body: []byte(body)}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+func TestTracingPropagation(t *testing.T) {
+	amqp, exporter := connectTraced(t)
+	publishTraced(t, amqp, "order")
+
+	producerSpans := endedSpans(exporter, trace.SpanKindProducer)
+	if len(producerSpans) != 1 || producerSpans[0].Name != "(default) publish" {
+		t.Fatalf("got producer spans %v", producerSpans)
+	}
+
+	d := receive(t, consume(t, amqp, myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders"))))
+	if _, ok := d.Headers["traceparent"]; !ok {
+		t.Errorf("traceparent not propagated: %v", d.Headers)
+	}
+	consumerCtx := trace.SpanContextFromContext(myamqp.DeliveryContext(d))
+	if consumerCtx.TraceID() != producerSpans[0].SpanContext.TraceID() {
+		t.Errorf("consumer span trace %s, want %s", consumerCtx.TraceID(), producerSpans[0].SpanContext.TraceID())
+	}
+	if spans := endedSpans(exporter, trace.SpanKindConsumer); len(spans) != 0 {
+		t.Fatalf("consumer span ended before the ack: %v", spans)
+	}
+
+	d.Ack(false)
+	consumerSpans := endedSpans(exporter, trace.SpanKindConsumer)
+	if len(consumerSpans) != 1 {
+		t.Fatalf("got %d ended consumer spans, want 1", len(consumerSpans))
+	}
+	span := consumerSpans[0]
+	if span.Name != "orders process" || span.Parent.SpanID() != producerSpans[0].SpanContext.SpanID() ||
+		span.SpanContext.SpanID() != consumerCtx.SpanID() || span.Status.Code == codes.Error {
+		t.Errorf("unexpected consumer span %+v", span)
+	}
+}
+
+func TestTracingEndsSettledSpans(t *testing.T) {
+	tests := []struct {
+		name        string
+		settle      func(d amqp091.Delivery) error
+		wantStatus  codes.Code
+		wantRequeue bool
+	}{
+		{name: "ack multiple", settle: func(d amqp091.Delivery) error { return d.Ack(true) }},
+		{name: "nack multiple", settle: func(d amqp091.Delivery) error { return d.Nack(true, false) }, wantStatus: codes.Error},
+		{name: "nack multiple with requeue", settle: func(d amqp091.Delivery) error { return d.Nack(true, true) }, wantStatus: codes.Error, wantRequeue: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amqp, exporter := connectTraced(t)
+			publishTraced(t, amqp, "1", "2", "3")
+			deliveries := consume(t, amqp, myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")))
+			receive(t, deliveries)
+			receive(t, deliveries)
+			last := receive(t, deliveries)
+
+			if err := tt.settle(last); err != nil {
+				t.Fatalf("settle: %v", err)
+			}
+
+			spans := endedSpans(exporter, trace.SpanKindConsumer)
+			if len(spans) != 3 {
+				t.Fatalf("got %d ended consumer spans, want the 3 settled deliveries", len(spans))
+			}
+			for _, span := range spans {
+				if span.Status.Code != tt.wantStatus {
+					t.Errorf("span %s: got status %v, want %v", span.SpanContext.SpanID(), span.Status.Code, tt.wantStatus)
+				}
+				if requeue, ok := spanAttribute(span, "messaging.rabbitmq.requeue"); tt.wantStatus == codes.Error && (!ok || requeue.AsBool() != tt.wantRequeue) {
+					t.Errorf("span %s: got requeue %v, want %v", span.SpanContext.SpanID(), requeue.AsBool(), tt.wantRequeue)
+				}
+			}
+
+			// Settling again ends no span twice.
+			last.Ack(false)
+			if got := len(endedSpans(exporter, trace.SpanKindConsumer)); got != 3 {
+				t.Errorf("got %d ended consumer spans after settling again, want 3", got)
+			}
+		})
+	}
+}
+
+func TestTracingAutoAck(t *testing.T) {
+	amqp, exporter := connectTraced(t)
+	publishTraced(t, amqp, "order")
+
+	receive(t, consume(t, amqp, myamqp.NewQueueConsumerOptions("orders", myamqp.NewQueueOptions("orders")).WithAutoAck(true)))
+	waitFor(t, "the consumer span to end", func() bool { return len(endedSpans(exporter, trace.SpanKindConsumer)) == 1 })
+}
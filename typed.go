@@ -0,0 +1,132 @@
+package myamqp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// TypedProducer represents a producer publishing values of type T encoded with a Codec.
+type TypedProducer[T any] struct {
+	producer Publisher
+	codec    Codec
+}
+
+// NewTypedProducer creates a new TypedProducer with the given Publisher, usually a Producer, and Codec.
+func NewTypedProducer[T any](producer Publisher, codec Codec) *TypedProducer[T] {
+	return &TypedProducer[T]{
+		producer: producer,
+		codec:    codec,
+	}
+}
+
+// Encode returns a Publishing with the encoded message as Body, and the ContentType and the ContentEncoding
+// of the Codec, see ContentEncodingCodec. It can be used to set additional properties before publishing
+// through the underlying Producer.
+func (p *TypedProducer[T]) Encode(msg T) (amqp091.Publishing, error) {
+	body, err := p.codec.Marshal(msg)
+	if err != nil {
+		return amqp091.Publishing{}, err
+	}
+
+	return amqp091.Publishing{
+		ContentType:     p.codec.ContentType(),
+		ContentEncoding: codecContentEncoding(p.codec),
+		Body:            body,
+	}, nil
+}
+
+// Publish encodes the message and publishes it to the AMQP server.
+func (p *TypedProducer[T]) Publish(ctx context.Context, routingKey string, mandatory, immediate bool, msg T) error {
+	publishing, err := p.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	return p.producer.Publish(ctx, routingKey, mandatory, immediate, publishing)
+}
+
+// PublishWithDeferredConfirm encodes the message, publishes it to the AMQP server and returns a DeferredConfirmation.
+func (p *TypedProducer[T]) PublishWithDeferredConfirm(ctx context.Context, routingKey string, mandatory, immediate bool, msg T) (*amqp091.DeferredConfirmation, error) {
+	publishing, err := p.Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.producer.PublishWithDeferredConfirm(ctx, routingKey, mandatory, immediate, publishing)
+}
+
+// TypedDelivery represents a delivery with its decoded message.
+type TypedDelivery[T any] struct {
+	amqp091.Delivery
+	Message T
+}
+
+// TypedHandleFunc is a function that handles incoming typed deliveries.
+type TypedHandleFunc[T any] func(deliveries <-chan TypedDelivery[T], done chan error)
+
+// TypedConsumer represents a consumer decoding deliveries into values of type T.
+type TypedConsumer[T any] struct {
+	*Consumer
+}
+
+// NewTypedConsumer creates a new TypedConsumer on the MyAMQP with the given ConsumerOptions, Codecs and TypedHandleFunc.
+// The Codec is chosen by the ContentType of each delivery. When codecs is nil, DefaultCodecs is used.
+// Deliveries which cannot be decoded are logged and rejected without requeue, and never reach the handler.
+func NewTypedConsumer[T any](s *MyAMQP, options *ConsumerOptions, codecs *Codecs, handler TypedHandleFunc[T]) (*TypedConsumer[T], error) {
+	if codecs == nil {
+		codecs = DefaultCodecs()
+	}
+
+	logger := s.logger()
+	if options != nil {
+		logger = logger.With("consumer", options.name)
+	}
+
+	consumer, err := s.Consumer(options, func(deliveries <-chan amqp091.Delivery, done chan error) {
+		typed := make(chan TypedDelivery[T])
+		go handler(typed, done)
+
+		for d := range deliveries {
+			msg, err := decodeDelivery[T](codecs, d)
+			if err != nil {
+				logger.Warn("delivery decoding failed, rejecting", "content_type", d.ContentType, "content_encoding", d.ContentEncoding, "error", err)
+				if !options.autoAck {
+					d.Reject(false)
+				}
+				continue
+			}
+			typed <- TypedDelivery[T]{Delivery: d, Message: msg}
+		}
+
+		close(typed)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedConsumer[T]{Consumer: consumer}, nil
+}
+
+func decodeDelivery[T any](codecs *Codecs, d amqp091.Delivery) (T, error) {
+	var msg T
+	codec, err := codecs.Lookup(d.ContentType)
+	if err != nil {
+		return msg, err
+	}
+
+	// Deliveries decompressed by the Decompression of the consumer have no ContentEncoding anymore.
+	if d.ContentEncoding != codecContentEncoding(codec) {
+		return msg, fmt.Errorf("%w: %q", ErrUnknownContentEncoding, d.ContentEncoding)
+	}
+
+	// Pointer types, e.g. protobuf messages, are decoded into a newly allocated value.
+	if rt := reflect.TypeOf(msg); rt != nil && rt.Kind() == reflect.Pointer {
+		msg = reflect.New(rt.Elem()).Interface().(T)
+		return msg, codec.Unmarshal(d.Body, msg)
+	}
+
+	return msg, codec.Unmarshal(d.Body, &msg)
+}
@@ -0,0 +1,252 @@
+package myamqp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	ErrInvalidMessage   = errors.New("invalid message")
+	ErrDeadLetterNacked = errors.New("dead letter nacked")
+)
+
+const (
+	// AnyRoutingKey registers a Validator for all routing keys of an exchange.
+	AnyRoutingKey = "#"
+	// ValidationErrorHeader is the header carrying the validation error of a dead-lettered delivery.
+	ValidationErrorHeader = "x-validation-error"
+)
+
+// Validator validates a message body.
+type Validator interface {
+	Validate(body []byte) error
+}
+
+// JSONSchemaValidator is a Validator checking JSON bodies against a JSON Schema.
+type JSONSchemaValidator struct {
+	schema *jsonschema.Schema
+}
+
+// NewJSONSchemaValidator creates a new JSONSchemaValidator with the given JSON Schema document.
+func NewJSONSchemaValidator(schema []byte) (*JSONSchemaValidator, error) {
+	compiled, err := jsonschema.CompileString("schema.json", string(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONSchemaValidator{
+		schema: compiled,
+	}, nil
+}
+
+// Validate checks the body against the JSON Schema.
+func (v *JSONSchemaValidator) Validate(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+
+	return v.schema.Validate(doc)
+}
+
+type validationKey struct {
+	exchange   string
+	routingKey string
+}
+
+// MessageValidation holds Validators per exchange and routing key.
+type MessageValidation struct {
+	validators map[validationKey]Validator
+	deadLetter *DeadLetter
+}
+
+// NewMessageValidation creates a new MessageValidation without Validators.
+func NewMessageValidation() *MessageValidation {
+	return &MessageValidation{
+		validators: make(map[validationKey]Validator),
+	}
+}
+
+// WithValidator sets the Validator for messages published to the exchange with the routing key.
+// Use AnyRoutingKey to validate all messages of the exchange. A Validator set for the exact
+// routing key takes precedence.
+func (mv *MessageValidation) WithValidator(exchange, routingKey string, validator Validator) *MessageValidation {
+	mv.validators[validationKey{exchange: exchange, routingKey: routingKey}] = validator
+	return mv
+}
+
+// WithDeadLetter sets the DeadLetter on the MessageValidation.
+// It is used by consumers, which publish invalid deliveries to it with the ValidationErrorHeader set.
+// Without a DeadLetter, invalid deliveries are rejected without requeue.
+func (mv *MessageValidation) WithDeadLetter(deadLetter *DeadLetter) *MessageValidation {
+	mv.deadLetter = deadLetter
+	return mv
+}
+
+// Validate validates the body with the Validator of the exchange and routing key.
+// Messages without a matching Validator are valid.
+func (mv *MessageValidation) Validate(exchange, routingKey string, body []byte) error {
+	validator, ok := mv.validators[validationKey{exchange: exchange, routingKey: routingKey}]
+	if !ok {
+		validator, ok = mv.validators[validationKey{exchange: exchange, routingKey: AnyRoutingKey}]
+	}
+	if !ok {
+		return nil
+	}
+
+	if err := validator.Validate(body); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	return nil
+}
+
+// DeadLetter represents the destination of deliveries which failed validation.
+type DeadLetter struct {
+	exchange   string
+	routingKey string
+}
+
+// NewDeadLetter creates a new DeadLetter with the given exchange and routing key.
+// An empty routing key keeps the routing key of the delivery.
+func NewDeadLetter(exchange, routingKey string) *DeadLetter {
+	return &DeadLetter{
+		exchange:   exchange,
+		routingKey: routingKey,
+	}
+}
+
+// validateDeliveries forwards the valid deliveries. Invalid deliveries are published to the DeadLetter
+// and acked once the AMQP server confirmed them, or rejected without requeue when there is no DeadLetter
+// or the dead letter is not confirmed.
+// With autoAck, invalid deliveries are published to the DeadLetter, if any, and dropped.
+// Bodies resolved by a ClaimCheck are published without the ClaimCheckHeader, as acking deletes them.
+func (mv *MessageValidation) validateDeliveries(logger *slog.Logger, conn Connection, deliveries <-chan amqp091.Delivery, autoAck, claimChecked bool) <-chan amqp091.Delivery {
+	validated := make(chan amqp091.Delivery)
+	go func() {
+		defer close(validated)
+
+		publisher := &deadLetterPublisher{conn: conn}
+		defer publisher.close()
+
+		for d := range deliveries {
+			validationErr := mv.Validate(d.Exchange, d.RoutingKey, d.Body)
+			if validationErr == nil {
+				validated <- d
+				continue
+			}
+
+			if mv.deadLetter == nil {
+				logger.Warn("delivery validation failed, rejecting", "routing_key", d.RoutingKey, "error", validationErr)
+				if !autoAck {
+					d.Reject(false)
+				}
+				continue
+			}
+
+			msg := publishingFromDelivery(d)
+			msg.Headers[ValidationErrorHeader] = validationErr.Error()
+			if claimChecked {
+				delete(msg.Headers, ClaimCheckHeader)
+				delete(msg.Headers, ClaimCheckSizeHeader)
+			}
+			routingKey := mv.deadLetter.routingKey
+			if routingKey == "" {
+				routingKey = d.RoutingKey
+			}
+
+			logger.Warn("delivery validation failed, dead-lettering", "routing_key", d.RoutingKey, "dead_letter_exchange", mv.deadLetter.exchange, "error", validationErr)
+
+			err := publisher.publish(mv.deadLetter.exchange, routingKey, msg)
+			if err != nil {
+				logger.Error("dead letter publish failed, rejecting", "dead_letter_exchange", mv.deadLetter.exchange, "error", err)
+			}
+			if autoAck {
+				continue
+			}
+			// Requeuing would redeliver the invalid delivery to this consumer over and over.
+			if err != nil {
+				d.Reject(false)
+				continue
+			}
+			d.Ack(false)
+		}
+	}()
+
+	return validated
+}
+
+// deadLetterPublisher publishes dead-lettered deliveries on its own channel in confirm mode,
+// opened on the first publish and again after it closed.
+type deadLetterPublisher struct {
+	conn    Connection
+	channel Channel
+}
+
+// publish publishes the message and waits for its confirm.
+func (p *deadLetterPublisher) publish(exchange, routingKey string, msg amqp091.Publishing) error {
+	if p.channel == nil || p.channel.IsClosed() {
+		channel, err := p.conn.Channel()
+		if err != nil {
+			return err
+		}
+		if err = channel.Confirm(false); err != nil {
+			channel.Close()
+			return err
+		}
+		p.channel = channel
+	}
+
+	confirm, err := p.channel.PublishWithDeferredConfirmWithContext(context.Background(), exchange, routingKey, false, false, msg)
+	if err != nil {
+		return err
+	}
+
+	// The confirmation is resolved as nacked when the channel closes.
+	if !confirm.Wait() {
+		return ErrDeadLetterNacked
+	}
+
+	return nil
+}
+
+func (p *deadLetterPublisher) close() {
+	if p.channel != nil {
+		p.channel.Close()
+	}
+}
+
+// publishingFromDelivery returns a Publishing with the properties, headers and body of the delivery.
+// The UserId is not copied, as the AMQP server rejects a message whose UserId is not the user of the connection.
+func publishingFromDelivery(d amqp091.Delivery) amqp091.Publishing {
+	headers := amqp091.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+
+	return amqp091.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
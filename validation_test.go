@@ -0,0 +1,165 @@
+package myamqp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dmasior/myamqp"
+	"github.com/dmasior/myamqp/fakebroker"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const orderSchema = `{"type":"object","required":["id"],"properties":{"id":{"type":"integer"}}}`
+
+// newOrderValidation returns a MessageValidation requiring an id on the orders.created messages of the orders exchange.
+func newOrderValidation(t *testing.T) *myamqp.MessageValidation {
+	t.Helper()
+
+	validator, err := myamqp.NewJSONSchemaValidator([]byte(orderSchema))
+	if err != nil {
+		t.Fatalf("NewJSONSchemaValidator: %v", err)
+	}
+	return myamqp.NewMessageValidation().WithValidator("orders", "orders.created", validator)
+}
+
+// newOrdersBroker returns a broker with the orders exchange routing to the orders.created queue.
+func newOrdersBroker(t *testing.T) *fakebroker.Broker {
+	t.Helper()
+
+	broker := newBroker(t)
+	broker.DeclareExchange("orders", amqp091.ExchangeDirect)
+	if err := broker.DeclareQueue("orders.created", "orders", "orders.created", nil); err != nil {
+		t.Fatalf("DeclareQueue: %v", err)
+	}
+	return broker
+}
+
+func TestMessageValidationValidate(t *testing.T) {
+	validator, err := myamqp.NewJSONSchemaValidator([]byte(orderSchema))
+	if err != nil {
+		t.Fatalf("NewJSONSchemaValidator: %v", err)
+	}
+	anything, err := myamqp.NewJSONSchemaValidator([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("NewJSONSchemaValidator: %v", err)
+	}
+	validation := myamqp.NewMessageValidation().
+		WithValidator("orders", myamqp.AnyRoutingKey, validator).
+		WithValidator("orders", "orders.imported", anything)
+
+	tests := []struct {
+		name       string
+		exchange   string
+		routingKey string
+		body       string
+		wantErr    error
+	}{
+		{name: "valid", exchange: "orders", routingKey: "orders.created", body: `{"id":1}`},
+		{name: "missing id", exchange: "orders", routingKey: "orders.created", body: `{}`, wantErr: myamqp.ErrInvalidMessage},
+		{name: "large integer", exchange: "orders", routingKey: "orders.created", body: `{"id":12345678901234567890}`},
+		{name: "not json", exchange: "orders", routingKey: "orders.created", body: `not json`, wantErr: myamqp.ErrInvalidMessage},
+		{name: "exact routing key first", exchange: "orders", routingKey: "orders.imported", body: `{}`},
+		{name: "no validator", exchange: "invoices", routingKey: "orders.created", body: `not json`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validation.Validate(tt.exchange, tt.routingKey, []byte(tt.body)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err = myamqp.NewJSONSchemaValidator([]byte(`{"type":`)); err == nil {
+		t.Error("NewJSONSchemaValidator: invalid schema compiled")
+	}
+}
+
+func TestProducerValidation(t *testing.T) {
+	broker := newOrdersBroker(t)
+	amqp := connect(t, broker)
+
+	producer, err := amqp.Producer(myamqp.NewProducerOptions(
+		myamqp.NewExchangeOptions("orders", amqp091.ExchangeDirect).WithDurable(true),
+	).WithValidation(newOrderValidation(t)))
+	if err != nil {
+		t.Fatalf("Producer: %v", err)
+	}
+	defer producer.Close()
+
+	ctx := context.Background()
+	if err = producer.Publish(ctx, "orders.created", false, false, amqp091.Publishing{Body: []byte(`{}`)}); !errors.Is(err, myamqp.ErrInvalidMessage) {
+		t.Errorf("Publish invalid: got error %v, want %v", err, myamqp.ErrInvalidMessage)
+	}
+	if err = producer.Publish(ctx, "orders.created", false, false, amqp091.Publishing{Body: []byte(`{"id":1}`)}); err != nil {
+		t.Errorf("Publish valid: %v", err)
+	}
+
+	waitFor(t, "the valid message", func() bool { return len(broker.Messages("orders.created")) == 1 })
+	if body := string(broker.Messages("orders.created")[0].Body); body != `{"id":1}` {
+		t.Errorf("got body %s, want the valid message only", body)
+	}
+}
+
+func TestConsumerValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		// deadLetter is the exchange of the DeadLetter, none when empty.
+		deadLetter string
+		// wantDeadLettered is the number of invalid deliveries in the invalid queue.
+		wantDeadLettered int
+	}{
+		{name: "rejected without dead letter"},
+		{name: "dead-lettered", deadLetter: "orders.invalid", wantDeadLettered: 1},
+		{name: "rejected when the dead letter fails", deadLetter: "missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newOrdersBroker(t)
+			broker.DeclareExchange("orders.invalid", amqp091.ExchangeFanout)
+			if err := broker.DeclareQueue("invalid", "orders.invalid", "", nil); err != nil {
+				t.Fatalf("DeclareQueue: %v", err)
+			}
+			amqp := connect(t, broker)
+
+			validation := newOrderValidation(t)
+			if tt.deadLetter != "" {
+				validation = validation.WithDeadLetter(myamqp.NewDeadLetter(tt.deadLetter, ""))
+			}
+			deliveries := consume(t, amqp, myamqp.NewQueueConsumerOptions("orders",
+				myamqp.NewQueueOptions("orders.created").WithDeclareMode(myamqp.DeclareModePassive),
+			).WithValidation(validation))
+
+			for _, body := range []string{`{}`, `{"id":1}`} {
+				if err := broker.Publish("orders", "orders.created", amqp091.Publishing{MessageId: body, Body: []byte(body)}); err != nil {
+					t.Fatalf("Publish: %v", err)
+				}
+			}
+
+			// The invalid delivery never reaches the handler, nor is it redelivered.
+			d := receive(t, deliveries)
+			if string(d.Body) != `{"id":1}` {
+				t.Fatalf("got body %s, want the valid delivery", d.Body)
+			}
+			d.Ack(false)
+			waitFor(t, "the invalid delivery to be settled", func() bool {
+				info, _ := broker.Queue("orders.created")
+				return info.Messages == 0 && info.Unacked == 0
+			})
+
+			deadLettered := broker.Messages("invalid")
+			if len(deadLettered) != tt.wantDeadLettered {
+				t.Fatalf("got %d dead-lettered messages, want %d", len(deadLettered), tt.wantDeadLettered)
+			}
+			if tt.wantDeadLettered > 0 {
+				dl := deadLettered[0]
+				if _, ok := dl.Headers[myamqp.ValidationErrorHeader].(string); !ok || dl.MessageId != `{}` ||
+					dl.RoutingKey != "orders.created" || string(dl.Body) != `{}` {
+					t.Errorf("unexpected dead letter %+v", dl)
+				}
+			}
+		})
+	}
+}